@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/ecdh"
 	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"maps"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/karadia10/mycelium-mesh/internal/agent"
@@ -18,8 +26,21 @@ import (
 	"github.com/karadia10/mycelium-mesh/internal/fabric"
 	"github.com/karadia10/mycelium-mesh/internal/repo"
 	"github.com/karadia10/mycelium-mesh/internal/spore"
+	"github.com/karadia10/mycelium-mesh/internal/swarm"
 )
 
+// stringList is a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -36,6 +57,28 @@ func main() {
 		publishCommand()
 	case "run":
 		runCommand()
+	case "keygen":
+		keygenCommand()
+	case "sign":
+		signCommand()
+	case "encrypt":
+		encryptCommand()
+	case "trust":
+		trustCommand()
+	case "validate":
+		validateCommand()
+	case "inspect":
+		inspectCommand()
+	case "verify":
+		verifyCommand()
+	case "revoke":
+		revokeCommand()
+	case "tag":
+		tagCommand()
+	case "tags":
+		tagsCommand()
+	case "repo":
+		repoCommand()
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
@@ -49,75 +92,300 @@ func printUsage() {
 	fmt.Println("  build    - Build a spore from binary and manifest")
 	fmt.Println("  publish  - Publish a spore to repository")
 	fmt.Println("  run      - Run the mesh with edge and agents")
+	fmt.Println("  keygen   - Generate a signing key pair")
+	fmt.Println("  sign     - Add a co-signature to an existing spore")
+	fmt.Println("  encrypt  - Encrypt an existing spore's binary to node keys")
+	fmt.Println("  trust    - Manage trusted publisher keys (add, list, remove, threshold)")
+	fmt.Println("  validate - Check a manifest or spore against the manifest schema")
+	fmt.Println("  inspect  - Show a spore's manifest, files and signers")
+	fmt.Println("  verify   - Verify a spore against trusted keys")
+	fmt.Println("  revoke   - Revoke spores by digest or signing key in the repository")
+	fmt.Println("  tag      - Point a name:tag reference at a published spore")
+	fmt.Println("  tags     - List the repository's tags")
+	fmt.Println("  repo     - Maintain, serve or mirror the repository (gc, serve, fsck, mirror)")
 	fmt.Println("")
 	fmt.Println("Use 'mesh <command> -h' for command-specific help")
 }
 
 func buildCommand() {
 	var (
-		manifestPath = flag.String("manifest", "", "Path to manifest JSON file")
-		binaryPath   = flag.String("binary", "", "Path to binary file")
-		outDir       = flag.String("out", "./out", "Output directory for spore")
-		keyPath      = flag.String("key", "", "Path to private key file (generates new if not provided)")
+		manifestPath    = flag.String("manifest", "", "Path to manifest JSON file")
+		outDir          = flag.String("out", "./out", "Output directory for spore")
+		keyPath         = flag.String("key", "", "Path to private key file (see mesh keygen)")
+		passphraseFile  = flag.String("passphrase-file", "", "File holding the private key passphrase (default $MESH_KEY_PASSPHRASE)")
+		endorsementPath = flag.String("endorsement", "", "Key rotation endorsement to stamp into the manifest")
+		filesDir        = flag.String("dir", "", "Directory tree to ship alongside the binary")
+		attest          = flag.Bool("attest", true, "Attach provenance and SBOM attestations read from the Go binary's build info")
+		validFor        = flag.Duration("valid-for", 0, "Sign the spore as valid for this long after its creation time (default forever)")
+		env             = flag.String("env", "", "Environment overlay to apply, read from <manifest>.<env>.json next to the manifest")
+		binaries        stringList
+		includes        stringList
+		overlays        stringList
+		vars            stringList
+		recipients      stringList
 	)
+	flag.Var(&recipients, "recipient", "Node public key to encrypt the binary to (repeatable, see mesh keygen -type node)")
+	flag.Var(&binaries, "binary", "Path to binary file, or os/arch=path for each platform of a multi-arch spore (repeatable)")
+	flag.Var(&includes, "include", "Extra file or directory to ship alongside the binary (repeatable)")
+	flag.Var(&overlays, "overlay", "Extra JSON merge-patch overlay applied after the -env overlay (repeatable)")
+	flag.Var(&vars, "var", "NAME=VALUE substituted for ${NAME} in the manifest (repeatable)")
 	flag.Parse()
 
-	if *manifestPath == "" || *binaryPath == "" {
-		fmt.Println("Error: -manifest and -binary are required")
+	if *manifestPath == "" || len(binaries) == 0 || *keyPath == "" {
+		fmt.Println("Error: -manifest, -binary and -key are required (create a key with mesh keygen)")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// Read manifest
-	manifestData, err := os.ReadFile(*manifestPath)
+	// Read the manifest, applying overlays and variables, and validate it
+	manifest, err := loadBuildManifest(*manifestPath, *env, overlays, vars)
+	if err != nil {
+		log.Fatalf("Failed to load manifest: %v", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Load private key
+	privKey, err := loadPrivateKey(*keyPath, *passphraseFile)
 	if err != nil {
-		log.Fatalf("Failed to read manifest: %v", err)
+		log.Fatalf("Failed to load private key: %v", err)
 	}
 
-	var manifest spore.Manifest
-	if err := json.Unmarshal(manifestData, &manifest); err != nil {
-		log.Fatalf("Failed to parse manifest: %v", err)
+	// Stamp a key rotation endorsement into the manifest
+	if *endorsementPath != "" {
+		data, err := os.ReadFile(*endorsementPath)
+		if err != nil {
+			log.Fatalf("Failed to read endorsement: %v", err)
+		}
+		var endorsement spore.Endorsement
+		if err := json.Unmarshal(data, &endorsement); err != nil {
+			log.Fatalf("Failed to parse endorsement: %v", err)
+		}
+		manifest.Endorsement = &endorsement
 	}
 
-	// Generate or load private key
-	var privKey ed25519.PrivateKey
-	if *keyPath != "" {
-		keyData, err := os.ReadFile(*keyPath)
+	// Collect extra files
+	var files []spore.File
+	if *filesDir != "" {
+		dirFiles, err := spore.DirFiles(*filesDir, "")
 		if err != nil {
-			log.Fatalf("Failed to read key file: %v", err)
+			log.Fatalf("Failed to read directory: %v", err)
 		}
-		privKey = ed25519.PrivateKey(keyData)
-	} else {
-		_, privKey, err = ed25519.GenerateKey(nil)
+		files = append(files, dirFiles...)
+	}
+	for _, include := range includes {
+		includeFiles, err := includeFiles(include)
+		if err != nil {
+			log.Fatalf("Failed to include %s: %v", include, err)
+		}
+		files = append(files, includeFiles...)
+	}
+
+	// Stamp $SOURCE_DATE_EPOCH rather than the current time, if set, so
+	// rebuilding the same inputs yields the same spore digest
+	createdAt, err := spore.SourceDateEpoch()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if !createdAt.IsZero() {
+		log.Printf("Reproducible build at SOURCE_DATE_EPOCH %d", createdAt.Unix())
+	}
+
+	// Limit how long the signature is valid
+	if *validFor > 0 {
+		start := createdAt
+		if start.IsZero() {
+			start = time.Now().UTC().Truncate(time.Second)
+		}
+		manifest.NotBefore = start
+		manifest.NotAfter = start.Add(*validFor)
+	}
+
+	// Encrypt the binary to node keys
+	payload := spore.Payload{Files: files, CreatedAt: createdAt}
+	payload.Recipients, err = loadNodePublicKeys(recipients)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Open the binary, or one binary per platform
+	for _, arg := range binaries {
+		platform, binaryPath, multiArch := strings.Cut(arg, "=")
+		if !multiArch {
+			platform, binaryPath = "", arg
+		}
+		if (platform == "") != (len(binaries) == 1 && !multiArch) {
+			log.Fatalf("Use a single -binary path, or os/arch=path for every -binary")
+		}
+
+		binaryFile, err := os.Open(binaryPath)
 		if err != nil {
-			log.Fatalf("Failed to generate key: %v", err)
+			log.Fatalf("Failed to open binary: %v", err)
 		}
-		// Save key for future use
-		keyPath := filepath.Join(*outDir, "private.key")
-		if err := os.MkdirAll(*outDir, 0755); err != nil {
-			log.Fatalf("Failed to create output directory: %v", err)
+		defer binaryFile.Close()
+
+		if platform == "" {
+			payload.Binary = binaryFile
+		} else {
+			payload.Binaries = append(payload.Binaries, spore.PlatformBinary{Platform: platform, Binary: binaryFile})
 		}
-		if err := os.WriteFile(keyPath, privKey, 0600); err != nil {
-			log.Fatalf("Failed to save private key: %v", err)
+
+		// Attest to how the binary was built; scripts have no Go build info
+		if *attest && !manifest.IsScript() {
+			attestations, err := spore.GoAttestations(binaryPath, platform)
+			if err != nil {
+				log.Printf("Warning: no attestations attached for %s: %v", binaryPath, err)
+			}
+			payload.Attestations = append(payload.Attestations, attestations...)
 		}
-		log.Printf("Generated private key saved to %s", keyPath)
 	}
 
 	// Pack spore
-	sporePath, finalManifest, err := spore.Pack(*binaryPath, manifest, privKey, *outDir)
+	sporePath, finalManifest, err := spore.PackPayload(payload, *manifest, privKey, *outDir)
 	if err != nil {
 		log.Fatalf("Failed to pack spore: %v", err)
 	}
 
 	log.Printf("Spore created: %s", sporePath)
 	log.Printf("Manifest: %+v", finalManifest)
+	log.Printf("Signer: %s (public key %s)", finalManifest.KeyID, finalManifest.PublicKey)
+}
+
+// loadNodePublicKeys reads node public key files
+func loadNodePublicKeys(paths []string) ([]*ecdh.PublicKey, error) {
+	var keys []*ecdh.PublicKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read node key: %w", err)
+		}
+		pub, err := spore.ParseNodePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse node key %s: %w", path, err)
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+// loadBuildManifest reads a manifest for mesh build. With an environment,
+// overlays or variables it composes the base manifest with them, recording
+// the overlays in the manifest.
+func loadBuildManifest(path, env string, overlayPaths, vars []string) (*spore.Manifest, error) {
+	if env == "" && len(overlayPaths) == 0 && len(vars) == 0 {
+		return spore.LoadManifest(path)
+	}
+
+	base, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if env != "" {
+		envPath := strings.TrimSuffix(path, filepath.Ext(path)) + "." + env + ".json"
+		overlayPaths = append([]string{envPath}, overlayPaths...)
+	}
+	var overlays []spore.Overlay
+	for _, p := range overlayPaths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read overlay: %w", err)
+		}
+		overlays = append(overlays, spore.Overlay{Name: filepath.Base(p), Data: data})
+	}
+
+	values := make(map[string]string)
+	for _, v := range vars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -var %q, expected NAME=VALUE", v)
+		}
+		values[name] = value
+	}
+
+	return spore.ComposeManifest(base, env, overlays, values)
+}
+
+// includeFiles returns the files to pack for an -include path: a file is
+// packed under its base name, a directory as a tree under its base name
+func includeFiles(path string) ([]spore.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(filepath.Clean(path))
+	if info.IsDir() {
+		return spore.DirFiles(path, name)
+	}
+
+	f, err := spore.OSFile(path, name)
+	if err != nil {
+		return nil, err
+	}
+	return []spore.File{f}, nil
+}
+
+func validateCommand() {
+	var (
+		manifestPath = flag.String("manifest", "", "Path to manifest JSON file")
+		sporePath    = flag.String("spore", "", "Path to spore file")
+		env          = flag.String("env", "", "Environment overlay to apply to -manifest, as in mesh build")
+		overlays     stringList
+		vars         stringList
+	)
+	flag.Var(&overlays, "overlay", "Extra JSON merge-patch overlay to apply to -manifest (repeatable)")
+	flag.Var(&vars, "var", "NAME=VALUE substituted for ${NAME} in -manifest (repeatable)")
+	flag.Parse()
+
+	if (*manifestPath == "") == (*sporePath == "") {
+		fmt.Println("Error: exactly one of -manifest or -spore is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var (
+		manifest *spore.Manifest
+		source   = *manifestPath
+		err      error
+	)
+	if *manifestPath != "" {
+		manifest, err = loadBuildManifest(*manifestPath, *env, overlays, vars)
+	} else {
+		// Check the spore's integrity, then validate an upgraded copy of its manifest
+		source = *sporePath
+		manifest, err = spore.Verify(*sporePath, nil)
+		if err == nil {
+			err = spore.Migrate(manifest)
+		}
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", source, err)
+	}
+
+	if err := manifest.Validate(); err != nil {
+		var errs spore.ValidationErrors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Printf("%s: %v\n", source, e)
+			}
+			os.Exit(1)
+		}
+		log.Fatalf("%s: %v", source, err)
+	}
+
+	fmt.Printf("%s: valid %s manifest for %s %s\n", source, manifest.APIVersion, manifest.Name, manifest.Version)
 }
 
 func publishCommand() {
 	var (
-		sporePath = flag.String("spore", "", "Path to spore file")
-		repoDir   = flag.String("repo", "./repo", "Repository directory")
+		sporePath  = flag.String("spore", "", "Path to spore file")
+		repoDir    = flag.String("repo", "./repo", "Repository directory or repo server URL")
+		policyPath = flag.String("policy", "", "Publish policy file for a repository directory (default <repo>/policy.json; repo servers apply their own)")
+		tags       stringList
 	)
+	flag.Var(&tags, "tag", "Extra tag to point at the spore, e.g. latest (repeatable; name:version is always tagged)")
 	flag.Parse()
 
 	if *sporePath == "" {
@@ -127,46 +395,150 @@ func publishCommand() {
 	}
 
 	// Open repository
-	repo, err := repo.Open(*repoDir)
+	r, err := openRepo(*repoDir, true)
 	if err != nil {
 		log.Fatalf("Failed to open repository: %v", err)
 	}
 
+	if local, ok := r.(*repo.Repo); ok {
+		if *policyPath == "" {
+			*policyPath = local.PolicyPath()
+		}
+		if local.Policy, err = repo.LoadPublishPolicy(*policyPath); err != nil {
+			log.Fatalf("Failed to load publish policy: %v", err)
+		}
+	}
+
 	// Publish spore
-	digest, storedPath, err := repo.Put(*sporePath)
+	digest, err := r.Put(*sporePath)
+	var policyErr *repo.PublishPolicyError
+	if errors.As(err, &policyErr) {
+		log.Printf("Spore refused by the repository's publish policy:")
+		for _, v := range policyErr.Violations {
+			log.Printf("  %s: %s", v.Rule, v.Detail)
+		}
+		os.Exit(1)
+	}
 	if err != nil {
 		log.Fatalf("Failed to publish spore: %v", err)
 	}
 
+	insp, err := spore.Inspect(*sporePath)
+	if err != nil {
+		log.Fatalf("Failed to read published spore: %v", err)
+	}
+	refs := []string{insp.Manifest.Name + ":" + insp.Manifest.Version}
+	for _, tag := range tags {
+		ref := insp.Manifest.Name + ":" + tag
+		if err := r.Tag(ref, digest); err != nil {
+			log.Fatalf("Failed to tag spore: %v", err)
+		}
+		refs = append(refs, ref)
+	}
+
 	log.Printf("Spore published successfully")
 	log.Printf("Digest: %s", digest)
-	log.Printf("Stored at: %s", storedPath)
+	log.Printf("Tags: %s", strings.Join(refs, ", "))
+	log.Printf("Stored in: %s", *repoDir)
 }
 
 func runCommand() {
 	var (
-		repoDir   = flag.String("repo", "./repo", "Repository directory")
-		digest    = flag.String("digest", "", "Spore digest to run")
-		appName   = flag.String("app", "", "App name")
-		instances = flag.Int("instances", 2, "Number of instances to run")
-		edgeAddr  = flag.String("edge", ":8080", "Edge server address")
-		nodes     = flag.Int("nodes", 3, "Number of agent nodes")
-		warmup    = flag.Duration("warmup", 2*time.Second, "Blue/green warmup duration")
+		repoDir     = flag.String("repo", "./repo", "Repository directory or repo server URL")
+		cacheDir    = flag.String("cache", defaultCacheDir(), "Where spores downloaded from a repo server are cached")
+		sporeRef    = flag.String("spore", "", "Spore to run, by digest, digest prefix or name:tag (e.g. billing:v0.1.0)")
+		digest      = flag.String("digest", "", "Spore digest to run (same as -spore)")
+		appName     = flag.String("app", "", "App name (default the spore's app)")
+		instances   = flag.Int("instances", 2, "Number of instances to run")
+		edgeAddr    = flag.String("edge", ":8080", "Edge server address")
+		nodes       = flag.Int("nodes", 3, "Number of agent nodes")
+		warmup      = flag.Duration("warmup", 2*time.Second, "Blue/green warmup duration")
+		keyring     = flag.String("keyring", "", "Path to trusted publisher keyring (required unless -insecure-any-signer)")
+		anySigner   = flag.Bool("insecure-any-signer", false, "Run without a keyring, trusting any validly signed spore")
+		cleanTree   = flag.Bool("require-clean-tree", false, "Only run binaries whose provenance shows an unmodified VCS revision")
+		needProv    = flag.Bool("require-provenance", false, "Only run spores carrying a provenance attestation")
+		stopRevoked = flag.Bool("stop-revoked", false, "Stop running instances of spores revoked in the repository, rather than only reporting them")
+		nodeKeyPath = flag.String("node-key", "", "Node key that decrypts encrypted spores (see mesh keygen -type node)")
+		p2p         = flag.Bool("p2p", false, "Let agents fetch spore chunks from each other, falling back to the repository")
 	)
 	flag.Parse()
 
-	if *digest == "" || *appName == "" {
-		fmt.Println("Error: -digest and -app are required")
+	if *sporeRef == "" {
+		sporeRef = digest
+	}
+	if *sporeRef == "" {
+		fmt.Println("Error: -spore is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *keyring == "" && !*anySigner {
+		fmt.Println("Error: -keyring is required; pass -insecure-any-signer to trust any validly signed spore")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *anySigner {
+		if *keyring != "" {
+			fmt.Println("Error: -keyring and -insecure-any-signer are mutually exclusive")
+			os.Exit(1)
+		}
+		log.Printf("Warning: running without a keyring, any validly signed spore will be sprouted")
+	}
 
 	// Open repository
-	repo, err := repo.Open(*repoDir)
+	r, err := openRepoCache(*repoDir, *cacheDir, true)
 	if err != nil {
 		log.Fatalf("Failed to open repository: %v", err)
 	}
 
+	// Resolve tags to the digest agents pull
+	*digest, err = r.Resolve(*sporeRef)
+	if err != nil {
+		log.Fatalf("Failed to resolve spore: %v", err)
+	}
+	if *appName == "" {
+		blob, err := r.Open(*digest)
+		if err != nil {
+			log.Fatalf("Failed to fetch spore %s: %v", *digest, err)
+		}
+		insp, err := spore.InspectReader(blob, blob.Size())
+		blob.Close()
+		if err != nil {
+			log.Fatalf("Failed to read spore %s: %v", *digest, err)
+		}
+		*appName = insp.Manifest.Name
+	}
+
+	// Load trusted publishers
+	var kr *spore.Keyring
+	if *keyring != "" {
+		kr, err = spore.LoadKeyring(*keyring)
+		if err != nil {
+			log.Fatalf("Failed to load keyring: %v", err)
+		}
+		if len(kr.Keys) == 0 {
+			log.Printf("Warning: keyring %s has no keys, every spore will be rejected", *keyring)
+		}
+	}
+
+	// Node key for encrypted spores
+	var nodeKey *ecdh.PrivateKey
+	if *nodeKeyPath != "" {
+		data, err := os.ReadFile(*nodeKeyPath)
+		if err != nil {
+			log.Fatalf("Failed to read node key: %v", err)
+		}
+		nodeKey, err = spore.ParseNodePrivateKey(data)
+		if err != nil {
+			log.Fatalf("Failed to parse node key: %v", err)
+		}
+	}
+
+	// Attestation policy
+	var policy *spore.Policy
+	if *cleanTree || *needProv {
+		policy = &spore.Policy{RequireProvenance: *needProv, RequireCleanTree: *cleanTree}
+	}
+
 	// Create fabric
 	fab := fabric.New()
 
@@ -196,8 +568,22 @@ func runCommand() {
 		agentID := fmt.Sprintf("node-%d", i+1)
 		runDir := filepath.Join("./run", agentID)
 
-		ag := agent.New(agentID, fab, repo, runDir)
+		var source repo.Source = r
+		if *p2p {
+			sw, err := joinSwarm(agentID, fab, r, runDir)
+			if err != nil {
+				log.Fatalf("Failed to start chunk server for %s: %v", agentID, err)
+			}
+			defer sw.Leave()
+			source = sw
+		}
+
+		ag := agent.New(agentID, fab, source, runDir)
 		ag.Warmup = *warmup
+		ag.Keyring = kr
+		ag.Policy = policy
+		ag.StopRevoked = *stopRevoked
+		ag.NodeKey = nodeKey
 
 		go ag.Start(ctx)
 	}
@@ -211,6 +597,27 @@ func runCommand() {
 		Port:    0, // Let agents choose ports
 	}
 
+	// Pin the plan's digest against repo garbage collection while it is live
+	planHolder := "plan-" + *appName
+	if err := r.Lease(plan.Digest, planHolder); err != nil {
+		log.Fatalf("Failed to lease spore: %v", err)
+	}
+	defer r.Release(plan.Digest, planHolder)
+	go func() {
+		ticker := time.NewTicker(repo.LeaseTTL / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Lease(plan.Digest, planHolder); err != nil {
+					log.Printf("Failed to renew lease on spore: %v", err)
+				}
+			}
+		}
+	}()
+
 	log.Printf("Publishing plan: %+v", plan)
 	fab.PublishPlan(plan)
 
@@ -227,3 +634,1137 @@ func runCommand() {
 	cancel()
 	time.Sleep(1 * time.Second)
 }
+
+// joinSwarm starts a node's chunk server and joins it to the fabric, caching
+// the spores it fetches in the node's run directory
+func joinSwarm(nodeID string, fab *fabric.Fabric, origin swarm.Origin, runDir string) (*swarm.Swarm, error) {
+	cacheDir := filepath.Join(runDir, "spores")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spore cache: %w", err)
+	}
+	sw := swarm.New(nodeID, fab, origin, &repo.FileStore{Dir: cacheDir})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(ln, sw)
+
+	if err := sw.Join("http://" + ln.Addr().String()); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return sw, nil
+}
+
+func keygenCommand() {
+	var (
+		outPath        = flag.String("out", "./keys/signing.key", "Path for the private key (public key is written to <out>.pub)")
+		format         = flag.String("format", "pem", "Key format: pem, openssh or raw")
+		passphraseFile = flag.String("passphrase-file", "", "File holding a passphrase to encrypt the private key (pem only, as encrypted PKCS#8)")
+		rotateFrom     = flag.String("rotate-from", "", "Previous private key that endorses the new key")
+		prevPassFile   = flag.String("rotate-passphrase-file", "", "File holding the previous key's passphrase")
+		keyType        = flag.String("type", "signing", "Key type: signing (ed25519) or node (X25519, for encrypted spores)")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh keygen [flags]")
+		fmt.Fprintln(flag.CommandLine.Output(), "Encrypted keys are standard encrypted PKCS#8 (PBES2, PBKDF2-HMAC-SHA256, AES-256-CBC), which openssl reads.")
+		fmt.Fprintln(flag.CommandLine.Output(), "OpenSSH keys cannot be encrypted, and passphrase-protected OpenSSH keys are not read: remove the")
+		fmt.Fprintln(flag.CommandLine.Output(), "passphrase with ssh-keygen -p -N '' or use -format pem.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch *keyType {
+	case "signing":
+	case "node":
+		if *format != "pem" || *passphraseFile != "" || *rotateFrom != "" {
+			log.Fatalf("Node keys are unencrypted PEM and cannot be rotated")
+		}
+		nodeKeygen(*outPath)
+		return
+	default:
+		log.Fatalf("Unknown key type %q", *keyType)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+
+	var passphrase []byte
+	if *passphraseFile != "" {
+		passphrase, err = readPassphrase(*passphraseFile)
+		if err != nil {
+			log.Fatalf("Failed to read passphrase: %v", err)
+		}
+	}
+
+	privData, err := spore.MarshalPrivateKey(priv, spore.KeyFormat(*format), passphrase)
+	if err != nil {
+		log.Fatalf("Failed to encode private key: %v", err)
+	}
+	pubData, err := spore.MarshalPublicKey(pub, spore.KeyFormat(*format))
+	if err != nil {
+		log.Fatalf("Failed to encode public key: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outPath), 0755); err != nil {
+		log.Fatalf("Failed to create key directory: %v", err)
+	}
+	if err := os.WriteFile(*outPath, privData, 0600); err != nil {
+		log.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(*outPath+".pub", pubData, 0644); err != nil {
+		log.Fatalf("Failed to write public key: %v", err)
+	}
+
+	log.Printf("Private key: %s", *outPath)
+	log.Printf("Public key: %s.pub", *outPath)
+	log.Printf("Key ID: %s", spore.Fingerprint(pub))
+
+	// Have the previous key vouch for the new one
+	if *rotateFrom != "" {
+		prevKey, err := loadPrivateKey(*rotateFrom, *prevPassFile)
+		if err != nil {
+			log.Fatalf("Failed to load previous key: %v", err)
+		}
+
+		endorsement, err := json.MarshalIndent(spore.Endorse(prevKey, pub), "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode endorsement: %v", err)
+		}
+		if err := os.WriteFile(*outPath+".endorsement.json", endorsement, 0644); err != nil {
+			log.Fatalf("Failed to write endorsement: %v", err)
+		}
+
+		log.Printf("Endorsement by %s: %s.endorsement.json (pass to mesh build -endorsement)", spore.Fingerprint(prevKey.Public().(ed25519.PublicKey)), *outPath)
+	}
+}
+
+// nodeKeygen writes a new X25519 node key to outPath and its public key to
+// outPath.pub
+func nodeKeygen(outPath string) {
+	priv, err := spore.GenerateNodeKey()
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	privData, err := spore.MarshalNodePrivateKey(priv)
+	if err != nil {
+		log.Fatalf("Failed to encode private key: %v", err)
+	}
+	pubData, err := spore.MarshalNodePublicKey(priv.PublicKey())
+	if err != nil {
+		log.Fatalf("Failed to encode public key: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		log.Fatalf("Failed to create key directory: %v", err)
+	}
+	if err := os.WriteFile(outPath, privData, 0600); err != nil {
+		log.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(outPath+".pub", pubData, 0644); err != nil {
+		log.Fatalf("Failed to write public key: %v", err)
+	}
+
+	log.Printf("Node key: %s", outPath)
+	log.Printf("Public key: %s.pub (pass to mesh build -recipient)", outPath)
+	log.Printf("Key ID: %s", spore.NodeKeyID(priv.PublicKey()))
+}
+
+// loadPrivateKey reads a private key in any supported format. The passphrase,
+// if needed, comes from passphraseFile or $MESH_KEY_PASSPHRASE.
+func loadPrivateKey(path, passphraseFile string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	passphrase := []byte(os.Getenv("MESH_KEY_PASSPHRASE"))
+	if passphraseFile != "" {
+		passphrase, err = readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return spore.ParsePrivateKey(data, passphrase)
+}
+
+// readPassphrase reads a passphrase file, dropping the trailing newline
+func readPassphrase(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+func signCommand() {
+	var (
+		sporePath      = flag.String("spore", "", "Path to spore file")
+		keyPath        = flag.String("key", "", "Path to private key file")
+		passphraseFile = flag.String("passphrase-file", "", "File holding the private key passphrase (default $MESH_KEY_PASSPHRASE)")
+	)
+	flag.Parse()
+
+	if *sporePath == "" || *keyPath == "" {
+		fmt.Println("Error: -spore and -key are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	privKey, err := loadPrivateKey(*keyPath, *passphraseFile)
+	if err != nil {
+		log.Fatalf("Failed to load private key: %v", err)
+	}
+
+	manifest, err := spore.Cosign(*sporePath, privKey)
+	if err != nil {
+		log.Fatalf("Failed to sign spore: %v", err)
+	}
+
+	log.Printf("Co-signed %s with key %s (%d signatures)", *sporePath, spore.Fingerprint(privKey.Public().(ed25519.PublicKey)), 1+len(manifest.Cosignatures))
+}
+
+func encryptCommand() {
+	var (
+		sporePath      = flag.String("spore", "", "Path to spore file")
+		keyPath        = flag.String("key", "", "Private key to sign the encrypted spore with")
+		passphraseFile = flag.String("passphrase-file", "", "File holding the private key passphrase (default $MESH_KEY_PASSPHRASE)")
+		recipients     stringList
+	)
+	flag.Var(&recipients, "recipient", "Node public key to encrypt the binary to (repeatable)")
+	flag.Parse()
+
+	if *sporePath == "" || *keyPath == "" || len(recipients) == 0 {
+		fmt.Println("Error: -spore, -key and at least one -recipient are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	privKey, err := loadPrivateKey(*keyPath, *passphraseFile)
+	if err != nil {
+		log.Fatalf("Failed to load private key: %v", err)
+	}
+	keys, err := loadNodePublicKeys(recipients)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	manifest, err := spore.Encrypt(*sporePath, privKey, keys)
+	if err != nil {
+		log.Fatalf("Failed to encrypt spore: %v", err)
+	}
+
+	log.Printf("Encrypted %s to %d node keys and re-signed with key %s", *sporePath, len(manifest.Encryption.Recipients), manifest.KeyID)
+	log.Printf("Co-signatures were dropped; collect them again with mesh sign")
+}
+
+func inspectCommand() {
+	var (
+		repoDir = flag.String("repo", "./repo", "Repository directory or repo server URL for digests and tags")
+		jsonOut = flag.Bool("json", false, "Print JSON instead of text")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh inspect [flags] <spore file|digest|name:tag>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	blob, err := openSpore(flag.Arg(0), *repoDir)
+	if err != nil {
+		log.Fatalf("Failed to resolve spore: %v", err)
+	}
+	defer blob.Close()
+	insp, err := spore.InspectReader(blob, blob.Size())
+	if err != nil {
+		log.Fatalf("Failed to inspect spore: %v", err)
+	}
+
+	if *jsonOut {
+		printJSON(insp)
+		return
+	}
+
+	m := insp.Manifest
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", m.Name)
+	fmt.Fprintf(tw, "Version:\t%s\n", m.Version)
+	apiVersion := m.APIVersion
+	if apiVersion == "" {
+		apiVersion = "none (predates versioning)"
+	}
+	fmt.Fprintf(tw, "API version:\t%s\n", apiVersion)
+	fmt.Fprintf(tw, "Digest:\t%s\n", insp.Digest)
+	fmt.Fprintf(tw, "Size:\t%d bytes\n", insp.Size)
+	fmt.Fprintf(tw, "Created:\t%s\n", insp.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Command:\t%s\n", strings.Join(append([]string{m.Command}, m.Args...), " "))
+	if o := m.Overlay; o != nil {
+		var names []string
+		for _, src := range o.Overlays {
+			names = append(names, src.Name)
+		}
+		fmt.Fprintf(tw, "Overlay:\tenv %q (%s)\n", o.Env, strings.Join(names, ", "))
+	}
+	if e := m.Encryption; e != nil {
+		var keyIDs []string
+		for _, r := range e.Recipients {
+			keyIDs = append(keyIDs, r.KeyID)
+		}
+		fmt.Fprintf(tw, "Encrypted:\t%s, plaintext sha256 %s, to %s\n", e.Algorithm, e.PlaintextSHA256, strings.Join(keyIDs, ", "))
+	}
+	if m.IsScript() {
+		fmt.Fprintf(tw, "Runtime:\tscript, interpreter %s %s\n", m.Runtime.Interpreter, m.Runtime.Version)
+	}
+	if len(m.Binaries) == 0 {
+		fmt.Fprintf(tw, "Binary:\tsha256 %s, %d bytes\n", m.BinarySHA256, m.BinarySize)
+	}
+	for _, b := range m.Binaries {
+		fmt.Fprintf(tw, "Binary %s:\tsha256 %s, %d bytes\n", b.Platform, b.SHA256, b.Size)
+	}
+	fmt.Fprintf(tw, "Nutrients:\t%d millicpu, %d MB\n", m.Nutrients.CPUMilli, m.Nutrients.MemoryMB)
+	if !m.NotBefore.IsZero() || !m.NotAfter.IsZero() {
+		fmt.Fprintf(tw, "Valid:\t%s to %s\n", formatBound(m.NotBefore, "any time"), formatBound(m.NotAfter, "forever"))
+	}
+	tw.Flush()
+
+	fmt.Println("\nSigners:")
+	for _, s := range insp.Signers {
+		role := "primary"
+		if s.Cosigner {
+			role = "cosigner"
+		}
+		if s.EndorsedBy != "" {
+			role += ", endorsed by " + s.EndorsedBy
+		}
+		fmt.Printf("  %s  (%s)\n", s.KeyID, role)
+	}
+
+	if len(m.Attestations) > 0 {
+		fmt.Println("\nAttestations:")
+		for _, a := range m.Attestations {
+			fmt.Printf("  %-12s %s\n", a.Name, a.PredicateType)
+		}
+	}
+
+	if len(m.Files) > 0 {
+		fmt.Println("\nFiles:")
+		tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, f := range m.Files {
+			fmt.Fprintf(tw, "  %04o\t%d\t %s\n", f.Mode, f.Size, f.Path)
+		}
+		tw.Flush()
+	}
+
+	fmt.Println("\nEntries:")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "  SIZE\tCOMPRESSED\t NAME\n")
+	for _, e := range insp.Entries {
+		fmt.Fprintf(tw, "  %d\t%d\t %s\n", e.Size, e.CompressedSize, e.Name)
+	}
+	tw.Flush()
+
+	fmt.Println("\nManifest:")
+	printJSON(m)
+}
+
+// formatBound formats one end of a validity period
+func formatBound(t time.Time, unset string) string {
+	if t.IsZero() {
+		return unset
+	}
+	return t.Format(time.RFC3339)
+}
+
+// verifyResult is the outcome of mesh verify, printed as JSON with -json
+type verifyResult struct {
+	Spore   string   `json:"spore"`
+	OK      bool     `json:"ok"`
+	App     string   `json:"app,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Signers []string `json:"signers,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func verifyCommand() {
+	var (
+		repoDir     = flag.String("repo", "./repo", "Repository directory or repo server URL for digests and tags")
+		keyringPath = flag.String("keyring", "./trust.json", "Path to keyring file")
+		jsonOut     = flag.Bool("json", false, "Print the result as JSON")
+		keys        stringList
+	)
+	flag.Var(&keys, "key", "Public key file to trust for any app, in addition to the keyring (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh verify [flags] <spore file|digest|name:tag>")
+		fmt.Fprintln(flag.CommandLine.Output(), "Exits 1 and reports a reason code if the spore does not verify.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// With -json, failures before verification are reported the same way
+	// as verification failures
+	fail := func(err error) {
+		if *jsonOut {
+			printJSON(verifyResult{Spore: flag.Arg(0), Reason: spore.Reason(err), Error: err.Error()})
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
+
+	kr, err := spore.LoadKeyring(*keyringPath)
+	if err != nil {
+		fail(fmt.Errorf("failed to load keyring: %w", err))
+	}
+	for _, keyPath := range keys {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			fail(fmt.Errorf("failed to read key file: %w", err))
+		}
+		pub, err := spore.ParsePublicKey(data)
+		if err != nil {
+			fail(fmt.Errorf("failed to parse key %s: %w", keyPath, err))
+		}
+		if err := kr.Add(keyPath, pub, nil); err != nil {
+			fail(fmt.Errorf("failed to add key %s: %w", keyPath, err))
+		}
+	}
+	if len(kr.Keys) == 0 {
+		fail(errors.New("no trusted keys: add keys with mesh trust add or pass -key"))
+	}
+
+	blob, err := openSpore(flag.Arg(0), *repoDir)
+	if err != nil {
+		fail(fmt.Errorf("failed to resolve spore: %w", err))
+	}
+	defer blob.Close()
+	result := verifyResult{Spore: flag.Arg(0)}
+	manifest, err := spore.VerifyReader(blob, blob.Size(), kr)
+	if err == nil {
+		err = checkRevoked(blob, manifest, *repoDir, kr)
+	}
+	if err != nil {
+		result.Reason = spore.Reason(err)
+		result.Error = err.Error()
+	} else {
+		result.OK = true
+		result.App = manifest.Name
+		result.Version = manifest.Version
+		result.Signers = append(result.Signers, manifest.KeyID)
+		for _, sig := range manifest.Cosignatures {
+			result.Signers = append(result.Signers, sig.KeyID)
+		}
+	}
+
+	switch {
+	case *jsonOut:
+		printJSON(result)
+	case result.OK:
+		fmt.Printf("OK %s %s %s (signed by %s)\n", result.Spore, result.App, result.Version, strings.Join(result.Signers, ", "))
+	default:
+		fmt.Fprintf(os.Stderr, "FAIL %s %s: %s\n", result.Spore, result.Reason, result.Error)
+	}
+
+	if !result.OK {
+		os.Exit(1)
+	}
+}
+
+// checkRevoked checks a verified spore against the repository's revocation
+// list, if one was published
+func checkRevoked(blob repo.Blob, manifest *spore.Manifest, repoDir string, kr *spore.Keyring) error {
+	r, err := openRepo(repoDir, false)
+	if err != nil {
+		return err
+	}
+	rl, err := r.Revocations()
+	if err != nil {
+		return err
+	}
+	if err := rl.Verify(kr); err != nil {
+		return fmt.Errorf("revocation list rejected: %w", err)
+	}
+
+	insp, err := spore.InspectReader(blob, blob.Size())
+	if err != nil {
+		return err
+	}
+	return rl.Check(insp.Digest, manifest)
+}
+
+// openSpore opens a spore given either a file path, or a digest, digest
+// prefix or name:tag reference in the repository
+func openSpore(arg, repoDir string) (repo.Blob, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return repo.OpenFile(arg)
+	}
+	r, err := openRepo(repoDir, false)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := r.Resolve(arg)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(digest)
+}
+
+// sporeRepo is what commands need from a repository, local or remote
+type sporeRepo interface {
+	repo.Source
+	Put(sporePath string) (digest string, err error)
+	ChunkIndex(digest string) (*repo.ChunkIndex, error)
+	ReadChunk(digest string, c repo.Chunk) ([]byte, error)
+	List() ([]repo.Object, error)
+	Resolve(ref string) (string, error)
+	Tag(ref, digest string) error
+	Tags() (map[string]string, error)
+}
+
+// openRepo opens a repository directory, creating it if asked, or a repo
+// server by URL with downloads cached in the default cache directory
+func openRepo(location string, create bool) (sporeRepo, error) {
+	return openRepoCache(location, defaultCacheDir(), create)
+}
+
+// openRepoCache is openRepo with an explicit cache directory
+func openRepoCache(location, cacheDir string, create bool) (sporeRepo, error) {
+	if repo.IsRemote(location) {
+		rm, err := repo.NewRemote(location, cacheDir)
+		if err != nil {
+			return nil, err
+		}
+		// Servers started with a write token need it for publishing and tagging
+		if token := os.Getenv("MESH_REPO_TOKEN"); token != "" {
+			rm.Client = repo.TokenClient(token)
+		}
+		return rm, nil
+	}
+	if create {
+		return repo.Open(location)
+	}
+	return repo.New(location, &repo.FileStore{Dir: location}), nil
+}
+
+// defaultCacheDir is where spores downloaded from repo servers are cached
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(".", "cache")
+	}
+	return filepath.Join(dir, "mesh", "spores")
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal JSON: %v", err)
+	}
+	fmt.Println(string(data))
+}
+
+func revokeCommand() {
+	var (
+		repoDir        = flag.String("repo", "./repo", "Repository directory")
+		keyPath        = flag.String("key", "", "Private key to sign the revocation list (must be trusted for every app)")
+		passphraseFile = flag.String("passphrase-file", "", "File holding the private key passphrase (default $MESH_KEY_PASSPHRASE)")
+		reason         = flag.String("reason", "", "Why the spores are revoked")
+		list           = flag.Bool("list", false, "List current revocations instead of adding one")
+		digests        stringList
+		keyIDs         stringList
+	)
+	flag.Var(&digests, "digest", "Spore digest or name:tag to revoke (repeatable)")
+	flag.Var(&keyIDs, "key-id", "Signing key ID whose spores to revoke (repeatable)")
+	flag.Parse()
+
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		log.Fatalf("Failed to open repository: %v", err)
+	}
+	rl, err := r.Revocations()
+	if err != nil {
+		log.Fatalf("Failed to load revocation list: %v", err)
+	}
+
+	if *list {
+		if rl.Signer != nil {
+			fmt.Printf("Signed by %s at %s\n", rl.Signer.KeyID, rl.IssuedAt.Format(time.RFC3339))
+		}
+		for _, rev := range rl.Digests {
+			fmt.Printf("digest %s  %s  %s\n", rev.ID, rev.RevokedAt.Format(time.RFC3339), rev.Reason)
+		}
+		for _, rev := range rl.Keys {
+			fmt.Printf("key    %s  %s  %s\n", rev.ID, rev.RevokedAt.Format(time.RFC3339), rev.Reason)
+		}
+		return
+	}
+
+	if *keyPath == "" || len(digests)+len(keyIDs) == 0 {
+		fmt.Println("Error: -key and at least one -digest or -key-id are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	privKey, err := loadPrivateKey(*keyPath, *passphraseFile)
+	if err != nil {
+		log.Fatalf("Failed to load private key: %v", err)
+	}
+
+	resolved := make([]string, 0, len(digests))
+	for _, ref := range digests {
+		digest, err := r.Resolve(ref)
+		if err != nil {
+			log.Fatalf("Failed to resolve spore: %v", err)
+		}
+		resolved = append(resolved, digest)
+	}
+
+	// Revoke against the list as it is when the update holds it, so
+	// concurrent revocations are all kept
+	err = r.UpdateRevocations(func(current *spore.RevocationList) error {
+		rl = current
+		for _, digest := range resolved {
+			rl.RevokeDigest(digest, *reason)
+		}
+		for _, keyID := range keyIDs {
+			rl.RevokeKey(keyID, *reason)
+		}
+		if err := rl.Sign(privKey); err != nil {
+			return fmt.Errorf("failed to sign revocation list: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to publish revocation list: %v", err)
+	}
+
+	log.Printf("Revocation list now blocks %d digests and %d keys", len(rl.Digests), len(rl.Keys))
+}
+
+func tagCommand() {
+	var (
+		repoDir = flag.String("repo", "./repo", "Repository directory")
+		remove  = flag.Bool("d", false, "Delete the tag instead of setting it")
+		force   = flag.Bool("f", false, "Move a name:version tag, which is otherwise immutable")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh tag [flags] <name:tag> <digest|name:tag>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       mesh tag -d [flags] <name:tag>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if (*remove && flag.NArg() != 1) || (!*remove && flag.NArg() != 2) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		log.Fatalf("Failed to open repository: %v", err)
+	}
+	ref := flag.Arg(0)
+
+	if *remove {
+		if err := r.Untag(ref); err != nil {
+			log.Fatalf("Failed to delete tag: %v", err)
+		}
+		log.Printf("Deleted tag %s", ref)
+		return
+	}
+
+	digest, err := r.Resolve(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Failed to resolve spore: %v", err)
+	}
+	tag := r.Tag
+	if *force {
+		tag = r.ForceTag
+	}
+	if err := tag(ref, digest); err != nil {
+		log.Fatalf("Failed to tag spore: %v", err)
+	}
+	log.Printf("Tagged %s as %s", digest, ref)
+}
+
+func tagsCommand() {
+	var (
+		repoDir = flag.String("repo", "./repo", "Repository directory or repo server URL")
+		jsonOut = flag.Bool("json", false, "Print JSON instead of text")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh tags [flags] [app]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	r, err := openRepo(*repoDir, false)
+	if err != nil {
+		log.Fatalf("Failed to open repository: %v", err)
+	}
+	tags, err := r.Tags()
+	if err != nil {
+		log.Fatalf("Failed to read tags: %v", err)
+	}
+	if app := flag.Arg(0); app != "" {
+		maps.DeleteFunc(tags, func(ref, _ string) bool {
+			return !strings.HasPrefix(ref, app+":")
+		})
+	}
+
+	if *jsonOut {
+		printJSON(tags)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, ref := range slices.Sorted(maps.Keys(tags)) {
+		fmt.Fprintf(tw, "%s\t%s\n", ref, tags[ref])
+	}
+	tw.Flush()
+}
+
+func repoCommand() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: mesh repo <gc|serve|fsck|mirror> [flags]")
+		os.Exit(1)
+	}
+
+	sub := os.Args[1]
+	os.Args = os.Args[1:]
+
+	switch sub {
+	case "gc":
+		repoGCCommand()
+	case "serve":
+		repoServeCommand()
+	case "fsck":
+		repoFsckCommand()
+	case "mirror":
+		repoMirrorCommand()
+	default:
+		fmt.Printf("Unknown repo command: %s\n", sub)
+		os.Exit(1)
+	}
+}
+
+func repoGCCommand() {
+	var (
+		repoDir    = flag.String("repo", "./repo", "Repository directory")
+		policyPath = flag.String("policy", "", "Retention policy file (default <repo>/retention.json)")
+		dryRun     = flag.Bool("dry-run", false, "Report what would be removed without removing it")
+		jsonOut    = flag.Bool("json", false, "Print the report as JSON")
+	)
+	flag.Parse()
+
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		log.Fatalf("Failed to open repository: %v", err)
+	}
+	if *policyPath == "" {
+		*policyPath = r.RetentionPath()
+	}
+	policy, err := repo.LoadRetentionPolicy(*policyPath)
+	if err != nil {
+		log.Fatalf("Failed to load retention policy: %v", err)
+	}
+
+	report, err := r.GC(policy, *dryRun)
+	if err != nil {
+		log.Fatalf("Garbage collection failed: %v", err)
+	}
+
+	if *jsonOut {
+		printJSON(report)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, e := range report.Kept {
+		fmt.Fprintf(tw, "keep\t%s:%s\t%s\t%s\n", e.App, e.Version, e.Digest, strings.Join(e.Reasons, ", "))
+	}
+	for _, e := range report.Removed {
+		fmt.Fprintf(tw, "remove\t%s:%s\t%s\t%d bytes\n", e.App, e.Version, e.Digest, e.Size)
+	}
+	tw.Flush()
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d spores, freeing %d bytes; kept %d\n", verb, len(report.Removed), report.Freed, len(report.Kept))
+}
+
+func repoServeCommand() {
+	var (
+		repoDir     = flag.String("repo", "./repo", "Repository directory")
+		addr        = flag.String("addr", "127.0.0.1:8090", "Address to listen on; use :8090 to serve other hosts")
+		readOnly    = flag.Bool("read-only", false, "Refuse uploads, deletes and tag changes, as for a mirror")
+		policyPath  = flag.String("policy", "", "Publish policy file checked on upload (default <repo>/policy.json)")
+		tokenFile   = flag.String("token-file", "", "File holding the token uploads and tag changes must carry (default $MESH_REPO_TOKEN)")
+		allowDelete = flag.Bool("allow-delete", false, "Serve DELETE /spores/<digest>")
+		maxUpload   = flag.Int64("max-upload", repo.DefaultMaxUpload, "Largest spore accepted on upload, in bytes")
+	)
+	flag.Parse()
+
+	token := os.Getenv("MESH_REPO_TOKEN")
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			log.Fatalf("Failed to read token file: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		log.Fatalf("Failed to open repository: %v", err)
+	}
+	if *policyPath == "" {
+		*policyPath = r.PolicyPath()
+	}
+	if r.Policy, err = repo.LoadPublishPolicy(*policyPath); err != nil {
+		log.Fatalf("Failed to load publish policy: %v", err)
+	}
+
+	srv := repo.NewServer(r)
+	srv.ReadOnly = *readOnly
+	srv.Token = token
+	srv.AllowDelete = *allowDelete
+	srv.MaxUpload = *maxUpload
+	if host, _, _ := net.SplitHostPort(*addr); token == "" && !*readOnly && host != "127.0.0.1" && host != "localhost" {
+		log.Printf("Repository writes need no token; anyone who can reach %s can publish and move tags", *addr)
+	}
+	log.Printf("Serving repository %s on http://%s", *repoDir, *addr)
+	if err := http.ListenAndServe(*addr, srv); err != nil {
+		log.Fatalf("Repo server failed: %v", err)
+	}
+}
+
+func repoFsckCommand() {
+	var (
+		repoDir    = flag.String("repo", "./repo", "Repository directory")
+		quarantine = flag.Bool("quarantine", false, "Move corrupt objects into <repo>/quarantine")
+		mirrorRepo = flag.String("mirror", "", "Repository directory or repo server URL to fetch corrupt objects from again (implies -quarantine)")
+		jsonOut    = flag.Bool("json", false, "Print the report as JSON")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh repo fsck [flags]")
+		fmt.Fprintln(flag.CommandLine.Output(), "Exits 1 if corrupt objects remain unrepaired.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		log.Fatalf("Failed to open repository: %v", err)
+	}
+	var mirror repo.Source
+	if *mirrorRepo != "" {
+		if mirror, err = openRepo(*mirrorRepo, false); err != nil {
+			log.Fatalf("Failed to open mirror: %v", err)
+		}
+	}
+
+	report, err := r.Fsck(mirror, *quarantine)
+	if err != nil {
+		log.Fatalf("Fsck failed: %v", err)
+	}
+
+	if *jsonOut {
+		printJSON(report)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, issue := range report.Corrupt {
+			fmt.Fprintf(tw, "corrupt\t%s\t%s\t%s\n", issue.Digest, issue.Problem, issue.Detail)
+			if issue.Action != "" {
+				fmt.Fprintf(tw, "\t\t\t%s\n", issue.Action)
+			}
+		}
+		for _, issue := range report.Orphans {
+			fmt.Fprintf(tw, "orphan\t%s\t%s\t%s\n", issue.Path, issue.Problem, issue.Detail)
+		}
+		tw.Flush()
+		fmt.Printf("Checked %d spores: %d corrupt, %d unrepaired, %d orphans\n", report.Checked, len(report.Corrupt), report.Unrepaired, len(report.Orphans))
+	}
+
+	if report.Unrepaired > 0 {
+		os.Exit(1)
+	}
+}
+
+func repoMirrorCommand() {
+	var (
+		repoDir  = flag.String("repo", "./mirror", "Mirror repository directory")
+		from     = flag.String("from", "", "Repository directory or repo server URL to mirror")
+		interval = flag.Duration("interval", time.Minute, "Time between sync passes")
+		once     = flag.Bool("once", false, "Make one sync pass and exit; exits 1 if anything failed to copy")
+		status   = flag.Bool("status", false, "Report lag per tag without syncing")
+		jsonOut  = flag.Bool("json", false, "Print reports as JSON")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mesh repo mirror -from <repo dir|URL> [flags]")
+		fmt.Fprintln(flag.CommandLine.Output(), "Keeps a repository in sync with another; serve it with mesh repo serve -read-only.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *from == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		log.Fatalf("Failed to open mirror: %v", err)
+	}
+	// Downloads resume from the mirror's incoming directory
+	src, err := openRepoCache(*from, r.IncomingDir(), false)
+	if err != nil {
+		log.Fatalf("Failed to open source: %v", err)
+	}
+
+	if *status {
+		st, err := r.MirrorStatus(src, *from)
+		if err != nil {
+			log.Fatalf("Failed to compare with source: %v", err)
+		}
+		printMirrorStatus(st, *jsonOut)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		report, err := r.Mirror(src, *from)
+		switch {
+		case err != nil && *once:
+			log.Fatalf("Mirror pass failed: %v", err)
+		case err != nil:
+			log.Printf("Mirror pass failed: %v", err)
+		case *jsonOut:
+			printJSON(report)
+		default:
+			for _, f := range report.Failed {
+				log.Printf("Failed to mirror %s%s: %s", f.Ref, f.Digest, f.Error)
+			}
+			behind := 0
+			for _, t := range report.Status.Tags {
+				if !t.InSync() {
+					behind++
+				}
+			}
+			log.Printf("Mirrored %s: copied %d spores, %d failed, %d missing, %d of %d tags behind",
+				*from, len(report.Copied), len(report.Failed), report.Status.Missing, behind, len(report.Status.Tags))
+			if report.Revocations {
+				log.Printf("Updated revocation list")
+			}
+		}
+
+		if *once {
+			if len(report.Failed) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// printMirrorStatus prints a mirror's lag per tag
+func printMirrorStatus(st *repo.MirrorStatus, jsonOut bool) {
+	if jsonOut {
+		printJSON(st)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tSOURCE\tMIRROR\tLAG")
+	for _, t := range st.Tags {
+		lag := "in sync"
+		switch {
+		case t.Source == "":
+			lag = "dropped at source"
+		case !t.InSync():
+			lag = t.Lag.Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Ref, shortDigest(t.Source), shortDigest(t.Mirror), lag)
+	}
+	tw.Flush()
+	fmt.Printf("Last sync %s; %d spores missing\n", formatBound(st.LastSync, "never"), st.Missing)
+}
+
+// shortDigest abbreviates a digest for tables
+func shortDigest(digest string) string {
+	switch {
+	case digest == "":
+		return "-"
+	case len(digest) > 12:
+		return digest[:12]
+	}
+	return digest
+}
+
+func trustCommand() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: mesh trust <add|list|remove|threshold> [flags]")
+		os.Exit(1)
+	}
+
+	sub := os.Args[1]
+	os.Args = os.Args[1:]
+
+	switch sub {
+	case "add":
+		trustAddCommand()
+	case "list":
+		trustListCommand()
+	case "remove":
+		trustRemoveCommand()
+	case "threshold":
+		trustThresholdCommand()
+	default:
+		fmt.Printf("Unknown trust command: %s\n", sub)
+		os.Exit(1)
+	}
+}
+
+func trustAddCommand() {
+	var (
+		keyringPath = flag.String("keyring", "./trust.json", "Path to keyring file")
+		name        = flag.String("name", "", "Name for the trusted key")
+		keyPath     = flag.String("key", "", "Path to public (or private) key file")
+		pubKey      = flag.String("pubkey", "", "Base64 public key")
+		apps        = flag.String("apps", "", "Comma-separated apps the key may sign (default any)")
+	)
+	flag.Parse()
+
+	if *name == "" || (*keyPath == "" && *pubKey == "") {
+		fmt.Println("Error: -name and one of -key or -pubkey are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	keyData := []byte(*pubKey)
+	if *keyPath != "" {
+		var err error
+		keyData, err = os.ReadFile(*keyPath)
+		if err != nil {
+			log.Fatalf("Failed to read key file: %v", err)
+		}
+	}
+
+	pub, err := spore.ParsePublicKey(keyData)
+	if err != nil {
+		log.Fatalf("Failed to parse key: %v", err)
+	}
+
+	kr, err := spore.LoadKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	var appList []string
+	if *apps != "" {
+		appList = strings.Split(*apps, ",")
+	}
+
+	if err := kr.Add(*name, pub, appList); err != nil {
+		log.Fatalf("Failed to add key: %v", err)
+	}
+	if err := kr.Save(*keyringPath); err != nil {
+		log.Fatalf("Failed to save keyring: %v", err)
+	}
+
+	log.Printf("Trusted key %s (%s)", *name, spore.Fingerprint(pub))
+}
+
+func trustListCommand() {
+	keyringPath := flag.String("keyring", "./trust.json", "Path to keyring file")
+	flag.Parse()
+
+	kr, err := spore.LoadKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	for _, key := range kr.Keys {
+		pub, _ := spore.ParsePublicKey([]byte(key.PublicKey))
+		apps := "*"
+		if len(key.Apps) > 0 {
+			apps = strings.Join(key.Apps, ",")
+		}
+		fmt.Printf("%-20s %s  apps=%s\n", key.Name, spore.Fingerprint(pub), apps)
+	}
+	for _, app := range slices.Sorted(maps.Keys(kr.Thresholds)) {
+		fmt.Printf("threshold %-10s %d signatures\n", app, kr.Thresholds[app])
+	}
+}
+
+func trustThresholdCommand() {
+	var (
+		keyringPath = flag.String("keyring", "./trust.json", "Path to keyring file")
+		app         = flag.String("app", "*", "App the threshold applies to (* for the default)")
+		n           = flag.Int("n", 1, "Number of distinct trusted signatures required")
+	)
+	flag.Parse()
+
+	kr, err := spore.LoadKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	if err := kr.SetThreshold(*app, *n); err != nil {
+		log.Fatalf("Failed to set threshold: %v", err)
+	}
+	if err := kr.Save(*keyringPath); err != nil {
+		log.Fatalf("Failed to save keyring: %v", err)
+	}
+
+	log.Printf("App %s now requires %d trusted signatures", *app, *n)
+}
+
+func trustRemoveCommand() {
+	var (
+		keyringPath = flag.String("keyring", "./trust.json", "Path to keyring file")
+		name        = flag.String("name", "", "Name of the key to remove")
+	)
+	flag.Parse()
+
+	if *name == "" {
+		fmt.Println("Error: -name is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	kr, err := spore.LoadKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	if err := kr.Remove(*name); err != nil {
+		log.Fatalf("Failed to remove key: %v", err)
+	}
+	if err := kr.Save(*keyringPath); err != nil {
+		log.Fatalf("Failed to save keyring: %v", err)
+	}
+
+	log.Printf("Removed key %s", *name)
+}
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"crypto/ecdh"
 	"fmt"
 	"log"
 	"net"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,42 +19,65 @@ import (
 	"github.com/karadia10/mycelium-mesh/internal/spore"
 )
 
+// revocationsFile keeps the newest revocation list the agent accepted, in
+// its run directory, so a stale or unsigned list is refused across restarts
+const revocationsFile = "revocations.json"
+
 // procInfo tracks a running process
 type procInfo struct {
-	AppName string
-	Digest  string
-	Process *exec.Cmd
-	URL     string
-	Port    int
+	AppName  string
+	Digest   string
+	Manifest *spore.Manifest
+	Process  *exec.Cmd
+	URL      string
+	Port     int
 }
 
 // Agent represents a node agent
 type Agent struct {
-	ID     string
-	Fab    *fabric.Fabric
-	Repo   *repo.Repo
-	RunDir string
-	Warmup time.Duration
+	ID      string
+	Fab     *fabric.Fabric
+	Repo    repo.Source
+	RunDir  string
+	Warmup  time.Duration
+	Keyring *spore.Keyring   // trusted publishers; nil trusts any valid signature
+	Policy  *spore.Policy    // attestation requirements; nil allows any spore
+	NodeKey *ecdh.PrivateKey // decrypts spores encrypted to this node; nil sprouts only unencrypted spores
+
+	// RevocationInterval is how often running spores are checked against the
+	// repo's revocation list; zero disables the check
+	RevocationInterval time.Duration
+	// StopRevoked stops running instances of revoked spores instead of only
+	// reporting them
+	StopRevoked bool
 
 	mu    sync.RWMutex
 	procs map[string]procInfo // appName -> procInfo
+
+	revocationsMu sync.Mutex // serializes revocation list refreshes
 }
 
 // New creates a new agent
-func New(id string, fab *fabric.Fabric, repo *repo.Repo, runDir string) *Agent {
+func New(id string, fab *fabric.Fabric, repo repo.Source, runDir string) *Agent {
 	return &Agent{
 		ID:     id,
 		Fab:    fab,
 		Repo:   repo,
 		RunDir: runDir,
 		Warmup: 2 * time.Second,
-		procs:  make(map[string]procInfo),
+
+		RevocationInterval: 30 * time.Second,
+
+		procs: make(map[string]procInfo),
 	}
 }
 
 // Start starts the agent
 func (a *Agent) Start(ctx context.Context) {
 	log.Printf("Agent %s starting", a.ID)
+	if a.Keyring == nil {
+		log.Printf("Agent %s has no keyring, trusting any validly signed spore", a.ID)
+	}
 
 	// Subscribe to plans
 	planCh := a.Fab.SubscribePlans()
@@ -63,6 +88,14 @@ func (a *Agent) Start(ctx context.Context) {
 		return
 	}
 
+	// Periodically check running spores against the revocation list
+	var revocationTick <-chan time.Time
+	if a.RevocationInterval > 0 {
+		ticker := time.NewTicker(a.RevocationInterval)
+		defer ticker.Stop()
+		revocationTick = ticker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -71,6 +104,8 @@ func (a *Agent) Start(ctx context.Context) {
 			return
 		case plan := <-planCh:
 			a.handlePlan(plan)
+		case <-revocationTick:
+			a.checkRunningRevocations()
 		}
 	}
 }
@@ -79,6 +114,13 @@ func (a *Agent) Start(ctx context.Context) {
 func (a *Agent) handlePlan(plan fabric.Plan) {
 	log.Printf("Agent %s received plan for app %s, digest %s", a.ID, plan.AppName, plan.Digest)
 
+	digest, err := repo.ParseDigest(plan.Digest)
+	if err != nil {
+		log.Printf("Agent %s rejected plan for app %s: %v", a.ID, plan.AppName, err)
+		return
+	}
+	plan.Digest = digest
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -167,22 +209,55 @@ func (a *Agent) launchProcess(plan fabric.Plan) {
 }
 
 // sproutProcess sprouts a spore as a process
-func (a *Agent) sproutProcess(plan fabric.Plan) (procInfo, error) {
-	// Get spore path from repo
-	sporePath := a.Repo.Path(plan.Digest)
-	if _, err := os.Stat(sporePath); err != nil {
-		return procInfo{}, fmt.Errorf("spore not found: %w", err)
+func (a *Agent) sproutProcess(plan fabric.Plan) (info procInfo, err error) {
+	if err := repo.CheckDigest(plan.Digest); err != nil {
+		return procInfo{}, err
 	}
 
-	// Verify spore
-	manifest, err := spore.Verify(sporePath)
+	// Pin the digest so repo garbage collection leaves it while we sprout
+	if err := a.Repo.Lease(plan.Digest, a.ID); err != nil {
+		log.Printf("Agent %s could not lease spore %s: %v", a.ID, plan.Digest, err)
+	}
+	defer a.Repo.Release(plan.Digest, a.ID)
+
+	// Read the spore from the repo
+	blob, err := a.Repo.Open(plan.Digest)
+	if err != nil {
+		return procInfo{}, err
+	}
+	defer blob.Close()
+
+	// Verify the spore, then refuse revoked digests and spores signed by
+	// revoked keys and check its attestations against the policy, all before
+	// anything is written to disk
+	verified, atts, err := spore.VerifyAttestationsReader(blob, blob.Size(), a.Keyring)
 	if err != nil {
 		return procInfo{}, fmt.Errorf("spore verification failed: %w", err)
 	}
+	if err := a.checkRevoked(plan.Digest, verified); err != nil {
+		return procInfo{}, err
+	}
+	if a.Policy != nil {
+		if err := a.Policy.Check(verified, atts); err != nil {
+			return procInfo{}, err
+		}
+	}
+
+	// Scripts need their interpreter on this node
+	interp, err := verified.FindInterpreter()
+	if err != nil {
+		return procInfo{}, err
+	}
 
-	// Extract spore
+	// Extract spore, verifying it against trusted publishers in the same
+	// pass, and remove it again if it does not come up
 	extractDir := filepath.Join(a.RunDir, fmt.Sprintf("%s-%s-%d", plan.AppName, plan.Digest[:8], time.Now().Unix()))
-	_, binaryPath, err := spore.Extract(sporePath, extractDir)
+	defer func() {
+		if err != nil {
+			os.RemoveAll(extractDir)
+		}
+	}()
+	manifest, binaryPath, err := spore.ExtractReaderWithKey(blob, blob.Size(), extractDir, a.Keyring, a.NodeKey)
 	if err != nil {
 		return procInfo{}, fmt.Errorf("spore extraction failed: %w", err)
 	}
@@ -206,8 +281,9 @@ func (a *Agent) sproutProcess(plan fabric.Plan) (procInfo, error) {
 		return procInfo{}, fmt.Errorf("failed to get absolute path for binary: %w", err)
 	}
 
-	log.Printf("Launching binary: %s in dir: %s", absBinaryPath, extractDir)
-	cmd := exec.Command(absBinaryPath, manifest.Args...)
+	name, args := interp.Command(absBinaryPath, manifest.Args)
+	log.Printf("Launching %s in dir: %s", strings.Join(append([]string{name}, args...), " "), extractDir)
+	cmd := exec.Command(name, args...)
 	cmd.Dir = extractDir
 	cmd.Env = env
 
@@ -221,18 +297,95 @@ func (a *Agent) sproutProcess(plan fabric.Plan) (procInfo, error) {
 	// Wait for health check
 	if err := a.waitForHealth(url, 6*time.Second); err != nil {
 		cmd.Process.Kill()
+		cmd.Wait()
 		return procInfo{}, fmt.Errorf("health check failed: %w", err)
 	}
 
 	return procInfo{
-		AppName: plan.AppName,
-		Digest:  plan.Digest,
-		Process: cmd,
-		URL:     url,
-		Port:    port,
+		AppName:  plan.AppName,
+		Digest:   plan.Digest,
+		Manifest: manifest,
+		Process:  cmd,
+		URL:      url,
+		Port:     port,
 	}, nil
 }
 
+// revocations returns the repo's revocation list once it verifies against
+// the agent's keyring and is no older than the last list the agent accepted,
+// which it keeps in its run directory
+func (a *Agent) revocations() (*spore.RevocationList, error) {
+	a.revocationsMu.Lock()
+	defer a.revocationsMu.Unlock()
+
+	rl, err := a.Repo.Revocations()
+	if err != nil {
+		return nil, err
+	}
+	if err := rl.Verify(a.Keyring); err != nil {
+		return nil, fmt.Errorf("revocation list rejected: %w", err)
+	}
+
+	path := filepath.Join(a.RunDir, revocationsFile)
+	prev, err := spore.LoadRevocationList(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := rl.CheckRollback(prev); err != nil {
+		return nil, fmt.Errorf("revocation list rejected: %w", err)
+	}
+	if rl.Signer != nil && rl.IssuedAt.After(prev.IssuedAt) {
+		if err := rl.Save(path); err != nil {
+			return nil, err
+		}
+	}
+	return rl, nil
+}
+
+// checkRevoked fails if the spore's digest or any of its signers is revoked.
+// A revocation list that cannot be read or trusted fails closed.
+func (a *Agent) checkRevoked(digest string, manifest *spore.Manifest) error {
+	rl, err := a.revocations()
+	if err != nil {
+		return err
+	}
+	return rl.Check(digest, manifest)
+}
+
+// checkRunningRevocations reports running instances of revoked spores, and
+// stops them if StopRevoked is set
+func (a *Agent) checkRunningRevocations() {
+	rl, err := a.revocations()
+	if err != nil {
+		log.Printf("Agent %s cannot check revocations: %v", a.ID, err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for appName, proc := range a.procs {
+		if proc.Manifest == nil {
+			continue
+		}
+		err := rl.Check(proc.Digest, proc.Manifest)
+		if err == nil {
+			continue
+		}
+		if !a.StopRevoked {
+			log.Printf("Agent %s is running a revoked spore: %v", a.ID, err)
+			continue
+		}
+
+		log.Printf("Agent %s stopping app %s: %v", a.ID, appName, err)
+		a.Fab.RemoveEndpoint(appName, a.ID)
+		if proc.Process != nil && proc.Process.Process != nil {
+			proc.Process.Process.Kill()
+		}
+		delete(a.procs, appName)
+	}
+}
+
 // findFreePort finds a free TCP port
 func (a *Agent) findFreePort() (int, error) {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karadia10/mycelium-mesh/internal/fabric"
+	"github.com/karadia10/mycelium-mesh/internal/repo"
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// memorySource serves spores from memory with a fixed revocation list
+type memorySource struct {
+	*repo.MemoryStore
+	revocations *spore.RevocationList
+}
+
+func (s *memorySource) Revocations() (*spore.RevocationList, error) { return s.revocations, nil }
+func (s *memorySource) Lease(digest, holder string) error           { return nil }
+func (s *memorySource) Release(digest, holder string) error         { return nil }
+
+// putSpore packs a spore in memory, stores it and returns its digest
+func putSpore(t *testing.T, store repo.Store, priv ed25519.PrivateKey) string {
+	t.Helper()
+	return putManifest(t, store, testManifest(), priv)
+}
+
+// testManifest returns the manifest putSpore packs
+func testManifest() spore.Manifest {
+	return spore.Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: spore.Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+}
+
+// putManifest packs a spore for manifest in memory, stores it and returns
+// its digest
+func putManifest(t *testing.T, store repo.Store, manifest spore.Manifest, priv ed25519.PrivateKey) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := spore.PackTo(&buf, spore.Payload{Binary: strings.NewReader("test content")}, manifest, priv); err != nil {
+		t.Fatalf("PackTo failed: %v", err)
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(buf.Bytes()))
+	if err := store.Put(digest, &buf); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	return digest
+}
+
+func TestSproutRejects(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	kr := &spore.Keyring{}
+	if err := kr.Add("publisher", pub, nil); err != nil {
+		t.Fatalf("Failed to trust key: %v", err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	src := &memorySource{MemoryStore: repo.NewMemoryStore(), revocations: &spore.RevocationList{}}
+	trusted := putSpore(t, src, priv)
+	untrusted := putSpore(t, src, otherPriv)
+	script := testManifest()
+	script.Runtime = &spore.Runtime{Type: spore.RuntimeScript, Interpreter: "mesh-test-no-such-interpreter"}
+	noInterpreter := putManifest(t, src, script, priv)
+
+	a := New("node-1", fabric.New(), src, t.TempDir())
+	a.Keyring = kr
+
+	// Malformed and unknown digests, untrusted publishers and revoked spores
+	// never run
+	for _, digest := range []string{"", "abc", "sha256:" + trusted, trusted[:12]} {
+		_, err = a.sproutProcess(fabric.Plan{AppName: "test-app", Digest: digest})
+		var refErr *repo.RefError
+		if !errors.As(err, &refErr) {
+			t.Errorf("Digest %q: expected RefError, got %v", digest, err)
+		}
+	}
+
+	_, err = a.sproutProcess(fabric.Plan{AppName: "test-app", Digest: strings.Repeat("1", 64)})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	_, err = a.sproutProcess(fabric.Plan{AppName: "test-app", Digest: untrusted})
+	var untrustedErr *spore.UntrustedKeyError
+	if !errors.As(err, &untrustedErr) {
+		t.Errorf("Expected UntrustedKeyError, got %v", err)
+	}
+
+	_, err = a.sproutProcess(fabric.Plan{AppName: "test-app", Digest: noInterpreter})
+	var runtimeErr *spore.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("Expected RuntimeError, got %v", err)
+	}
+
+	older := &spore.RevocationList{}
+	if err := older.Sign(priv); err != nil {
+		t.Fatalf("Failed to sign revocation list: %v", err)
+	}
+	src.revocations.RevokeDigest(trusted, "test")
+	if err := src.revocations.Sign(priv); err != nil {
+		t.Fatalf("Failed to sign revocation list: %v", err)
+	}
+	_, err = a.sproutProcess(fabric.Plan{AppName: "test-app", Digest: trusted})
+	var revoked *spore.RevokedError
+	if !errors.As(err, &revoked) {
+		t.Errorf("Expected RevokedError, got %v", err)
+	}
+	// Nothing of a refused spore is written to disk
+	entries, _ := os.ReadDir(a.RunDir)
+	for _, e := range entries {
+		if e.IsDir() {
+			t.Errorf("Refused spore was extracted to %s", e.Name())
+		}
+	}
+
+	// Once a signed list is seen, an unsigned or older one cannot erase it
+	for _, rl := range []*spore.RevocationList{{}, older} {
+		src.revocations = rl
+		_, err = a.sproutProcess(fabric.Plan{AppName: "test-app", Digest: trusted})
+		if err == nil || !strings.Contains(err.Error(), "revocation list rejected") {
+			t.Errorf("Expected the revocation list to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestStopRevoked(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	kr := &spore.Keyring{}
+	if err := kr.Add("publisher", pub, nil); err != nil {
+		t.Fatalf("Failed to trust key: %v", err)
+	}
+
+	src := &memorySource{MemoryStore: repo.NewMemoryStore(), revocations: &spore.RevocationList{}}
+	digest := putSpore(t, src, priv)
+	blob, err := src.Open(digest)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	manifest, err := spore.VerifyReader(blob, blob.Size(), kr)
+	blob.Close()
+	if err != nil {
+		t.Fatalf("VerifyReader failed: %v", err)
+	}
+
+	a := New("node-1", fabric.New(), src, t.TempDir())
+	a.Keyring = kr
+	a.StopRevoked = true
+
+	// A running instance of the spore
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer cmd.Process.Kill()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	a.procs["test-app"] = procInfo{AppName: "test-app", Digest: digest, Manifest: manifest, Process: cmd}
+	a.Fab.RegisterEndpoint(fabric.Endpoint{AppName: "test-app", URL: "http://127.0.0.1:1", NodeID: a.ID})
+
+	// Nothing is revoked yet
+	a.checkRunningRevocations()
+	if _, ok := a.procs["test-app"]; !ok {
+		t.Fatalf("App stopped with nothing revoked")
+	}
+
+	src.revocations.RevokeKey(manifest.KeyID, "key leaked")
+	if err := src.revocations.Sign(priv); err != nil {
+		t.Fatalf("Failed to sign revocation list: %v", err)
+	}
+	a.checkRunningRevocations()
+	if _, ok := a.procs["test-app"]; ok {
+		t.Errorf("Revoked app is still tracked")
+	}
+	if endpoints := a.Fab.Endpoints("test-app"); len(endpoints) != 0 {
+		t.Errorf("Revoked app is still routed to: %+v", endpoints)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Revoked app was not stopped")
+	}
+}
@@ -28,6 +28,12 @@ type Endpoint struct {
 	NodeID  string
 }
 
+// Peer is an agent that serves spore chunks to other agents
+type Peer struct {
+	NodeID string
+	URL    string // base URL of the node's chunk server
+}
+
 // Fabric represents the control fabric
 type Fabric struct {
 	mu          sync.RWMutex
@@ -35,6 +41,8 @@ type Fabric struct {
 	budgets     map[string]Budget
 	endpoints   map[string][]Endpoint // appName -> endpoints
 	subscribers []chan Plan
+	peers       map[string]Peer            // nodeID -> peer
+	chunks      map[string]map[string]bool // chunk hash -> nodeIDs holding it
 }
 
 // New creates a new fabric
@@ -44,6 +52,8 @@ func New() *Fabric {
 		budgets:     make(map[string]Budget),
 		endpoints:   make(map[string][]Endpoint),
 		subscribers: make([]chan Plan, 0),
+		peers:       make(map[string]Peer),
+		chunks:      make(map[string]map[string]bool),
 	}
 }
 
@@ -114,6 +124,20 @@ func (f *Fabric) RegisterEndpoint(e Endpoint) {
 	f.endpoints[e.AppName] = append(endpoints, e)
 }
 
+// RemoveEndpoint removes a node's endpoint for an app
+func (f *Fabric) RemoveEndpoint(app, nodeID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	endpoints := f.endpoints[app]
+	for i, ep := range endpoints {
+		if ep.NodeID == nodeID {
+			f.endpoints[app] = append(endpoints[:i:i], endpoints[i+1:]...)
+			return
+		}
+	}
+}
+
 // Endpoints returns all endpoints for an app
 func (f *Fabric) Endpoints(app string) []Endpoint {
 	f.mu.RLock()
@@ -129,3 +153,53 @@ func (f *Fabric) Endpoints(app string) []Endpoint {
 	copy(result, endpoints)
 	return result
 }
+
+// RegisterPeer announces where a node serves spore chunks
+func (f *Fabric) RegisterPeer(p Peer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.peers[p.NodeID] = p
+}
+
+// RemovePeer forgets a node and the chunks it advertised
+func (f *Fabric) RemovePeer(nodeID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.peers, nodeID)
+	for hash, holders := range f.chunks {
+		delete(holders, nodeID)
+		if len(holders) == 0 {
+			delete(f.chunks, hash)
+		}
+	}
+}
+
+// AdvertiseChunks announces that a node holds chunks, by hash
+func (f *Fabric) AdvertiseChunks(nodeID string, hashes ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, hash := range hashes {
+		holders, exists := f.chunks[hash]
+		if !exists {
+			holders = make(map[string]bool)
+			f.chunks[hash] = holders
+		}
+		holders[nodeID] = true
+	}
+}
+
+// ChunkPeers returns the registered peers that advertised a chunk
+func (f *Fabric) ChunkPeers(hash string) []Peer {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var peers []Peer
+	for nodeID := range f.chunks[hash] {
+		if p, exists := f.peers[nodeID]; exists {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
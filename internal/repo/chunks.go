@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// DefaultChunkSize is the size of the chunks spores are split into for
+// peer-to-peer distribution
+const DefaultChunkSize = 1 << 20
+
+// Chunk is a piece of a spore, addressed by its own hash so it can be
+// fetched from any peer and checked on arrival
+type Chunk struct {
+	Hash   string `json:"hash"` // sha256 of the chunk
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// ChunkIndex lists the chunks of a spore in order
+type ChunkIndex struct {
+	Digest string  `json:"digest"`
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Check returns ErrDigestMismatch unless data is the chunk
+func (c Chunk) Check(data []byte) error {
+	if int64(len(data)) != c.Size {
+		return fmt.Errorf("%w: chunk %s is %d bytes, expected %d", ErrDigestMismatch, c.Hash, len(data), c.Size)
+	}
+	if got := fmt.Sprintf("%x", sha256.Sum256(data)); got != c.Hash {
+		return fmt.Errorf("%w: chunk hashes to %s, expected %s", ErrDigestMismatch, got, c.Hash)
+	}
+	return nil
+}
+
+// IndexChunks reads a spore that must hash to digest and splits it into
+// chunks of chunkSize bytes
+func IndexChunks(r io.Reader, digest string, chunkSize int64) (*ChunkIndex, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	index := &ChunkIndex{Digest: digest}
+	whole := sha256.New()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			whole.Write(buf[:n])
+			index.Chunks = append(index.Chunks, Chunk{
+				Hash:   fmt.Sprintf("%x", sha256.Sum256(buf[:n])),
+				Offset: index.Size,
+				Size:   int64(n),
+			})
+			index.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spore %s: %w", digest, err)
+		}
+	}
+
+	if err := checkDigest(whole, digest); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// ChunkIndex splits a stored spore into chunks of ChunkSize bytes. Indexes
+// are kept, so a rollout to many nodes reads the spore once.
+func (r *Repo) ChunkIndex(digest string) (*ChunkIndex, error) {
+	if index, ok := r.indexes.Load(digest); ok {
+		return index.(*ChunkIndex), nil
+	}
+
+	blob, err := r.Store.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	chunkSize := r.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	index, err := IndexChunks(blob, digest, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	r.indexes.Store(digest, index)
+	return index, nil
+}
+
+// ReadChunk reads one chunk of a stored spore and checks it
+func (r *Repo) ReadChunk(digest string, c Chunk) ([]byte, error) {
+	blob, err := r.Store.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	data := make([]byte, c.Size)
+	if _, err := blob.ReadAt(data, c.Offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read chunk of spore %s: %w", digest, err)
+	}
+	if err := c.Check(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ChunkIndex fetches the server's chunk index of a spore
+func (rm *Remote) ChunkIndex(digest string) (*ChunkIndex, error) {
+	if err := CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	var index ChunkIndex
+	if err := getJSON(rm.Client, rm.URL+"/chunks/"+url.PathEscape(digest), &index); err != nil {
+		return nil, err
+	}
+	if index.Digest != digest {
+		return nil, fmt.Errorf("repo server sent the chunk index of %s for %s", index.Digest, digest)
+	}
+	return &index, nil
+}
+
+// ReadChunk downloads one chunk of a spore with a range request and checks it
+func (rm *Remote) ReadChunk(digest string, c Chunk) ([]byte, error) {
+	if err := CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	data := make([]byte, c.Size)
+	if err := rm.objects().readRange(digest, data, c.Offset); err != nil {
+		return nil, err
+	}
+	if err := c.Check(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestChunkIndex(t *testing.T) {
+	repo, srv := newTestServer(t)
+	repo.ChunkSize = 100
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	data, err := os.ReadFile(repo.objectPath(digest))
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+
+	remote, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+	index, err := remote.ChunkIndex(digest)
+	if err != nil {
+		t.Fatalf("ChunkIndex failed: %v", err)
+	}
+	if index.Size != int64(len(data)) || len(index.Chunks) != (len(data)+99)/100 {
+		t.Fatalf("Unexpected index of %d bytes in %d chunks for a %d byte spore", index.Size, len(index.Chunks), len(data))
+	}
+
+	// The chunks cover the spore in order
+	var joined []byte
+	for _, c := range index.Chunks {
+		chunk, err := remote.ReadChunk(digest, c)
+		if err != nil {
+			t.Fatalf("ReadChunk failed: %v", err)
+		}
+		joined = append(joined, chunk...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Error("Chunks do not join up to the spore")
+	}
+
+	c := index.Chunks[0]
+	if err := c.Check(bytes.Repeat([]byte("x"), int(c.Size))); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Expected ErrDigestMismatch for a bad chunk, got %v", err)
+	}
+	if _, err := IndexChunks(bytes.NewReader(data[1:]), digest, 100); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Expected ErrDigestMismatch indexing the wrong content, got %v", err)
+	}
+}
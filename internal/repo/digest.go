@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DigestPrefix is the algorithm prefix references may carry, as in
+// sha256:<digest>
+const DigestPrefix = "sha256:"
+
+// MinPrefixLength is the shortest digest prefix Resolve expands
+const MinPrefixLength = 4
+
+var (
+	digestPattern       = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	digestPrefixPattern = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+)
+
+// AmbiguousDigestError is returned when a digest prefix matches more than
+// one stored spore
+type AmbiguousDigestError struct {
+	Prefix     string
+	Candidates []string
+}
+
+func (e *AmbiguousDigestError) Error() string {
+	return fmt.Sprintf("digest prefix %s is ambiguous, matches %s", e.Prefix, strings.Join(e.Candidates, ", "))
+}
+
+// IsDigest reports whether s is a full spore digest
+func IsDigest(s string) bool {
+	return digestPattern.MatchString(s)
+}
+
+// CheckDigest returns a RefError unless digest is a full spore digest. It is
+// the one check every entry point taking a digest goes through.
+func CheckDigest(digest string) error {
+	if !IsDigest(digest) {
+		return &RefError{Kind: "digest", Value: digest, Want: "64 lowercase hex characters"}
+	}
+	return nil
+}
+
+// ParseDigest returns the full digest s names, with or without the sha256:
+// prefix
+func ParseDigest(s string) (string, error) {
+	digest := strings.TrimPrefix(s, DigestPrefix)
+	if !IsDigest(digest) {
+		return "", &RefError{Kind: "digest", Value: s, Want: "64 lowercase hex characters, optionally prefixed with " + DigestPrefix}
+	}
+	return digest, nil
+}
+
+// resolvePrefix expands a digest prefix against the objects returned by
+// list. The prefix must match exactly one of them.
+func resolvePrefix(prefix string, list func() ([]Object, error)) (string, error) {
+	objects, err := list()
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Digest, prefix) {
+			candidates = append(candidates, obj.Digest)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("unknown digest prefix %s", prefix)
+	case 1:
+		return candidates[0], nil
+	}
+	slices.Sort(candidates)
+	return "", &AmbiguousDigestError{Prefix: prefix, Candidates: candidates}
+}
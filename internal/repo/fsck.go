@@ -0,0 +1,231 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// quarantineDir holds objects fsck found to be bad, out of the way of Fetch
+const quarantineDir = "quarantine"
+
+// Problems fsck reports besides spore.Reason codes
+const (
+	ProblemTempFile    = "temp_file"    // left behind by an interrupted write
+	ProblemUnknownFile = "unknown_file" // not something the repo writes
+	ProblemDanglingTag = "dangling_tag" // names a digest that is not stored
+	ProblemStaleLease  = "stale_lease"  // leases a digest that is not stored
+)
+
+// FsckIssue is one problem found by Fsck
+type FsckIssue struct {
+	Digest  string `json:"digest,omitempty"`
+	Path    string `json:"path"`
+	Problem string `json:"problem"` // spore.Reason code or one of the Problem constants
+	Detail  string `json:"detail,omitempty"`
+	Action  string `json:"action,omitempty"` // what fsck did about it
+}
+
+// FsckReport lists what Fsck found
+type FsckReport struct {
+	Checked int         `json:"checked"`
+	Corrupt []FsckIssue `json:"corrupt"`
+	Orphans []FsckIssue `json:"orphans"`
+	// Unrepaired counts corrupt objects still missing from the repo, either
+	// quarantined or left in place
+	Unrepaired int `json:"unrepaired"`
+}
+
+// Fsck rehashes every stored spore and verifies it, and reports files,
+// tags and leases that belong to nothing. With quarantine, corrupt objects
+// are moved into the repo's quarantine directory. With a mirror, they are
+// quarantined and fetched again from the mirror, which must hold a copy
+// that hashes to the digest and verifies.
+func (r *Repo) Fsck(mirror Source, quarantine bool) (*FsckReport, error) {
+	objects, err := r.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{}
+	for _, obj := range objects {
+		digest := obj.Digest
+		report.Checked++
+		problem, detail := r.checkObject(digest)
+		if problem == "" {
+			continue
+		}
+
+		issue := FsckIssue{Digest: digest, Path: r.objectPath(digest), Problem: problem, Detail: detail}
+		repaired := false
+		if quarantine || mirror != nil {
+			issue.Action, repaired = r.quarantine(digest, mirror)
+		}
+		if !repaired {
+			report.Unrepaired++
+		}
+		report.Corrupt = append(report.Corrupt, issue)
+	}
+
+	orphans, err := r.orphans()
+	if err != nil {
+		return nil, err
+	}
+	report.Orphans = orphans
+
+	return report, nil
+}
+
+// checkObject returns the problem with a stored spore, if any
+func (r *Repo) checkObject(digest string) (problem, detail string) {
+	blob, err := r.Store.Open(digest)
+	if err != nil {
+		return spore.ReasonNotFound, err.Error()
+	}
+	defer blob.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(blob, 0, blob.Size())); err != nil {
+		return spore.ReasonMalformed, fmt.Sprintf("failed to read: %v", err)
+	}
+	if got := fmt.Sprintf("%x", hasher.Sum(nil)); got != digest {
+		return spore.ReasonHashMismatch, fmt.Sprintf("content hashes to %s", got)
+	}
+
+	// A spore past its validity period is intact, just no longer runnable
+	if _, err := spore.VerifyReader(blob, blob.Size(), nil); err != nil {
+		switch reason := spore.Reason(err); reason {
+		case spore.ReasonExpired, spore.ReasonNotYetValid:
+		default:
+			return reason, err.Error()
+		}
+	}
+	return "", ""
+}
+
+// quarantine moves a bad object aside and, given a mirror, fetches it again.
+// It describes what was done and reports whether a good copy is in place.
+func (r *Repo) quarantine(digest string, mirror Source) (action string, repaired bool) {
+	dir := filepath.Join(r.Dir, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Sprintf("quarantine failed: %v", err), false
+	}
+	// Keep every bad copy for inspection
+	dest := filepath.Join(dir, fmt.Sprintf("%s-%d.spore", digest, time.Now().UnixNano()))
+	if err := r.copyObject(digest, dest); err != nil {
+		return fmt.Sprintf("quarantine failed: %v", err), false
+	}
+	if err := r.Store.Delete(digest); err != nil {
+		return fmt.Sprintf("quarantine failed: %v", err), false
+	}
+	action = "quarantined to " + dest
+
+	if mirror == nil {
+		return action, false
+	}
+	if err := r.refetch(digest, mirror); err != nil {
+		return fmt.Sprintf("%s; repair failed: %v", action, err), false
+	}
+	return "repaired from mirror; bad copy " + action, true
+}
+
+// copyObject copies a stored object, whatever its content, to a file
+func (r *Repo) copyObject(digest, dest string) error {
+	blob, err := r.Store.Open(digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	_, err = io.Copy(out, blob)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to copy spore %s: %w", digest, err)
+	}
+	return nil
+}
+
+// refetch stores a mirror's copy of a spore, which PutReader checks against
+// the digest and verifies
+func (r *Repo) refetch(digest string, mirror Source) error {
+	blob, err := mirror.Open(digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	return r.PutReader(blob, digest)
+}
+
+// objectPath describes where a stored spore lives, for reports
+func (r *Repo) objectPath(digest string) string {
+	if fileStore, ok := r.Store.(*FileStore); ok {
+		return fileStore.Path(digest)
+	}
+	return digest + ".spore"
+}
+
+// orphans reports files, tags and leases in the repo that belong to nothing
+func (r *Repo) orphans() ([]FsckIssue, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repo directory: %w", err)
+	}
+
+	var orphans []FsckIssue
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(r.Dir, name)
+		if digest, ok := strings.CutSuffix(name, ".spore"); ok && IsDigest(digest) && e.Type().IsRegular() {
+			continue
+		}
+
+		switch {
+		case name == tagsFile || name == revocationsFile || name == retentionFile || name == mirrorFile || name == policyFile:
+		case name == tagsFile+lockSuffix || name == mirrorFile+lockSuffix || name == revocationsFile+lockSuffix || name == leasesDir+lockSuffix:
+		case (name == leasesDir || name == quarantineDir || name == incomingDir) && e.IsDir():
+		case strings.HasPrefix(name, ".put-") || strings.HasSuffix(name, ".tmp"):
+			orphans = append(orphans, FsckIssue{Path: path, Problem: ProblemTempFile})
+		default:
+			orphans = append(orphans, FsckIssue{Path: path, Problem: ProblemUnknownFile})
+		}
+	}
+
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range slices.Sorted(maps.Keys(tags)) {
+		if _, err := r.Store.Stat(tags[ref]); errors.Is(err, os.ErrNotExist) {
+			orphans = append(orphans, FsckIssue{Digest: tags[ref], Path: filepath.Join(r.Dir, tagsFile), Problem: ProblemDanglingTag, Detail: ref})
+		}
+	}
+
+	leases, err := os.ReadDir(filepath.Join(r.Dir, leasesDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read leases: %w", err)
+	}
+	for _, e := range leases {
+		if _, err := r.Store.Stat(e.Name()); errors.Is(err, os.ErrNotExist) {
+			orphans = append(orphans, FsckIssue{Digest: e.Name(), Path: filepath.Join(r.Dir, leasesDir, e.Name()), Problem: ProblemStaleLease})
+		}
+	}
+
+	return orphans, nil
+}
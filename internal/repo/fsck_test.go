@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// corrupt flips a byte in the middle of a stored spore
+func corrupt(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	good := bytes.Clone(data)
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to corrupt spore: %v", err)
+	}
+	return good
+}
+
+func TestFsck(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	sporePath := packSpore(t, "v1.0.0", "test content 1")
+	bad, err := repo.Put(sporePath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := repo.Put(packSpore(t, "v1.0.1", "test content 2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	corrupt(t, repo.objectPath(bad))
+
+	// Leftovers of an interrupted Put, a lease and a tag for a missing spore
+	missing := "1111111111111111111111111111111111111111111111111111111111111111"
+	if err := os.WriteFile(filepath.Join(repo.Dir, ".put-123"), []byte("partial"), 0644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := repo.Lease(missing, "node-1"); err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	tags, _ := repo.Tags()
+	tags["test-app:gone"] = missing
+	if err := repo.saveTags(tags); err != nil {
+		t.Fatalf("Failed to save tags: %v", err)
+	}
+
+	// Report only
+	report, err := repo.Fsck(nil, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if report.Checked != 2 || len(report.Corrupt) != 1 || report.Unrepaired != 1 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if issue := report.Corrupt[0]; issue.Digest != bad || issue.Problem != spore.ReasonHashMismatch || issue.Action != "" {
+		t.Errorf("Unexpected corrupt entry %+v", issue)
+	}
+	var problems []string
+	for _, o := range report.Orphans {
+		problems = append(problems, o.Problem)
+	}
+	slices.Sort(problems)
+	if want := []string{ProblemDanglingTag, ProblemStaleLease, ProblemTempFile}; !slices.Equal(problems, want) {
+		t.Errorf("Orphans %v, expected %v", problems, want)
+	}
+	if _, err := os.Stat(repo.objectPath(bad)); err != nil {
+		t.Error("Fsck without quarantine should leave the object in place")
+	}
+
+	// Quarantine moves the bad object aside
+	report, err = repo.Fsck(nil, true)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Corrupt) != 1 || report.Unrepaired != 1 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if _, err := os.Stat(repo.objectPath(bad)); !os.IsNotExist(err) {
+		t.Error("Corrupt object should be quarantined")
+	}
+	quarantined, _ := filepath.Glob(filepath.Join(repo.Dir, quarantineDir, bad+"-*.spore"))
+	if len(quarantined) != 1 {
+		t.Errorf("Expected the bad copy in quarantine, found %v", quarantined)
+	}
+}
+
+func TestFsckRepairsFromMirror(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	mirror, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open mirror: %v", err)
+	}
+
+	sporePath := packSpore(t, "v1.0.0", "test content")
+	digest, err := repo.Put(sporePath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := mirror.Put(sporePath); err != nil {
+		t.Fatalf("Mirror Put failed: %v", err)
+	}
+	good := corrupt(t, repo.objectPath(digest))
+
+	report, err := repo.Fsck(mirror, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Corrupt) != 1 || report.Unrepaired != 0 {
+		t.Fatalf("Expected one repaired object, got %+v", report)
+	}
+	if data, _ := os.ReadFile(repo.objectPath(digest)); !bytes.Equal(data, good) {
+		t.Error("Repaired object differs from the original")
+	}
+
+	// A clean repo stays clean
+	report, err = repo.Fsck(mirror, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Corrupt) != 0 || len(report.Orphans) != 0 {
+		t.Errorf("Expected a clean report, got %+v", report)
+	}
+}
@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// retentionFile is the default retention policy, read from the repo directory
+const retentionFile = "retention.json"
+
+// gcGracePeriod protects spores published moments ago, before a tag or plan
+// has had a chance to reference them
+const gcGracePeriod = time.Hour
+
+// RetentionPolicy decides which untagged spores garbage collection keeps
+type RetentionPolicy struct {
+	KeepVersions int `json:"keep_versions"`       // newest versions per app, by creation time
+	KeepDays     int `json:"keep_days,omitempty"` // spores published within this many days
+}
+
+// DefaultRetention applies when no policy file exists
+var DefaultRetention = RetentionPolicy{KeepVersions: 3}
+
+// GCEntry describes one spore considered by garbage collection
+type GCEntry struct {
+	Digest  string   `json:"digest"`
+	App     string   `json:"app,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Size    int64    `json:"size"`
+	Reasons []string `json:"reasons,omitempty"` // why it was kept
+}
+
+// GCReport lists what garbage collection kept and removed
+type GCReport struct {
+	DryRun  bool      `json:"dry_run"`
+	Kept    []GCEntry `json:"kept"`
+	Removed []GCEntry `json:"removed"`
+	Freed   int64     `json:"freed"` // bytes
+}
+
+// RetentionPath returns the path of the repo's default retention policy
+func (r *Repo) RetentionPath() string {
+	return filepath.Join(r.Dir, retentionFile)
+}
+
+// LoadRetentionPolicy reads a retention policy file. A missing file yields
+// DefaultRetention, and fields the file omits keep their default.
+func LoadRetentionPolicy(path string) (*RetentionPolicy, error) {
+	policy := DefaultRetention
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policy: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse retention policy: %w", err)
+	}
+	if policy.KeepVersions < 0 || policy.KeepDays < 0 {
+		return nil, fmt.Errorf("retention policy %s: keep_versions and keep_days must not be negative", path)
+	}
+	return &policy, nil
+}
+
+// GC removes spores that nothing references. A spore is kept if a tag other
+// than its own name:version names it, a holder leases it (an agent sprouting
+// it or a live plan), it is among the policy's newest versions of its app,
+// or it was published within the policy's days or the last hour. With
+// dryRun nothing is removed.
+func (r *Repo) GC(policy *RetentionPolicy, dryRun bool) (*GCReport, error) {
+	now := time.Now()
+
+	objects, err := r.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		entry     GCEntry
+		createdAt time.Time
+		storedAt  time.Time
+	}
+	candidates := make(map[string]*candidate)
+	byApp := make(map[string][]*candidate)
+
+	digests := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		digest := obj.Digest
+		digests = append(digests, digest)
+		c := &candidate{entry: GCEntry{Digest: digest, Size: obj.Size}, storedAt: obj.StoredAt}
+		candidates[digest] = c
+
+		// Leave spores that cannot be read for fsck to deal with
+		insp, err := r.inspect(digest)
+		if err != nil {
+			c.entry.Reasons = append(c.entry.Reasons, "unreadable")
+			continue
+		}
+		c.entry.App = insp.Manifest.Name
+		c.entry.Version = insp.Manifest.Version
+		c.createdAt = insp.Manifest.CreatedAt
+		byApp[c.entry.App] = append(byApp[c.entry.App], c)
+	}
+
+	// Tags other than the automatic name:version tag pin their spore
+	for _, ref := range slices.Sorted(maps.Keys(tags)) {
+		c, ok := candidates[tags[ref]]
+		if !ok || ref == c.entry.App+":"+c.entry.Version {
+			continue
+		}
+		c.entry.Reasons = append(c.entry.Reasons, "tag "+ref)
+	}
+
+	// Newest versions per app
+	for _, list := range byApp {
+		slices.SortFunc(list, func(a, b *candidate) int {
+			return cmp.Or(b.createdAt.Compare(a.createdAt), strings.Compare(a.entry.Digest, b.entry.Digest))
+		})
+		for i, c := range list {
+			if i < policy.KeepVersions {
+				c.entry.Reasons = append(c.entry.Reasons, fmt.Sprintf("newest %d versions", policy.KeepVersions))
+			}
+		}
+	}
+
+	for _, digest := range digests {
+		c := candidates[digest]
+		age := now.Sub(c.storedAt)
+		if policy.KeepDays > 0 && age < time.Duration(policy.KeepDays)*24*time.Hour {
+			c.entry.Reasons = append(c.entry.Reasons, fmt.Sprintf("published within %d days", policy.KeepDays))
+		} else if age < gcGracePeriod {
+			c.entry.Reasons = append(c.entry.Reasons, "published within the last hour")
+		}
+	}
+
+	report := &GCReport{DryRun: dryRun}
+	removed := make(map[string]bool)
+	for _, digest := range digests {
+		c := candidates[digest]
+
+		// Leases are checked last. A spore kept for nothing else is checked
+		// and removed under the lease lock, so a sprout that leases it
+		// before it is removed always keeps it.
+		var holders []string
+		if len(c.entry.Reasons) > 0 {
+			holders, err = r.leaseHolders(digest, time.Now())
+		} else {
+			holders, err = r.deleteUnleased(digest, dryRun)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, holder := range holders {
+			c.entry.Reasons = append(c.entry.Reasons, "leased by "+holder)
+		}
+		if len(c.entry.Reasons) > 0 {
+			report.Kept = append(report.Kept, c.entry)
+			continue
+		}
+		removed[digest] = true
+		report.Removed = append(report.Removed, c.entry)
+		report.Freed += c.entry.Size
+	}
+
+	if dryRun || len(removed) == 0 {
+		return report, nil
+	}
+
+	// Drop the version tags of removed spores
+	if err := r.dropTags(removed); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,168 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// putAged publishes a spore and backdates its publish time
+func putAged(t *testing.T, repo *Repo, version string, createdAt time.Time, age time.Duration) string {
+	t.Helper()
+
+	digest, err := repo.Put(packSporeAt(t, version, "content "+version, createdAt))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	stored := time.Now().Add(-age)
+	if err := os.Chtimes(repo.objectPath(digest), stored, stored); err != nil {
+		t.Fatalf("Failed to backdate spore: %v", err)
+	}
+	return digest
+}
+
+// gcDigests returns the digests of a report's entries
+func gcDigests(entries []GCEntry) []string {
+	var digests []string
+	for _, e := range entries {
+		digests = append(digests, e.Digest)
+	}
+	slices.Sort(digests)
+	return digests
+}
+
+func TestGC(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	base := time.Now().Add(-30 * 24 * time.Hour)
+	var digests []string
+	for i, version := range []string{"v1.0.0", "v1.0.1", "v1.0.2", "v1.0.3"} {
+		digests = append(digests, putAged(t, repo, version, base.Add(time.Duration(i)*24*time.Hour), 48*time.Hour))
+	}
+
+	// v1.0.0 is pinned by a tag, v1.0.1 by a lease, v1.0.3 is the newest
+	if err := repo.Tag("test-app:stable", digests[0]); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	if err := repo.Lease(digests[1], "node-1"); err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	policy := &RetentionPolicy{KeepVersions: 1}
+
+	report, err := repo.GC(policy, true)
+	if err != nil {
+		t.Fatalf("GC dry run failed: %v", err)
+	}
+	if got := gcDigests(report.Removed); !slices.Equal(got, []string{digests[2]}) {
+		t.Errorf("Dry run would remove %v, expected %v", got, digests[2:3])
+	}
+	if _, err := os.Stat(repo.objectPath(digests[2])); err != nil {
+		t.Errorf("Dry run removed a spore: %v", err)
+	}
+
+	report, err = repo.GC(policy, false)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if got := gcDigests(report.Removed); !slices.Equal(got, []string{digests[2]}) {
+		t.Errorf("GC removed %v, expected %v", got, digests[2:3])
+	}
+	if report.Freed == 0 {
+		t.Error("GC should report freed bytes")
+	}
+	if _, err := os.Stat(repo.objectPath(digests[2])); !os.IsNotExist(err) {
+		t.Error("GC should remove the unreferenced spore")
+	}
+	for _, digest := range []string{digests[0], digests[1], digests[3]} {
+		if _, err := os.Stat(repo.objectPath(digest)); err != nil {
+			t.Errorf("GC removed a referenced spore: %v", err)
+		}
+	}
+
+	// The removed spore's version tag goes with it
+	if _, err := repo.Resolve("test-app:v1.0.2"); err == nil {
+		t.Error("Version tag of a removed spore should be dropped")
+	}
+	if _, err := repo.Resolve("test-app:v1.0.1"); err != nil {
+		t.Errorf("Version tag of a kept spore was dropped: %v", err)
+	}
+}
+
+func TestGCPublishAge(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	old := putAged(t, repo, "v1.0.0", time.Time{}, 10*24*time.Hour)
+	recent := putAged(t, repo, "v1.0.1", time.Time{}, 2*24*time.Hour)
+	fresh := putAged(t, repo, "v1.0.2", time.Time{}, time.Minute)
+
+	// Keep nothing by count: the days window and grace period still apply
+	report, err := repo.GC(&RetentionPolicy{KeepDays: 7}, false)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if got := gcDigests(report.Removed); !slices.Equal(got, []string{old}) {
+		t.Errorf("GC removed %v, expected %v", got, []string{old})
+	}
+
+	report, err = repo.GC(&RetentionPolicy{}, false)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if got := gcDigests(report.Removed); !slices.Equal(got, []string{recent}) {
+		t.Errorf("GC removed %v, expected %v", got, []string{recent})
+	}
+	if got := gcDigests(report.Kept); !slices.Equal(got, []string{fresh}) {
+		t.Errorf("GC kept %v, expected the just-published %v", got, []string{fresh})
+	}
+}
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := LoadRetentionPolicy(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatalf("LoadRetentionPolicy failed: %v", err)
+	}
+	if *policy != DefaultRetention {
+		t.Errorf("Missing policy file should yield the default, got %+v", policy)
+	}
+
+	path := filepath.Join(dir, "retention.json")
+	if err := os.WriteFile(path, []byte(`{"keep_versions": 5, "keep_days": 14}`), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	policy, err = LoadRetentionPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadRetentionPolicy failed: %v", err)
+	}
+	if policy.KeepVersions != 5 || policy.KeepDays != 14 {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	// Omitted fields keep their default
+	if err := os.WriteFile(path, []byte(`{"keep_days": 30}`), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	policy, err = LoadRetentionPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadRetentionPolicy failed: %v", err)
+	}
+	if policy.KeepVersions != DefaultRetention.KeepVersions || policy.KeepDays != 30 {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"keep_versions": -1}`), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	if _, err := LoadRetentionPolicy(path); err == nil {
+		t.Error("Negative keep_versions should be rejected")
+	}
+}
@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// HTTPStore keeps objects on a repository server through its /spores/
+// endpoints. The server verifies uploads, so only spores can be Put, and
+// tags them as name:version like Repo.Put. Objects are read straight from
+// the server without being checked against their digest; Remote is the
+// client that caches and checks downloads.
+type HTTPStore struct {
+	URL    string // base URL, e.g. http://repo.internal:8090
+	Client *http.Client
+}
+
+// Put uploads an object
+func (s *HTTPStore) Put(digest string, r io.Reader) error {
+	return s.put(digest, r, -1)
+}
+
+// put uploads an object of the given size, or of unknown size if negative
+func (s *HTTPStore) put(digest string, r io.Reader, size int64) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(digest), r)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", "application/zip")
+	resp, err := doRequest(s.Client, req, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open returns a reader for an object on the server. Sequential reads
+// stream a single download; random reads are range requests.
+func (s *HTTPStore) Open(digest string) (Blob, error) {
+	obj, err := s.Stat(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &httpBlob{store: s, digest: digest, size: obj.Size}, nil
+}
+
+// Stat returns the size and modification time of an object on the server
+func (s *HTTPStore) Stat(digest string) (Object, error) {
+	if err := CheckDigest(digest); err != nil {
+		return Object{}, err
+	}
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(digest), nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp, err := doRequest(s.Client, req, http.StatusOK)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+
+	obj := Object{Digest: digest, Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.StoredAt = modTime
+	}
+	return obj, nil
+}
+
+// List returns the objects on the server, sorted by digest
+func (s *HTTPStore) List() ([]Object, error) {
+	var objects []Object
+	if err := getJSON(s.Client, s.URL+"/spores/", &objects); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(objects, func(a, b Object) int { return strings.Compare(a.Digest, b.Digest) })
+	return objects, nil
+}
+
+// Delete removes an object from the server, which refuses while it is leased
+func (s *HTTPStore) Delete(digest string) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(s.Client, req, http.StatusNoContent)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// objectURL returns the content-addressed URL of an object
+func (s *HTTPStore) objectURL(digest string) string {
+	return s.URL + "/spores/" + url.PathEscape(digest)
+}
+
+// httpBlob reads an object from a repository server
+type httpBlob struct {
+	store  *HTTPStore
+	digest string
+	size   int64
+	body   io.ReadCloser // download for sequential reads, started on first Read
+}
+
+func (b *httpBlob) Size() int64 { return b.size }
+
+func (b *httpBlob) Read(p []byte) (int, error) {
+	if b.body == nil {
+		req, err := http.NewRequest(http.MethodGet, b.store.objectURL(b.digest), nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := doRequest(b.store.Client, req, http.StatusOK)
+		if err != nil {
+			return 0, err
+		}
+		b.body = resp.Body
+	}
+	return b.body.Read(p)
+}
+
+func (b *httpBlob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), b.size-off)
+	if want == 0 {
+		return 0, nil
+	}
+
+	if err := b.store.readRange(b.digest, p[:want], off); err != nil {
+		return 0, err
+	}
+	if want < int64(len(p)) {
+		return int(want), io.EOF
+	}
+	return int(want), nil
+}
+
+// readRange fills p from an object on the server, starting at off
+func (s *HTTPStore) readRange(digest string, p []byte, off int64) error {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(digest), nil)
+	if err != nil {
+		return err
+	}
+	// Content never changes under a digest, so ranges of it always agree
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := doRequest(s.Client, req, http.StatusPartialContent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.ReadFull(resp.Body, p); err != nil {
+		return fmt.Errorf("failed to read spore %s: %w", digest, err)
+	}
+	return nil
+}
+
+func (b *httpBlob) Close() error {
+	if b.body == nil {
+		return nil
+	}
+	return b.body.Close()
+}
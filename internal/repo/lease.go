@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// leasesDir holds one directory per leased digest, with a file per holder
+const leasesDir = "leases"
+
+// LeaseTTL is how long a lease pins a digest without being renewed
+const LeaseTTL = 10 * time.Minute
+
+var holderPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]*$`)
+
+// Lease pins a digest against garbage collection on behalf of holder, such
+// as an agent sprouting it or the plan that names it. Taking a lease again
+// renews it; a lease not renewed within LeaseTTL expires, so a crashed
+// holder cannot pin a digest forever.
+func (r *Repo) Lease(digest, holder string) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	if !holderPattern.MatchString(holder) {
+		return &RefError{Kind: "lease holder", Value: holder}
+	}
+
+	unlock, err := r.lockIndex(leasesDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir := filepath.Join(r.Dir, leasesDir, digest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create lease directory: %w", err)
+	}
+	now := time.Now()
+	if err := os.WriteFile(filepath.Join(dir, holder), []byte(now.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	return nil
+}
+
+// Release drops holder's lease on a digest
+func (r *Repo) Release(digest, holder string) error {
+	if !IsDigest(digest) || !holderPattern.MatchString(holder) {
+		return &RefError{Kind: "lease", Value: digest + "/" + holder}
+	}
+
+	unlock, err := r.lockIndex(leasesDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir := filepath.Join(r.Dir, leasesDir, digest)
+	if err := os.Remove(filepath.Join(dir, holder)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	// Only succeeds once the last holder is gone
+	os.Remove(dir)
+	return nil
+}
+
+// deleteUnleased removes a stored spore and its leases unless a live holder
+// leases it, and returns those holders. The lease lock is held across the
+// check and the removal, so a lease taken meanwhile always wins. With dryRun
+// only the leases are checked.
+func (r *Repo) deleteUnleased(digest string, dryRun bool) ([]string, error) {
+	unlock, err := r.lockIndex(leasesDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	holders, err := r.leaseHolders(digest, time.Now())
+	if err != nil || len(holders) > 0 || dryRun {
+		return holders, err
+	}
+	if err := r.Store.Delete(digest); err != nil {
+		return nil, err
+	}
+	r.indexes.Delete(digest)
+	os.RemoveAll(filepath.Join(r.Dir, leasesDir, digest))
+	return nil, nil
+}
+
+// leaseHolders returns the holders whose lease on digest has not expired
+func (r *Repo) leaseHolders(digest string, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.Dir, leasesDir, digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leases: %w", err)
+	}
+
+	var holders []string
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) < LeaseTTL {
+			holders = append(holders, e.Name())
+		}
+	}
+	return holders, nil
+}
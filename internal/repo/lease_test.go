@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for _, holder := range []string{"node-1", "plan-test-app"} {
+		if err := repo.Lease(digest, holder); err != nil {
+			t.Fatalf("Lease failed: %v", err)
+		}
+	}
+	if err := repo.Lease(digest, "../escape"); err == nil {
+		t.Error("Lease should reject a holder that is not a plain name")
+	}
+
+	holders, err := repo.leaseHolders(digest, time.Now())
+	if err != nil {
+		t.Fatalf("leaseHolders failed: %v", err)
+	}
+	if !slices.Equal(holders, []string{"node-1", "plan-test-app"}) {
+		t.Errorf("Unexpected holders %v", holders)
+	}
+
+	// Leases that are not renewed expire
+	stale := time.Now().Add(-2 * LeaseTTL)
+	if err := os.Chtimes(filepath.Join(repo.Dir, leasesDir, digest, "node-1"), stale, stale); err != nil {
+		t.Fatalf("Failed to backdate lease: %v", err)
+	}
+	if err := repo.Release(digest, "plan-test-app"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	holders, err = repo.leaseHolders(digest, time.Now())
+	if err != nil {
+		t.Fatalf("leaseHolders failed: %v", err)
+	}
+	if len(holders) != 0 {
+		t.Errorf("Expected no live leases, got %v", holders)
+	}
+}
+
+func TestLeaseWaitsForDelete(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// A lease taken while gc or Delete holds the lease lock waits for it,
+	// rather than landing between their lease check and the removal
+	unlock, err := repo.lockIndex(leasesDir)
+	if err != nil {
+		t.Fatalf("lockIndex failed: %v", err)
+	}
+	leased := make(chan error, 1)
+	go func() { leased <- repo.Lease(digest, "node-1") }()
+	select {
+	case err := <-leased:
+		t.Fatalf("Lease returned while the lease lock was held: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-leased; err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+
+	if err := repo.Delete(digest); !errors.Is(err, ErrLeased) {
+		t.Errorf("Expected ErrLeased, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockSuffix names the lock file kept beside an index file
+const lockSuffix = ".lock"
+
+// lockIndex takes an exclusive lock on an index file in the repo directory,
+// such as tags.json, to hold across reading, changing and saving it. mesh
+// commands and a repo server sharing the directory then never lose each
+// other's updates. The returned function releases the lock.
+func (r *Repo) lockIndex(name string) (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(r.Dir, name+lockSuffix), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock for %s: %w", name, err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", name, err)
+	}
+	// Closing the file releases the lock
+	return func() { f.Close() }, nil
+}
+
+// writeIndex replaces an index file in the repo directory atomically. Each
+// writer stages its own temp file, so concurrent writers never collide.
+func (r *Repo) writeIndex(name string, data []byte) error {
+	tmpFile, err := os.CreateTemp(r.Dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %w", name, err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set %s permissions: %w", name, err)
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(r.Dir, name)); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package repo
+
+import "os"
+
+// lockFile does nothing where flock is unavailable; the repo's in-process
+// mutex still serializes updates from one process
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,339 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// mirrorFile records a mirror's progress against its source
+const mirrorFile = "mirror.json"
+
+// incomingDir holds partial downloads from a remote mirror source, so an
+// interrupted transfer resumes where it stopped
+const incomingDir = "incoming"
+
+// MirrorSource is a repository Mirror copies from: a local Repo or a Remote
+type MirrorSource interface {
+	Open(digest string) (Blob, error)
+	List() ([]Object, error)
+	Tags() (map[string]string, error)
+	Revocations() (*spore.RevocationList, error)
+}
+
+// evicter is a MirrorSource that keeps local copies of what it serves, such
+// as a Remote's download cache, which Mirror drops once an object is stored
+type evicter interface {
+	Evict(digest string) error
+}
+
+// mirrorState is kept in the mirror's directory between passes
+type mirrorState struct {
+	Source   string    `json:"source"`
+	LastSync time.Time `json:"last_sync,omitzero"`
+	// Current maps each source tag to when the mirror last matched it
+	Current map[string]time.Time `json:"current"`
+}
+
+// TagStatus compares a tag in a mirror with its source
+type TagStatus struct {
+	Ref    string        `json:"ref"`
+	Source string        `json:"source,omitempty"` // digest the source names; empty if the source dropped the tag
+	Mirror string        `json:"mirror,omitempty"` // digest the mirror names, if any
+	Lag    time.Duration `json:"lag"`              // how long the mirror has been behind; zero when in sync
+}
+
+// InSync reports whether the mirror's tag names what the source's does
+func (s TagStatus) InSync() bool {
+	return s.Source == s.Mirror
+}
+
+// MirrorStatus reports how far a mirror is behind its source
+type MirrorStatus struct {
+	Source   string      `json:"source"`
+	LastSync time.Time   `json:"last_sync,omitzero"`
+	Missing  int         `json:"missing"` // source spores not yet mirrored
+	Tags     []TagStatus `json:"tags"`
+}
+
+// MirrorFailure is an object or tag a mirror pass could not copy
+type MirrorFailure struct {
+	Digest string `json:"digest,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+	Error  string `json:"error"`
+}
+
+// MirrorReport lists what one Mirror pass did
+type MirrorReport struct {
+	Copied      []Object        `json:"copied"`
+	Failed      []MirrorFailure `json:"failed"`
+	Revocations bool            `json:"revocations"` // whether the revocation list was updated
+	Status      *MirrorStatus   `json:"status"`
+}
+
+// IncomingDir returns the directory partial downloads from a remote mirror
+// source should be kept in
+func (r *Repo) IncomingDir() string {
+	return filepath.Join(r.Dir, incomingDir)
+}
+
+// Mirror makes one pass copying src, named source in the mirror state, into
+// the repo: spores it lacks, ordered so tagged ones come first, then tags
+// and a newer signed revocation list. Every spore is checked against its
+// digest and verified on the way in, as PutReader does; one that fails is
+// reported and retried on the next pass. Tags the source dropped are
+// removed, but spores are not: the mirror's own gc prunes them.
+func (r *Repo) Mirror(src MirrorSource, source string) (*MirrorReport, error) {
+	objects, err := src.List()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := src.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := make(map[string]bool)
+	for _, digest := range tags {
+		tagged[digest] = true
+	}
+	slices.SortStableFunc(objects, func(a, b Object) int {
+		switch {
+		case tagged[a.Digest] == tagged[b.Digest]:
+			return 0
+		case tagged[a.Digest]:
+			return -1
+		}
+		return 1
+	})
+
+	report := &MirrorReport{}
+	for _, obj := range objects {
+		switch _, err := r.Store.Stat(obj.Digest); {
+		case err == nil:
+			continue
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+		if err := r.copyFrom(src, obj.Digest); err != nil {
+			report.Failed = append(report.Failed, MirrorFailure{Digest: obj.Digest, Error: err.Error()})
+			continue
+		}
+		report.Copied = append(report.Copied, obj)
+	}
+
+	failed, err := r.syncTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	report.Failed = append(report.Failed, failed...)
+
+	if report.Revocations, err = r.syncRevocations(src); err != nil {
+		report.Failed = append(report.Failed, MirrorFailure{Ref: revocationsFile, Error: err.Error()})
+	}
+
+	// Hold the mirror state across the update, so concurrent passes do not
+	// lose each other's progress
+	unlock, err := r.lockIndex(mirrorFile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	state, err := r.loadMirrorState(source)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	state.LastSync = now
+	if report.Status, err = r.mirrorStatus(objects, tags, state, now); err != nil {
+		return nil, err
+	}
+	for _, s := range report.Status.Tags {
+		if s.InSync() {
+			state.Current[s.Ref] = now
+		}
+	}
+	maps.DeleteFunc(state.Current, func(ref string, _ time.Time) bool {
+		_, ok := tags[ref]
+		return !ok
+	})
+	if err := r.saveMirrorState(state); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// MirrorStatus compares the repo with src, named source in the mirror
+// state, without copying anything
+func (r *Repo) MirrorStatus(src MirrorSource, source string) (*MirrorStatus, error) {
+	objects, err := src.List()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := src.Tags()
+	if err != nil {
+		return nil, err
+	}
+	state, err := r.loadMirrorState(source)
+	if err != nil {
+		return nil, err
+	}
+	return r.mirrorStatus(objects, tags, state, time.Now())
+}
+
+// copyFrom stores src's copy of a spore, leaving its version tag to
+// syncTags
+func (r *Repo) copyFrom(src MirrorSource, digest string) error {
+	blob, err := src.Open(digest)
+	if err != nil {
+		return err
+	}
+	err = r.putReader(blob, digest, false)
+	blob.Close()
+
+	if e, ok := src.(evicter); ok {
+		e.Evict(digest)
+	}
+	return err
+}
+
+// syncTags points the repo's tags where the source's do, for spores the repo
+// holds, and removes tags the source does not have
+func (r *Repo) syncTags(tags map[string]string) ([]MirrorFailure, error) {
+	current, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []MirrorFailure
+	for _, ref := range slices.Sorted(maps.Keys(tags)) {
+		digest := tags[ref]
+		if current[ref] == digest {
+			continue
+		}
+		if _, err := r.Store.Stat(digest); err != nil {
+			continue // reported with the object
+		}
+		// The source's tags win, even a version tag it moved
+		if err := r.ForceTag(ref, digest); err != nil {
+			failed = append(failed, MirrorFailure{Digest: digest, Ref: ref, Error: err.Error()})
+		}
+	}
+	for _, ref := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := tags[ref]; ok {
+			continue
+		}
+		if err := r.Untag(ref); err != nil {
+			return nil, err
+		}
+	}
+	return failed, nil
+}
+
+// syncRevocations copies the source's revocation list if it is signed and
+// newer than the repo's, and reports whether it did
+func (r *Repo) syncRevocations(src MirrorSource) (bool, error) {
+	rl, err := src.Revocations()
+	if err != nil {
+		return false, err
+	}
+	if rl.Signer == nil {
+		return false, nil
+	}
+	switch err := r.PutRevocations(rl); {
+	case errors.Is(err, ErrStaleRevocations):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// mirrorStatus compares the repo's objects and tags with the source's
+func (r *Repo) mirrorStatus(objects []Object, tags map[string]string, state *mirrorState, now time.Time) (*MirrorStatus, error) {
+	status := &MirrorStatus{Source: state.Source, LastSync: state.LastSync}
+	for _, obj := range objects {
+		switch _, err := r.Store.Stat(obj.Digest); {
+		case errors.Is(err, fs.ErrNotExist):
+			status.Missing++
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	current, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	storedAt := make(map[string]time.Time)
+	for _, obj := range objects {
+		storedAt[obj.Digest] = obj.StoredAt
+	}
+
+	refs := slices.Collect(maps.Keys(tags))
+	for ref := range current {
+		if _, ok := tags[ref]; !ok {
+			refs = append(refs, ref)
+		}
+	}
+	slices.Sort(refs)
+	for _, ref := range refs {
+		s := TagStatus{Ref: ref, Source: tags[ref], Mirror: current[ref]}
+		if !s.InSync() && s.Source != "" {
+			// Behind since the mirror last matched the tag or, failing
+			// that, since the source stored the spore it names
+			since, ok := state.Current[ref]
+			if !ok {
+				since = storedAt[s.Source]
+			}
+			if !since.IsZero() && now.After(since) {
+				s.Lag = now.Sub(since)
+			}
+		}
+		status.Tags = append(status.Tags, s)
+	}
+	return status, nil
+}
+
+// loadMirrorState reads the mirror state. A missing file, or one recorded
+// against another source, yields a fresh state for source.
+func (r *Repo) loadMirrorState(source string) (*mirrorState, error) {
+	state := &mirrorState{Source: source, Current: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(filepath.Join(r.Dir, mirrorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mirror state: %w", err)
+	}
+	var saved mirrorState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse mirror state: %w", err)
+	}
+	if saved.Source != source {
+		return state, nil
+	}
+	if saved.Current == nil {
+		saved.Current = state.Current
+	}
+	return &saved, nil
+}
+
+// saveMirrorState replaces the mirror state atomically. Callers hold the
+// mirror state lock across loading and saving it.
+func (r *Repo) saveMirrorState(state *mirrorState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal mirror state: %w", err)
+	}
+	return r.writeIndex(mirrorFile, data)
+}
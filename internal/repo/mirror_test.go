@@ -0,0 +1,211 @@
+package repo
+
+import (
+	"crypto/ed25519"
+	"maps"
+	"os"
+	"testing"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// behind returns the refs a mirror status reports out of sync
+func behind(st *MirrorStatus) []string {
+	var refs []string
+	for _, s := range st.Tags {
+		if !s.InSync() {
+			refs = append(refs, s.Ref)
+		}
+	}
+	return refs
+}
+
+func TestMirror(t *testing.T) {
+	source, srv := newTestServer(t)
+	mirror, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open mirror: %v", err)
+	}
+	remote, err := NewRemote(srv.URL, mirror.IncomingDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+
+	v1, err := source.Put(packSpore(t, "v1.0.0", "test content 1"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := source.Tag("test-app:latest", v1); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	report, err := mirror.Mirror(remote, srv.URL)
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].Digest != v1 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected first pass %+v", report)
+	}
+	if refs := behind(report.Status); len(refs) != 0 || report.Status.Missing != 0 {
+		t.Errorf("Expected the mirror in sync, got %+v", report.Status)
+	}
+	want, _ := source.Tags()
+	if got, _ := mirror.Tags(); !maps.Equal(got, want) {
+		t.Errorf("Mirror tags %v, want %v", got, want)
+	}
+	// Downloads are dropped once stored
+	if entries, _ := os.ReadDir(mirror.IncomingDir()); len(entries) != 0 {
+		t.Errorf("Expected an empty incoming directory, got %d entries", len(entries))
+	}
+
+	// Nothing to copy on the next pass
+	report, err = mirror.Mirror(remote, srv.URL)
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(report.Copied) != 0 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected second pass %+v", report)
+	}
+
+	// The source moves ahead
+	v2, err := source.Put(packSpore(t, "v2.0.0", "test content 2"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := source.Tag("test-app:latest", v2); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	if err := source.Untag("test-app:v1.0.0"); err != nil {
+		t.Fatalf("Untag failed: %v", err)
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	rl := &spore.RevocationList{}
+	rl.RevokeDigest(v1, "test")
+	if err := rl.Sign(priv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := source.PutRevocations(rl); err != nil {
+		t.Fatalf("PutRevocations failed: %v", err)
+	}
+
+	st, err := mirror.MirrorStatus(remote, srv.URL)
+	if err != nil {
+		t.Fatalf("MirrorStatus failed: %v", err)
+	}
+	if st.Missing != 1 || st.LastSync.IsZero() {
+		t.Errorf("Unexpected status %+v", st)
+	}
+	refs := behind(st)
+	wantRefs := []string{"test-app:latest", "test-app:v1.0.0", "test-app:v2.0.0"}
+	if len(refs) != len(wantRefs) {
+		t.Fatalf("Tags behind %v, want %v", refs, wantRefs)
+	}
+	for _, s := range st.Tags {
+		if s.Ref == "test-app:latest" && (s.Source != v2 || s.Mirror != v1 || s.Lag <= 0) {
+			t.Errorf("Unexpected status for latest %+v", s)
+		}
+	}
+
+	report, err = mirror.Mirror(remote, srv.URL)
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].Digest != v2 || !report.Revocations {
+		t.Errorf("Unexpected third pass %+v", report)
+	}
+	if refs := behind(report.Status); len(refs) != 0 {
+		t.Errorf("Tags still behind: %v", refs)
+	}
+	want, _ = source.Tags()
+	if got, _ := mirror.Tags(); !maps.Equal(got, want) {
+		t.Errorf("Mirror tags %v, want %v", got, want)
+	}
+	// Spores the source untagged stay until the mirror's own gc
+	if _, err := mirror.Store.Stat(v1); err != nil {
+		t.Errorf("Mirror dropped spore %s: %v", v1, err)
+	}
+	mirrored, _ := mirror.Revocations()
+	if err := mirrored.Check(v1, &spore.Manifest{}); err == nil {
+		t.Errorf("Expected the mirror's revocation list to revoke %s", v1)
+	}
+
+	// The mirror's files are ones fsck knows
+	fsck, err := mirror.Fsck(nil, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(fsck.Orphans) != 0 {
+		t.Errorf("Unexpected orphans %+v", fsck.Orphans)
+	}
+
+	// The source moves a version tag to a rebuilt spore
+	if err := source.Untag("test-app:v2.0.0"); err != nil {
+		t.Fatalf("Untag failed: %v", err)
+	}
+	rebuilt, err := source.Put(packSpore(t, "v2.0.0", "test content 2 rebuilt"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	report, err = mirror.Mirror(remote, srv.URL)
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].Digest != rebuilt || len(report.Failed) != 0 {
+		t.Errorf("Unexpected pass after a moved version tag %+v", report)
+	}
+	if refs := behind(report.Status); len(refs) != 0 || report.Status.Missing != 0 {
+		t.Errorf("Expected the mirror in sync, got %+v", report.Status)
+	}
+	if tags, _ := mirror.Tags(); tags["test-app:v2.0.0"] != rebuilt {
+		t.Errorf("Mirror's version tag names %s, expected %s", tags["test-app:v2.0.0"], rebuilt)
+	}
+}
+
+func TestMirrorRejectsCorruptSource(t *testing.T) {
+	source, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	mirror, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open mirror: %v", err)
+	}
+
+	digest, err := source.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	good := corrupt(t, source.objectPath(digest))
+
+	report, err := mirror.Mirror(source, source.Dir)
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(report.Copied) != 0 || len(report.Failed) != 1 || report.Failed[0].Digest != digest {
+		t.Errorf("Expected the corrupt spore to fail, got %+v", report)
+	}
+	if _, err := mirror.Store.Stat(digest); err == nil {
+		t.Errorf("Corrupt spore was mirrored")
+	}
+	if tags, _ := mirror.Tags(); len(tags) != 0 {
+		t.Errorf("Expected no tags for a missing spore, got %v", tags)
+	}
+	if report.Status.Missing != 1 {
+		t.Errorf("Expected 1 missing spore, got %d", report.Status.Missing)
+	}
+
+	// Retried once the source is repaired
+	if err := os.WriteFile(source.objectPath(digest), good, 0644); err != nil {
+		t.Fatalf("Failed to repair spore: %v", err)
+	}
+	report, err = mirror.Mirror(source, source.Dir)
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(report.Copied) != 1 || len(report.Failed) != 0 || len(behind(report.Status)) != 0 {
+		t.Errorf("Unexpected pass after repair %+v", report)
+	}
+}
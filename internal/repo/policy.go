@@ -0,0 +1,214 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// policyFile is the default publish policy, read from the repo directory
+const policyFile = "policy.json"
+
+// PublishPolicy decides which verified spores the repo accepts on publish.
+// Env and app name rules take path.Match patterns, e.g. AWS_* or billing-*.
+// The zero PublishPolicy accepts every spore that verifies.
+type PublishPolicy struct {
+	RequiredSigners []string `json:"required_signers,omitempty"` // key IDs that must all sign
+	RequiredFields  []string `json:"required_fields,omitempty"`  // manifest fields by JSON path, e.g. slo.p99_budget_ms
+	MaxBinarySize   int64    `json:"max_binary_size,omitempty"`  // bytes, for each binary
+	ForbiddenEnv    []string `json:"forbidden_env,omitempty"`    // env var names
+	RequireSLO      bool     `json:"require_slo,omitempty"`      // a p99 budget must be set
+	MaxP99BudgetMs  int      `json:"max_p99_budget_ms,omitempty"`
+	ReadOnlyFS      []string `json:"read_only_fs,omitempty"` // app names that must set security.read_only_fs
+}
+
+// Violation is one publish policy rule a spore breaks
+type Violation struct {
+	Rule   string `json:"rule"`            // the policy field, e.g. max_binary_size
+	Field  string `json:"field,omitempty"` // the manifest field or archive entry at fault
+	Detail string `json:"detail"`
+}
+
+// PublishPolicyError is returned when a spore breaks the repo's publish
+// policy. It lists every rule broken, not just the first.
+type PublishPolicyError struct {
+	Ref        string // name:version, if known
+	Violations []Violation
+}
+
+func (e *PublishPolicyError) Error() string {
+	details := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		details[i] = v.Detail
+	}
+	subject := "spore"
+	if e.Ref != "" {
+		subject += " " + e.Ref
+	}
+	return fmt.Sprintf("%s violates publish policy: %s", subject, strings.Join(details, "; "))
+}
+
+// PolicyPath returns the path of the repo's default publish policy
+func (r *Repo) PolicyPath() string {
+	return filepath.Join(r.Dir, policyFile)
+}
+
+// LoadPublishPolicy reads a publish policy file. A missing file yields the
+// zero policy.
+func LoadPublishPolicy(path string) (*PublishPolicy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &PublishPolicy{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read publish policy: %w", err)
+	}
+
+	var policy PublishPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse publish policy: %w", err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("publish policy %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+// validate rejects policies that could never be applied as written
+func (p *PublishPolicy) validate() error {
+	if p.MaxBinarySize < 0 || p.MaxP99BudgetMs < 0 {
+		return errors.New("max_binary_size and max_p99_budget_ms must not be negative")
+	}
+	if slices.Contains(p.RequiredSigners, "") || slices.Contains(p.RequiredFields, "") {
+		return errors.New("required_signers and required_fields must not contain empty entries")
+	}
+	for _, pattern := range slices.Concat(p.ForbiddenEnv, p.ReadOnlyFS) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Check applies the policy to an inspected spore that has verified, and
+// returns a PublishPolicyError listing every violation
+func (p *PublishPolicy) Check(insp *spore.Inspection) error {
+	m := insp.Manifest
+	var violations []Violation
+
+	signers := make(map[string]bool)
+	for _, s := range insp.Signers {
+		signers[s.KeyID] = true
+	}
+	for _, keyID := range p.RequiredSigners {
+		if !signers[keyID] {
+			violations = append(violations, Violation{Rule: "required_signers", Detail: "not signed by required key " + keyID})
+		}
+	}
+
+	if len(p.RequiredFields) > 0 {
+		fields, err := manifestFields(m)
+		if err != nil {
+			return err
+		}
+		for _, field := range p.RequiredFields {
+			if !fieldSet(fields, field) {
+				violations = append(violations, Violation{Rule: "required_fields", Field: field, Detail: "manifest field " + field + " is not set"})
+			}
+		}
+	}
+
+	if p.MaxBinarySize > 0 {
+		for _, e := range insp.Entries {
+			if e.Name != "binary" && !strings.HasPrefix(e.Name, "binaries/") {
+				continue
+			}
+			if e.Size > p.MaxBinarySize {
+				violations = append(violations, Violation{Rule: "max_binary_size", Field: e.Name,
+					Detail: fmt.Sprintf("%s is %d bytes, over the %d byte limit", e.Name, e.Size, p.MaxBinarySize)})
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.Env)) {
+		if matchAny(p.ForbiddenEnv, name) {
+			violations = append(violations, Violation{Rule: "forbidden_env", Field: "env." + name, Detail: "env var " + name + " is forbidden"})
+		}
+	}
+
+	switch budget := m.SLO.P99BudgetMs; {
+	case p.RequireSLO && budget <= 0:
+		violations = append(violations, Violation{Rule: "require_slo", Field: "slo.p99_budget_ms", Detail: "no p99 latency budget set"})
+	case p.MaxP99BudgetMs > 0 && budget > p.MaxP99BudgetMs:
+		violations = append(violations, Violation{Rule: "max_p99_budget_ms", Field: "slo.p99_budget_ms",
+			Detail: fmt.Sprintf("p99 budget of %dms is over the %dms limit", budget, p.MaxP99BudgetMs)})
+	}
+
+	if matchAny(p.ReadOnlyFS, m.Name) && !m.Security.ReadOnlyFS {
+		violations = append(violations, Violation{Rule: "read_only_fs", Field: "security.read_only_fs", Detail: "app " + m.Name + " must run with a read-only filesystem"})
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PublishPolicyError{Ref: versionRef(m), Violations: violations}
+}
+
+// manifestFields returns the manifest as generic JSON, so required fields
+// can be named the way manifests spell them
+func manifestFields(m *spore.Manifest) (map[string]any, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	return fields, nil
+}
+
+// fieldSet reports whether the dotted JSON path names a field with a
+// non-zero value
+func fieldSet(fields map[string]any, field string) bool {
+	var value any = fields
+	for _, key := range strings.Split(field, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return false
+		}
+		value = obj[key]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case bool:
+		return v
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
+
+// matchAny reports whether name matches one of the patterns
+func matchAny(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	})
+}
@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// packManifest packs a spore for a manifest, signed by priv
+func packManifest(t *testing.T, m spore.Manifest, priv ed25519.PrivateKey) string {
+	t.Helper()
+
+	payload := spore.Payload{Binary: strings.NewReader("test content " + m.Version)}
+	sporePath, _, err := spore.PackPayload(payload, m, priv, t.TempDir())
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+	return sporePath
+}
+
+// violatedRules returns the rules a policy error lists
+func violatedRules(err error) []string {
+	var policyErr *PublishPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+	var rules []string
+	for _, v := range policyErr.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPublishPolicy(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	repo.Policy = &PublishPolicy{
+		RequiredSigners: []string{spore.Fingerprint(pub)},
+		RequiredFields:  []string{"security.lsm_profile"},
+		MaxBinarySize:   64,
+		ForbiddenEnv:    []string{"AWS_*"},
+		RequireSLO:      true,
+		ReadOnlyFS:      []string{"test-*"},
+	}
+
+	bad := spore.Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Env:       map[string]string{"AWS_SECRET_ACCESS_KEY": "x", "PORT": "8080"},
+		Nutrients: spore.Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	_, err = repo.Put(packManifest(t, bad, otherPriv))
+	want := []string{"required_signers", "required_fields", "forbidden_env", "require_slo", "read_only_fs"}
+	if got := violatedRules(err); !slices.Equal(got, want) {
+		t.Errorf("Violated rules %v, want %v (%v)", got, want, err)
+	}
+	if objects, _ := repo.List(); len(objects) != 0 {
+		t.Errorf("Refused spore was stored: %+v", objects)
+	}
+	if tags, _ := repo.Tags(); len(tags) != 0 {
+		t.Errorf("Refused spore was tagged: %v", tags)
+	}
+
+	good := bad
+	good.Version = "v1.0.1"
+	good.Env = map[string]string{"PORT": "8080"}
+	good.SLO = spore.SLO{P99BudgetMs: 50}
+	good.Security = spore.Security{LSMProfile: "default", ReadOnlyFS: true}
+	if _, err := repo.Put(packManifest(t, good, priv)); err != nil {
+		t.Errorf("Put of a compliant spore failed: %v", err)
+	}
+
+	// Binary size
+	repo.Policy.MaxBinarySize = 4
+	good.Version = "v1.0.2"
+	_, err = repo.Put(packManifest(t, good, priv))
+	if got := violatedRules(err); !slices.Equal(got, []string{"max_binary_size"}) {
+		t.Errorf("Violated rules %v, want max_binary_size (%v)", got, err)
+	}
+}
+
+func TestServerPublishPolicy(t *testing.T) {
+	repo, srv := newTestServer(t)
+	repo.Policy = &PublishPolicy{RequireSLO: true}
+	remote, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+
+	// Violations come back structured from a repo server too
+	_, err = remote.Put(packSpore(t, "v1.0.0", "test content"))
+	var policyErr *PublishPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Expected PublishPolicyError, got %v", err)
+	}
+	if len(policyErr.Violations) != 1 || policyErr.Violations[0].Field != "slo.p99_budget_ms" {
+		t.Errorf("Unexpected violations %+v", policyErr.Violations)
+	}
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != 422 || remoteErr.Reason != spore.ReasonPolicy {
+		t.Errorf("Expected a 422 policy refusal, got %v", err)
+	}
+}
+
+func TestLoadPublishPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := LoadPublishPolicy(filepath.Join(dir, "missing.json"))
+	if err != nil || len(policy.RequiredSigners) != 0 || policy.RequireSLO {
+		t.Errorf("Expected the zero policy for a missing file, got %+v, %v", policy, err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", `{"forbidden_env": ["AWS_*"], "read_only_fs": ["billing"], "max_binary_size": 1048576}`, false},
+		{"bad pattern", `{"forbidden_env": ["["]}`, true},
+		{"negative size", `{"max_binary_size": -1}`, true},
+		{"empty signer", `{"required_signers": [""]}`, true},
+		{"not json", `forbid everything`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatalf("Failed to write policy: %v", err)
+			}
+			if _, err := LoadPublishPolicy(path); (err != nil) != tt.wantErr {
+				t.Errorf("LoadPublishPolicy error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
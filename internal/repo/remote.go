@@ -0,0 +1,390 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// Remote is a client for a repository served by Server. Downloaded spores
+// are kept in CacheDir once they hash to their digest; an interrupted
+// download resumes with a range request.
+type Remote struct {
+	URL      string // base URL, e.g. http://repo.internal:8090
+	CacheDir string
+	Client   *http.Client
+
+	mu sync.Mutex // serializes downloads into CacheDir
+}
+
+// RemoteError is an error response from a repository server
+type RemoteError struct {
+	StatusCode int
+	Reason     string // spore.Reason code, if the server gave one
+	Message    string
+	Violations []Violation // publish policy violations, if the server gave any
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("repo server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is makes a 404 match fs.ErrNotExist, a rejected digest match
+// ErrDigestMismatch, a 405 match ErrReadOnly and a 401 match ErrUnauthorized
+func (e *RemoteError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case fs.ErrNotExist:
+		return e.StatusCode == http.StatusNotFound
+	case ErrDigestMismatch:
+		return e.StatusCode == http.StatusBadRequest && e.Reason == spore.ReasonHashMismatch
+	case ErrReadOnly:
+		return e.StatusCode == http.StatusMethodNotAllowed
+	}
+	return false
+}
+
+// As makes a publish policy refusal match PublishPolicyError
+func (e *RemoteError) As(target any) bool {
+	policyErr, ok := target.(**PublishPolicyError)
+	if !ok || e.Reason != spore.ReasonPolicy || len(e.Violations) == 0 {
+		return false
+	}
+	*policyErr = &PublishPolicyError{Violations: e.Violations}
+	return true
+}
+
+// IsRemote reports whether a repository location is a URL rather than a
+// directory
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// NewRemote creates a client for the repository server at baseURL, caching
+// spores in cacheDir
+func NewRemote(baseURL, cacheDir string) (*Remote, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid repository URL %q", baseURL)
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Remote{URL: strings.TrimSuffix(baseURL, "/"), CacheDir: cacheDir, Client: http.DefaultClient}, nil
+}
+
+// TokenClient returns an HTTP client that sends token as a bearer token,
+// for repository servers that require one for writes
+func TokenClient(token string) *http.Client {
+	return &http.Client{Transport: &tokenTransport{token: token, base: http.DefaultTransport}}
+}
+
+// tokenTransport adds a bearer token to every request
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// Fetch returns the path of a cached copy of a spore, downloading it first
+// if needed. A download that does not hash to digest is discarded.
+func (rm *Remote) Fetch(digest string) (string, error) {
+	if err := CheckDigest(digest); err != nil {
+		return "", err
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	path := filepath.Join(rm.CacheDir, digest+".spore")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := rm.download(digest, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// download fetches a spore into path, resuming from path.part if an earlier
+// download was cut short
+func (rm *Remote) download(digest, path string) (err error) {
+	partPath := path + ".part"
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer func() {
+		part.Close()
+		if errors.Is(err, ErrDigestMismatch) {
+			os.Remove(partPath)
+		}
+	}()
+
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to read download file: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rm.objects().objectURL(digest), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", `"`+digest+`"`)
+	}
+	resp, err := doRequest(rm.Client, req, http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Start over unless the server continued where we left off; a range
+	// past the end means the partial file is already complete
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := part.Truncate(0); err != nil {
+			return fmt.Errorf("failed to reset download file: %w", err)
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to reset download file: %w", err)
+		}
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		body = http.NoBody
+	}
+
+	if _, err := io.Copy(part, body); err != nil {
+		return fmt.Errorf("failed to download spore %s: %w", digest, err)
+	}
+
+	// Check what arrived, including any resumed prefix
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read download file: %w", err)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, part); err != nil {
+		return fmt.Errorf("failed to hash download: %w", err)
+	}
+	if got := fmt.Sprintf("%x", hasher.Sum(nil)); got != digest {
+		return fmt.Errorf("%w: downloaded spore hashes to %s, expected %s", ErrDigestMismatch, got, digest)
+	}
+
+	if err := part.Sync(); err != nil {
+		return fmt.Errorf("failed to sync download: %w", err)
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return fmt.Errorf("failed to move download into cache: %w", err)
+	}
+	return nil
+}
+
+// Open returns a reader for a cached copy of a spore, downloading it first
+// if needed
+func (rm *Remote) Open(digest string) (Blob, error) {
+	path, err := rm.Fetch(digest)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(path)
+}
+
+// Evict drops the cached copy of a spore
+func (rm *Remote) Evict(digest string) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	err := os.Remove(filepath.Join(rm.CacheDir, digest+".spore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Put uploads a spore file and returns its digest. The server verifies it
+// and tags it as name:version, like Repo.Put.
+func (rm *Remote) Put(sporePath string) (digest string, err error) {
+	file, err := os.Open(sporePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open spore file: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute digest: %w", err)
+	}
+	digest = fmt.Sprintf("%x", hasher.Sum(nil))
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind spore file: %w", err)
+	}
+
+	if err := rm.objects().put(digest, file, size); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Stat returns the size of a stored spore
+func (rm *Remote) Stat(digest string) (Object, error) {
+	return rm.objects().Stat(digest)
+}
+
+// List returns the stored spores, sorted by digest
+func (rm *Remote) List() ([]Object, error) {
+	return rm.objects().List()
+}
+
+// objects returns the server's object store
+func (rm *Remote) objects() *HTTPStore {
+	return &HTTPStore{URL: rm.URL, Client: rm.Client}
+}
+
+// Tags returns the tag index, mapping name:tag to digest
+func (rm *Remote) Tags() (map[string]string, error) {
+	tags := make(map[string]string)
+	if err := getJSON(rm.Client, rm.URL+"/tags", &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Resolve returns the digest a digest, digest prefix or name:tag reference
+// names
+func (rm *Remote) Resolve(ref string) (string, error) {
+	return resolveRef(ref, rm.Tags, rm.List)
+}
+
+// Tag points a name:tag reference at a stored spore. The server refuses to
+// move a version tag.
+func (rm *Remote) Tag(ref, digest string) error {
+	return rm.tag(ref, digest, false)
+}
+
+// ForceTag points a name:tag reference at a stored spore, moving version
+// tags too
+func (rm *Remote) ForceTag(ref, digest string) error {
+	return rm.tag(ref, digest, true)
+}
+
+func (rm *Remote) tag(ref, digest string, force bool) error {
+	if _, _, err := ParseRef(ref); err != nil {
+		return err
+	}
+	tagURL := rm.URL + "/tags/" + url.PathEscape(ref)
+	if force {
+		tagURL += "?force=1"
+	}
+	req, err := http.NewRequest(http.MethodPut, tagURL, strings.NewReader(digest))
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(rm.Client, req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Revocations returns the server's revocation list. Callers must Verify it
+// against their keyring before use.
+func (rm *Remote) Revocations() (*spore.RevocationList, error) {
+	var rl spore.RevocationList
+	if err := getJSON(rm.Client, rm.URL+"/revocations.json", &rl); err != nil {
+		return nil, err
+	}
+	return &rl, nil
+}
+
+// Lease takes or renews holder's lease on a digest on the server
+func (rm *Remote) Lease(digest, holder string) error {
+	return rm.lease(http.MethodPost, digest, holder)
+}
+
+// Release drops holder's lease on a digest on the server
+func (rm *Remote) Release(digest, holder string) error {
+	return rm.lease(http.MethodDelete, digest, holder)
+}
+
+func (rm *Remote) lease(method, digest, holder string) error {
+	req, err := http.NewRequest(method, rm.URL+"/leases/"+url.PathEscape(digest)+"/"+url.PathEscape(holder), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(rm.Client, req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getJSON decodes the JSON response to a GET request
+func getJSON(client *http.Client, url string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(client, req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// doRequest sends a request and returns a RemoteError unless the response
+// has one of the wanted statuses
+func doRequest(client *http.Client, req *http.Request, want ...int) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("repo server unreachable: %w", err)
+	}
+	if slices.Contains(want, resp.StatusCode) {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	remoteErr := &RemoteError{StatusCode: resp.StatusCode, Message: resp.Status}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var errResp errorResponse
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		remoteErr.Message = errResp.Error
+		remoteErr.Reason = errResp.Reason
+		remoteErr.Violations = errResp.Violations
+	} else if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		remoteErr.Message = string(trimmed)
+	}
+	return nil, remoteErr
+}
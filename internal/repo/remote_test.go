@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemotePutAndFetch(t *testing.T) {
+	repo, srv := newTestServer(t)
+	remote, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+
+	sporePath := packSpore(t, "v1.0.0", "test content")
+	digest, err := remote.Put(sporePath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := os.Stat(repo.objectPath(digest)); err != nil {
+		t.Errorf("Uploaded spore not stored on the server: %v", err)
+	}
+
+	// Tags and listing come from the server
+	got, err := remote.Resolve("test-app:v1.0.0")
+	if err != nil || got != digest {
+		t.Errorf("Resolve returned %s, %v; expected %s", got, err, digest)
+	}
+	objects, err := remote.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	obj, err := remote.Stat(digest)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Digest != obj.Digest || objects[0].Size != obj.Size {
+		t.Errorf("List returned %+v, Stat %+v", objects, obj)
+	}
+
+	// Fetch downloads into the cache
+	cached, err := remote.Fetch(digest)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	want, _ := os.ReadFile(sporePath)
+	if data, _ := os.ReadFile(cached); !bytes.Equal(data, want) {
+		t.Error("Fetched spore differs from the published one")
+	}
+
+	// and later fetches do not need the server
+	srv.Close()
+	if again, err := remote.Fetch(digest); err != nil || again != cached {
+		t.Errorf("Cached Fetch returned %s, %v", again, err)
+	}
+}
+
+func TestRemoteFetchResumes(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	data, _ := os.ReadFile(repo.objectPath(digest))
+
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	server := NewServer(repo)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		mu.Unlock()
+		server.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	// Leave half a download behind, as an interrupted fetch would
+	cacheDir := t.TempDir()
+	half := len(data) / 2
+	if err := os.WriteFile(filepath.Join(cacheDir, digest+".spore.part"), data[:half], 0644); err != nil {
+		t.Fatalf("Failed to write partial download: %v", err)
+	}
+
+	remote, err := NewRemote(srv.URL, cacheDir)
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+	cached, err := remote.Fetch(digest)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if got, _ := os.ReadFile(cached); !bytes.Equal(got, data) {
+		t.Error("Resumed download differs from the stored spore")
+	}
+	if want := []string{fmt.Sprintf("bytes=%d-", half)}; !slices.Equal(ranges, want) {
+		t.Errorf("Server saw ranges %q, expected %q", ranges, want)
+	}
+}
+
+func TestRemoteFetchRejectsCorruptDownload(t *testing.T) {
+	digest := "0000000000000000000000000000000000000000000000000000000000000000"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("not what you asked for"))
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+	remote, err := NewRemote(srv.URL, cacheDir)
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+	if _, err := remote.Fetch(digest); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("Expected ErrDigestMismatch, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(cacheDir)
+	for _, e := range entries {
+		t.Errorf("Corrupt download left %s in the cache", e.Name())
+	}
+}
+
+func TestRemoteLeasesAndRevocations(t *testing.T) {
+	repo, srv := newTestServer(t)
+	remote, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if err := remote.Lease(digest, "node-1"); err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	holders, _ := repo.leaseHolders(digest, time.Now())
+	if !slices.Equal(holders, []string{"node-1"}) {
+		t.Errorf("Server holds leases %v, expected node-1", holders)
+	}
+	if err := remote.Release(digest, "node-1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	holders, _ = repo.leaseHolders(digest, time.Now())
+	if len(holders) != 0 {
+		t.Errorf("Lease not released: %v", holders)
+	}
+
+	rl, err := remote.Revocations()
+	if err != nil {
+		t.Fatalf("Revocations failed: %v", err)
+	}
+	if len(rl.Digests) != 0 {
+		t.Errorf("Expected an empty revocation list, got %+v", rl)
+	}
+
+	// A missing spore is reported as not found
+	_, err = remote.Fetch("1111111111111111111111111111111111111111111111111111111111111111")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
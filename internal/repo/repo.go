@@ -2,70 +2,339 @@ package repo
 
 import (
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// revocationsFile holds the signed revocation list the repo serves
+const revocationsFile = "revocations.json"
+
+var (
+	// ErrDigestMismatch is returned when a spore does not hash to its digest
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrInvalidSpore wraps the verification error of a spore the repo refuses
+	ErrInvalidSpore = errors.New("invalid spore")
+	// ErrLeased is returned when deleting a spore a holder still leases
+	ErrLeased = errors.New("spore is leased")
+	// ErrReadOnly is returned by a read-only Server for writes
+	ErrReadOnly = errors.New("repository is read-only")
+	// ErrUnauthorized is returned by a Server with a Token for writes that
+	// do not carry it
+	ErrUnauthorized = errors.New("repository write token required")
+	// ErrStaleRevocations is returned when publishing a revocation list that
+	// is not newer than the repo's
+	ErrStaleRevocations = errors.New("revocation list is not newer than the current one")
 )
 
-// Repo represents a content-addressed repository
+// Source is where agents get spores from: a local Repo or a Remote one
+type Source interface {
+	// Open returns a reader for a stored spore
+	Open(digest string) (Blob, error)
+	Revocations() (*spore.RevocationList, error)
+	Lease(digest, holder string) error
+	Release(digest, holder string) error
+}
+
+// Object describes a stored spore
+type Object struct {
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	StoredAt time.Time `json:"stored_at,omitzero"`
+}
+
+// Repo represents a content-addressed repository. Spores are kept in Store;
+// tags, leases and the revocation list are files in Dir.
 type Repo struct {
-	Dir string
+	Dir   string
+	Store Store
+	// ChunkSize is the chunk size of ChunkIndex; zero means DefaultChunkSize
+	ChunkSize int64
+	// Policy is checked against every spore before it is stored; nil
+	// accepts any spore that verifies
+	Policy *PublishPolicy
+
+	mu      sync.Mutex // guards the tag index
+	indexes sync.Map   // digest -> *ChunkIndex; content never changes under a digest
 }
 
-// Open creates or opens a repository
+// New returns a repository with its metadata in dir and its spores in store
+func New(dir string, store Store) *Repo {
+	return &Repo{Dir: dir, Store: store}
+}
+
+// Open creates or opens a repository that keeps its spores in dir
 func Open(dir string) (*Repo, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create repo directory: %w", err)
 	}
-	return &Repo{Dir: dir}, nil
+	return New(dir, &FileStore{Dir: dir}), nil
 }
 
-// Put stores a spore file and returns its digest
-func (r *Repo) Put(sporePath string) (digest string, storedPath string, err error) {
+// Put stores a spore file and returns its digest. The spore must verify,
+// and satisfy the repo's Policy, before it is accepted. The store never
+// shows a partial spore under a digest, even after a crash or concurrent
+// Put, and a digest already present is not copied again. Put tags the spore
+// as name:version from its manifest.
+func (r *Repo) Put(sporePath string) (digest string, err error) {
 	// Read the spore file
 	file, err := os.Open(sporePath)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to open spore file: %w", err)
+		return "", fmt.Errorf("failed to open spore file: %w", err)
 	}
 	defer file.Close()
 
 	// Compute SHA256 digest
 	hasher := sha256.New()
 	if _, err := io.Copy(hasher, file); err != nil {
-		return "", "", fmt.Errorf("failed to compute digest: %w", err)
+		return "", fmt.Errorf("failed to compute digest: %w", err)
 	}
 
 	digest = fmt.Sprintf("%x", hasher.Sum(nil))
-	storedPath = filepath.Join(r.Dir, digest+".spore")
 
 	// Copy file to repository
-	if err := r.copyFile(sporePath, storedPath); err != nil {
-		return "", "", fmt.Errorf("failed to copy file to repo: %w", err)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind spore file: %w", err)
+	}
+	if err := r.PutReader(file, digest); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// PutReader stores a spore read from src, such as an upload, that must hash
+// to digest. It has the same guarantees as Put; if the digest is already
+// stored, src is not read.
+func (r *Repo) PutReader(src io.Reader, digest string) error {
+	return r.putReader(src, digest, true)
+}
+
+// putReader is PutReader, tagging the spore as name:version only if
+// tagVersion is set. A mirror stores spores untagged and takes its tags from
+// the source, which may have moved a version tag.
+func (r *Repo) putReader(src io.Reader, digest string, tagVersion bool) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+
+	// Content-addressed, so an existing object is already this spore
+	switch _, err := r.Store.Stat(digest); {
+	case err == nil && !tagVersion:
+		return nil
+	case err == nil:
+		insp, err := r.inspect(digest)
+		if err != nil {
+			return err
+		}
+		return r.tagVersion(insp.Manifest, digest)
+	case errors.Is(err, fs.ErrNotExist):
+		return r.store(src, digest, tagVersion)
+	default:
+		return err
+	}
+}
+
+// Open returns a reader for the stored spore with the given digest
+func (r *Repo) Open(digest string) (Blob, error) {
+	return r.Store.Open(digest)
+}
+
+// List returns the stored spores, sorted by digest
+func (r *Repo) List() ([]Object, error) {
+	return r.Store.List()
+}
+
+// Delete removes a spore and the tags that name it. A spore leased by a live
+// holder is not removed.
+func (r *Repo) Delete(digest string) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	holders, err := r.deleteUnleased(digest, false)
+	if err != nil {
+		return err
+	}
+	if len(holders) > 0 {
+		return fmt.Errorf("%w: %s by %s", ErrLeased, digest, strings.Join(holders, ", "))
+	}
+	return r.dropTags(map[string]bool{digest: true})
+}
+
+// Revocations returns the repo's revocation list, which is empty if none was
+// published. Callers must Verify it against their keyring before use.
+func (r *Repo) Revocations() (*spore.RevocationList, error) {
+	return spore.LoadRevocationList(filepath.Join(r.Dir, revocationsFile))
+}
+
+// PutRevocations publishes a signed revocation list, replacing the current
+// one. The list must have been issued after the current one, so an old list
+// replayed, or one built from a list since replaced, cannot drop revocations.
+func (r *Repo) PutRevocations(rl *spore.RevocationList) error {
+	unlock, err := r.lockIndex(revocationsFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return r.putRevocations(rl)
+}
+
+// UpdateRevocations calls update on the current revocation list and
+// publishes the result, which update must sign. The list is held from
+// reading it to publishing it, so concurrent updates are not lost.
+func (r *Repo) UpdateRevocations(update func(rl *spore.RevocationList) error) error {
+	unlock, err := r.lockIndex(revocationsFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rl, err := r.Revocations()
+	if err != nil {
+		return err
+	}
+	if err := update(rl); err != nil {
+		return err
+	}
+	return r.putRevocations(rl)
+}
+
+// putRevocations replaces the revocation list. Callers hold its lock.
+func (r *Repo) putRevocations(rl *spore.RevocationList) error {
+	if err := rl.Verify(nil); err != nil {
+		return err
+	}
+	current, err := r.Revocations()
+	if err != nil {
+		return err
+	}
+	if !rl.IssuedAt.After(current.IssuedAt) {
+		return fmt.Errorf("%w: issued %s, current list issued %s", ErrStaleRevocations,
+			rl.IssuedAt.Format(time.RFC3339Nano), current.IssuedAt.Format(time.RFC3339Nano))
 	}
 
-	return digest, storedPath, nil
+	data, err := json.MarshalIndent(rl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal revocation list: %w", err)
+	}
+	return r.writeIndex(revocationsFile, data)
 }
 
-// Path returns the file path for a given digest
-func (r *Repo) Path(digest string) string {
-	return filepath.Join(r.Dir, digest+".spore")
+// inspect reads the manifest of a stored spore
+func (r *Repo) inspect(digest string) (*spore.Inspection, error) {
+	blob, err := r.Store.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	return spore.InspectReader(blob, blob.Size())
 }
 
-// copyFile copies a file from src to dst
-func (r *Repo) copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// store stages src in a temporary file, checks that it hashes to digest and
+// verifies as a spore, then hands it to the store and, if tagVersion is set,
+// tags it as name:version. A FileStore takes the staged file as is.
+func (r *Repo) store(src io.Reader, digest string, tagVersion bool) (err error) {
+	fileStore, adopt := r.Store.(*FileStore)
+	stageDir := r.Dir
+	if adopt {
+		stageDir = fileStore.Dir
+	}
+	tmpFile, err := os.CreateTemp(stageDir, ".put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file in repo: %w", err)
+	}
+	defer func() {
+		tmpFile.Close()
+		if err != nil || !adopt {
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hasher), src)
+	if err != nil {
+		return fmt.Errorf("failed to copy file to repo: %w", err)
+	}
+	if err := checkDigest(hasher, digest); err != nil {
+		return err
+	}
+
+	// Verify the copy, so what is stored is exactly what was checked
+	manifest, err := spore.VerifyReader(tmpFile, size, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSpore, err)
+	}
+
+	if r.Policy != nil {
+		insp, err := spore.InspectReader(tmpFile, size)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSpore, err)
+		}
+		if err := r.Policy.Check(insp); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spore: %w", err)
+	}
+	if !tagVersion {
+		return r.putStaged(fileStore, digest, tmpFile)
+	}
+
+	// Hold the tag index from the version tag check until the spore is
+	// tagged, so concurrent Puts of one version cannot both be stored
+	unlock, err := r.lockTags()
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	defer unlock()
 
-	destFile, err := os.Create(dst)
+	tags, err := r.loadTags()
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
+	ref := versionRef(manifest)
+	if existing, ok := tags[ref]; ok && existing != digest {
+		return &TagConflictError{Ref: ref, Existing: existing, Digest: digest}
+	}
+
+	if err := r.putStaged(fileStore, digest, tmpFile); err != nil {
+		return err
+	}
+	tags[ref] = digest
+	return r.saveTags(tags)
+}
+
+// putStaged hands a staged spore to the store, which a FileStore adopts
+func (r *Repo) putStaged(fileStore *FileStore, digest string, tmpFile *os.File) error {
+	if fileStore != nil {
+		return fileStore.adopt(digest, tmpFile)
+	}
+	return r.Store.Put(digest, tmpFile)
+}
+
+// syncDir fsyncs a directory so renames within it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open repo directory: %w", err)
+	}
+	defer d.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync repo directory: %w", err)
+	}
+	return nil
 }
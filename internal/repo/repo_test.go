@@ -1,9 +1,18 @@
 package repo
 
 import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
 )
 
 func TestPutAndPath(t *testing.T) {
@@ -16,15 +25,11 @@ func TestPutAndPath(t *testing.T) {
 		t.Fatalf("Failed to open repository: %v", err)
 	}
 
-	// Create a test file
-	testFile := filepath.Join(tempDir, "test-file.txt")
-	content := "test content"
-	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
-	}
+	// Create a test spore
+	testFile := packSpore(t, "v1.0.0", "test content")
 
 	// Put file in repository
-	digest, storedPath, err := repo.Put(testFile)
+	digest, err := repo.Put(testFile)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
@@ -33,23 +38,17 @@ func TestPutAndPath(t *testing.T) {
 		t.Error("Digest should not be empty")
 	}
 
-	if storedPath == "" {
-		t.Error("Stored path should not be empty")
-	}
-
 	// Check if file was stored
+	storedPath := repo.Store.(*FileStore).Path(digest)
+	if storedPath != filepath.Join(tempDir, digest+".spore") {
+		t.Errorf("Path returned %s, expected a spore in %s", storedPath, tempDir)
+	}
 	if _, err := os.Stat(storedPath); os.IsNotExist(err) {
 		t.Error("Stored file does not exist")
 	}
 
-	// Test Path method
-	retrievedPath := repo.Path(digest)
-	if retrievedPath != storedPath {
-		t.Errorf("Path returned %s, expected %s", retrievedPath, storedPath)
-	}
-
 	// Test idempotent Put (same content should produce same digest)
-	digest2, storedPath2, err := repo.Put(testFile)
+	digest2, err := repo.Put(testFile)
 	if err != nil {
 		t.Fatalf("Second Put failed: %v", err)
 	}
@@ -57,10 +56,6 @@ func TestPutAndPath(t *testing.T) {
 	if digest != digest2 {
 		t.Error("Same content should produce same digest")
 	}
-
-	if storedPath != storedPath2 {
-		t.Error("Same content should produce same stored path")
-	}
 }
 
 func TestPutWithDifferentContent(t *testing.T) {
@@ -73,27 +68,17 @@ func TestPutWithDifferentContent(t *testing.T) {
 		t.Fatalf("Failed to open repository: %v", err)
 	}
 
-	// Create first test file
-	testFile1 := filepath.Join(tempDir, "test-file1.txt")
-	content1 := "test content 1"
-	if err := os.WriteFile(testFile1, []byte(content1), 0644); err != nil {
-		t.Fatalf("Failed to create test file 1: %v", err)
-	}
-
-	// Create second test file with different content
-	testFile2 := filepath.Join(tempDir, "test-file2.txt")
-	content2 := "test content 2"
-	if err := os.WriteFile(testFile2, []byte(content2), 0644); err != nil {
-		t.Fatalf("Failed to create test file 2: %v", err)
-	}
+	// Create two spores with different content
+	testFile1 := packSpore(t, "v1.0.0", "test content 1")
+	testFile2 := packSpore(t, "v1.0.1", "test content 2")
 
 	// Put both files
-	digest1, _, err := repo.Put(testFile1)
+	digest1, err := repo.Put(testFile1)
 	if err != nil {
 		t.Fatalf("Put file 1 failed: %v", err)
 	}
 
-	digest2, _, err := repo.Put(testFile2)
+	digest2, err := repo.Put(testFile2)
 	if err != nil {
 		t.Fatalf("Put file 2 failed: %v", err)
 	}
@@ -103,3 +88,244 @@ func TestPutWithDifferentContent(t *testing.T) {
 		t.Error("Different content should produce different digests")
 	}
 }
+
+func TestPutRejectsInvalidSpore(t *testing.T) {
+	tempDir := t.TempDir()
+	repo, err := Open(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	// A plain file is not a spore
+	testFile := filepath.Join(t.TempDir(), "test-file.txt")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := repo.Put(testFile); err == nil {
+		t.Fatal("Put should reject a file that is not a spore")
+	}
+
+	// A truncated spore, as a crashed copy would leave, is rejected
+	sporePath := packSpore(t, "v1.0.0", "test content")
+	data, err := os.ReadFile(sporePath)
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	if err := os.WriteFile(sporePath, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Failed to write spore: %v", err)
+	}
+	if _, err := repo.Put(sporePath); err == nil {
+		t.Fatal("Put should reject a truncated spore")
+	}
+
+	// Nothing, not even a temp file, is left behind
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read repository: %v", err)
+	}
+	for _, e := range entries {
+		t.Errorf("Unexpected file left in repository: %s", e.Name())
+	}
+}
+
+func TestPutSkipsExistingDigest(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	sporePath := packSpore(t, "v1.0.0", "test content")
+	digest, err := repo.Put(sporePath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	storedPath := repo.objectPath(digest)
+	before, err := os.Stat(storedPath)
+	if err != nil {
+		t.Fatalf("Failed to stat stored spore: %v", err)
+	}
+
+	if _, err := repo.Put(sporePath); err != nil {
+		t.Fatalf("Second Put failed: %v", err)
+	}
+	after, err := os.Stat(storedPath)
+	if err != nil {
+		t.Fatalf("Failed to stat stored spore: %v", err)
+	}
+
+	// The stored file was not replaced
+	if !os.SameFile(before, after) {
+		t.Error("Put of an existing digest should not rewrite the stored spore")
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	tempDir := t.TempDir()
+	repo, err := Open(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	sporePath := packSpore(t, "v1.0.0", "test content")
+
+	const publishers = 16
+	digests := make([]string, publishers)
+	errs := make([]error, publishers)
+	var wg sync.WaitGroup
+	for i := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			digests[i], errs[i] = repo.Put(sporePath)
+		}()
+	}
+	wg.Wait()
+
+	for i := range publishers {
+		if errs[i] != nil {
+			t.Fatalf("Put %d failed: %v", i, errs[i])
+		}
+		if digests[i] != digests[0] {
+			t.Errorf("Put %d returned digest %s, expected %s", i, digests[i], digests[0])
+		}
+	}
+
+	// Exactly one complete, valid spore is stored
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read repository: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{digests[0] + ".spore", tagsFile, tagsFile + lockSuffix}; !slices.Equal(names, want) {
+		t.Fatalf("Expected only %v in repository, got %v", want, names)
+	}
+	if _, err := spore.Verify(repo.objectPath(digests[0]), nil); err != nil {
+		t.Errorf("Stored spore does not verify: %v", err)
+	}
+}
+
+func TestRevocations(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	// No list published yet
+	rl, err := repo.Revocations()
+	if err != nil {
+		t.Fatalf("Revocations failed: %v", err)
+	}
+	if len(rl.Digests) != 0 || rl.Signer != nil {
+		t.Errorf("Expected an empty list, got %+v", rl)
+	}
+
+	// Unsigned revocations are refused
+	rl.RevokeDigest("abc123", "bad build")
+	if err := repo.PutRevocations(rl); err == nil {
+		t.Error("PutRevocations should reject an unsigned list")
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := rl.Sign(priv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := repo.PutRevocations(rl); err != nil {
+		t.Fatalf("PutRevocations failed: %v", err)
+	}
+
+	got, err := repo.Revocations()
+	if err != nil {
+		t.Fatalf("Revocations failed: %v", err)
+	}
+	if err := got.Check("abc123", &spore.Manifest{Name: "test-app"}); err == nil {
+		t.Error("Published revocation should apply")
+	}
+
+	// A list not issued after the current one is refused
+	if err := repo.PutRevocations(rl); !errors.Is(err, ErrStaleRevocations) {
+		t.Errorf("Expected ErrStaleRevocations republishing a list, got %v", err)
+	}
+}
+
+func TestUpdateRevocationsConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// Two Repos on one directory stand in for concurrent mesh revoke runs:
+	// neither may drop the other's revocations
+	const n = 10
+	var wg sync.WaitGroup
+	for i := range 2 {
+		repo, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Failed to open repository: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range n {
+				err := repo.UpdateRevocations(func(rl *spore.RevocationList) error {
+					rl.RevokeDigest(fmt.Sprintf("digest-%d-%d", i, j), "test")
+					return rl.Sign(priv)
+				})
+				if err != nil {
+					t.Errorf("UpdateRevocations failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	rl, err := repo.Revocations()
+	if err != nil {
+		t.Fatalf("Revocations failed: %v", err)
+	}
+	if len(rl.Digests) != 2*n {
+		t.Errorf("Expected %d revoked digests, got %d", 2*n, len(rl.Digests))
+	}
+	if err := rl.Verify(nil); err != nil {
+		t.Errorf("Published list does not verify: %v", err)
+	}
+}
+
+// packSpore builds a signed spore for the test app in a temporary directory
+func packSpore(t *testing.T, version, content string) string {
+	t.Helper()
+	return packSporeAt(t, version, content, time.Time{})
+}
+
+// packSporeAt is packSpore with a fixed creation time; zero means now
+func packSporeAt(t *testing.T, version, content string, createdAt time.Time) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := spore.Manifest{
+		Name:      "test-app",
+		Version:   version,
+		Command:   "test-binary",
+		Nutrients: spore.Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	payload := spore.Payload{Binary: strings.NewReader(content), CreatedAt: createdAt}
+	sporePath, _, err := spore.PackPayload(payload, manifest, priv, t.TempDir())
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+	return sporePath
+}
@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// errDeleteDisabled is returned for deletes on a Server without AllowDelete
+var errDeleteDisabled = fmt.Errorf("%w: spore deletes are disabled on this server", ErrReadOnly)
+
+// errorResponse is the body of a failed request
+type errorResponse struct {
+	Error      string      `json:"error"`
+	Reason     string      `json:"reason,omitempty"`     // spore.Reason code for rejected spores
+	Violations []Violation `json:"violations,omitempty"` // for spores refused by the publish policy
+}
+
+// DefaultMaxUpload is the largest spore a Server accepts unless
+// MaxUpload says otherwise
+const DefaultMaxUpload = 1 << 30
+
+// maxTagBody bounds the body of a tag request, which is a single digest
+const maxTagBody = 1024
+
+// Server serves a repository over HTTP with content-addressed URLs:
+//
+//	GET    /spores/                   list stored spores
+//	GET    /spores/<digest>           download a spore; supports HEAD and Range
+//	PUT    /spores/<digest>           upload a spore, which must hash to digest, verify and meet the policy
+//	DELETE /spores/<digest>           remove a spore and its tags, unless it is leased; only with AllowDelete
+//	GET    /chunks/<digest>           a spore's chunk index, for peer-to-peer distribution
+//	GET    /tags                      the tag index
+//	PUT    /tags/<name:tag>           point a tag at the digest in the body; ?force=1 moves a version tag
+//	GET    /revocations.json          the revocation list
+//	POST   /leases/<digest>/<holder>  take or renew a lease
+//	DELETE /leases/<digest>/<holder>  release a lease
+//
+// With a Token set, uploads, deletes and tag changes must carry it as a
+// bearer token and are refused with 401 otherwise. Reads and leases need no
+// token: leases expire, and agents take them while they sprout. A ReadOnly
+// server, such as one serving a mirror, refuses every write with 405 but
+// still takes leases, which keep the mirror's own gc from pruning what
+// agents run.
+type Server struct {
+	Repo        *Repo
+	ReadOnly    bool
+	Token       string // required for writes when set
+	AllowDelete bool   // serve DELETE /spores/<digest>
+	MaxUpload   int64  // largest spore accepted, in bytes
+	mux         *http.ServeMux
+}
+
+// NewServer creates an HTTP server for a repository
+func NewServer(r *Repo) *Server {
+	s := &Server{Repo: r, MaxUpload: DefaultMaxUpload, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /spores/{$}", s.handleList)
+	s.mux.HandleFunc("GET /spores/{digest}", s.handleGet)
+	s.mux.HandleFunc("PUT /spores/{digest}", s.writable(s.handlePut))
+	s.mux.HandleFunc("DELETE /spores/{digest}", s.writable(s.handleDelete))
+	s.mux.HandleFunc("GET /chunks/{digest}", s.handleChunks)
+	s.mux.HandleFunc("GET /tags", s.handleTags)
+	s.mux.HandleFunc("PUT /tags/{ref}", s.writable(s.handleTag))
+	s.mux.HandleFunc("GET /revocations.json", s.handleRevocations)
+	s.mux.HandleFunc("POST /leases/{digest}/{holder}", s.handleLease)
+	s.mux.HandleFunc("DELETE /leases/{digest}/{holder}", s.handleRelease)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+// writable wraps a handler that changes the repo, refusing it on a
+// read-only server or without the server's token
+func (s *Server) writable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if s.ReadOnly {
+			writeError(w, ErrReadOnly)
+			return
+		}
+		if s.Token != "" {
+			token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, ErrUnauthorized)
+				return
+			}
+		}
+		h(w, req)
+	}
+}
+
+func (s *Server) handleList(w http.ResponseWriter, req *http.Request) {
+	objects, err := s.Repo.List()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, objects)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, req *http.Request) {
+	digest := req.PathValue("digest")
+	obj, err := s.Repo.Store.Stat(digest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	blob, err := s.Repo.Open(digest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer blob.Close()
+
+	// Content never changes under a digest, so the digest is a strong ETag
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("ETag", `"`+digest+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, req, "", obj.StoredAt, io.NewSectionReader(blob, 0, blob.Size()))
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, req *http.Request) {
+	digest := req.PathValue("digest")
+	if err := s.Repo.PutReader(http.MaxBytesReader(w, req.Body, s.MaxUpload), digest); err != nil {
+		writeError(w, err)
+		return
+	}
+	obj, err := s.Repo.Store.Stat(digest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Printf("Stored spore %s", digest)
+	writeJSON(w, http.StatusCreated, obj)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, req *http.Request) {
+	if !s.AllowDelete {
+		writeError(w, errDeleteDisabled)
+		return
+	}
+	digest := req.PathValue("digest")
+	if err := s.Repo.Delete(digest); err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Printf("Deleted spore %s", digest)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleChunks(w http.ResponseWriter, req *http.Request) {
+	index, err := s.Repo.ChunkIndex(req.PathValue("digest"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, index)
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, req *http.Request) {
+	tags, err := s.Repo.Tags()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func (s *Server) handleTag(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxTagBody))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	tag := s.Repo.Tag
+	if req.URL.Query().Get("force") == "1" {
+		tag = s.Repo.ForceTag
+	}
+	if err := tag(req.PathValue("ref"), strings.TrimSpace(string(body))); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRevocations(w http.ResponseWriter, req *http.Request) {
+	rl, err := s.Repo.Revocations()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rl)
+}
+
+func (s *Server) handleLease(w http.ResponseWriter, req *http.Request) {
+	if err := s.Repo.Lease(req.PathValue("digest"), req.PathValue("holder")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, req *http.Request) {
+	if err := s.Repo.Release(req.PathValue("digest"), req.PathValue("holder")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps a repo error to an HTTP status and JSON error body
+func writeError(w http.ResponseWriter, err error) {
+	resp := errorResponse{Error: err.Error()}
+	status := http.StatusInternalServerError
+
+	var (
+		conflict  *TagConflictError
+		refErr    *RefError
+		policyErr *PublishPolicyError
+		tooLarge  *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidSpore):
+		status = http.StatusUnprocessableEntity
+		resp.Reason = spore.Reason(err)
+	case errors.As(err, &policyErr):
+		status = http.StatusUnprocessableEntity
+		resp.Reason = spore.ReasonPolicy
+		resp.Violations = policyErr.Violations
+	case errors.Is(err, ErrDigestMismatch):
+		status = http.StatusBadRequest
+		resp.Reason = spore.ReasonHashMismatch
+	case errors.As(err, &conflict), errors.Is(err, ErrLeased):
+		status = http.StatusConflict
+	case errors.Is(err, ErrReadOnly):
+		status = http.StatusMethodNotAllowed
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+		resp.Reason = spore.ReasonNotFound
+	case errors.As(err, &refErr):
+		status = http.StatusBadRequest
+	}
+
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,257 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// newTestServer serves a fresh repository on a loopback listener, with
+// deletes allowed
+func newTestServer(t *testing.T) (*Repo, *httptest.Server) {
+	t.Helper()
+
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	s := NewServer(repo)
+	s.AllowDelete = true
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return repo, srv
+}
+
+func TestServerGet(t *testing.T) {
+	repo, srv := newTestServer(t)
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	want, err := os.ReadFile(repo.objectPath(digest))
+	if err != nil {
+		t.Fatalf("Failed to read stored spore: %v", err)
+	}
+
+	// HEAD reports the size without a body
+	resp, err := http.Head(srv.URL + "/spores/" + digest)
+	if err != nil {
+		t.Fatalf("HEAD failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != int64(len(want)) {
+		t.Errorf("HEAD returned %d with length %d, expected 200 with %d", resp.StatusCode, resp.ContentLength, len(want))
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+digest+`"` {
+		t.Errorf("Unexpected ETag %s", etag)
+	}
+
+	// Range requests return a slice of the spore
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/spores/"+digest, nil)
+	req.Header.Set("Range", "bytes=10-19")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, want[10:20]) {
+		t.Errorf("Range GET returned %d %q, expected 206 %q", resp.StatusCode, body, want[10:20])
+	}
+
+	// Listing
+	resp, err = http.Get(srv.URL + "/spores/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var objects []Object
+	if err := json.NewDecoder(resp.Body).Decode(&objects); err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	resp.Body.Close()
+	if len(objects) != 1 || objects[0].Digest != digest || objects[0].Size != int64(len(want)) {
+		t.Errorf("Unexpected list %+v", objects)
+	}
+
+	// Unknown and malformed digests
+	for path, status := range map[string]int{
+		"/spores/" + strings.Repeat("0", 64): http.StatusNotFound,
+		"/spores/not-a-digest":               http.StatusBadRequest,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("GET %s returned %d, expected %d", path, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestServerPutRejections(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	data, err := os.ReadFile(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	wrongDigest := "0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		name   string
+		digest string
+		body   []byte
+		status int
+		reason string
+	}{
+		{"digest mismatch", wrongDigest, data, http.StatusBadRequest, spore.ReasonHashMismatch},
+		{"not a spore", "", []byte("test content"), http.StatusUnprocessableEntity, spore.ReasonMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := tt.digest
+			if digest == "" {
+				digest = fmt.Sprintf("%x", sha256.Sum256(tt.body))
+			}
+			req, _ := http.NewRequest(http.MethodPut, srv.URL+"/spores/"+digest, bytes.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("PUT failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var errResp errorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			if resp.StatusCode != tt.status || errResp.Reason != tt.reason {
+				t.Errorf("PUT returned %d %+v, expected %d with reason %s", resp.StatusCode, errResp, tt.status, tt.reason)
+			}
+		})
+	}
+}
+
+func TestServerReadOnly(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	s := NewServer(repo)
+	s.ReadOnly = true
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	store := &HTTPStore{URL: srv.URL, Client: srv.Client()}
+	remote, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Writes are refused
+	if _, err := remote.Put(packSpore(t, "v2.0.0", "other content")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly uploading, got %v", err)
+	}
+	if err := store.Delete(digest); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly deleting, got %v", err)
+	}
+	if err := remote.Tag("test-app:latest", digest); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly tagging, got %v", err)
+	}
+
+	// Reads and leases are not
+	if _, err := remote.Fetch(digest); err != nil {
+		t.Errorf("Fetch failed: %v", err)
+	}
+	if err := remote.Lease(digest, "node-1"); err != nil {
+		t.Errorf("Lease failed: %v", err)
+	}
+}
+
+func TestServerWriteAccess(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	s := NewServer(repo)
+	s.Token = "secret"
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Writes without the token are refused; reads and leases are not
+	anon, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+	if _, err := anon.Put(packSpore(t, "v2.0.0", "other content")); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized uploading, got %v", err)
+	}
+	if err := anon.Tag("test-app:latest", digest); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized tagging, got %v", err)
+	}
+	if _, err := anon.Fetch(digest); err != nil {
+		t.Errorf("Fetch failed: %v", err)
+	}
+	if err := anon.Lease(digest, "node-1"); err != nil {
+		t.Errorf("Lease failed: %v", err)
+	}
+
+	authed, err := NewRemote(srv.URL, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+	authed.Client = TokenClient("secret")
+	if _, err := authed.Put(packSpore(t, "v2.0.0", "other content")); err != nil {
+		t.Errorf("Put with the token failed: %v", err)
+	}
+	if err := authed.Tag("test-app:latest", digest); err != nil {
+		t.Errorf("Tag with the token failed: %v", err)
+	}
+
+	// Version tags move only when forced
+	other, err := repo.Resolve("test-app:v2.0.0")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	var remoteErr *RemoteError
+	if err := authed.Tag("test-app:v1.0.0", other); !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 moving a version tag, got %v", err)
+	}
+	if err := authed.ForceTag("test-app:v1.0.0", other); err != nil {
+		t.Errorf("ForceTag failed: %v", err)
+	}
+
+	// Deletes are off unless the server allows them
+	store := &HTTPStore{URL: srv.URL, Client: TokenClient("secret")}
+	if err := store.Delete(digest); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected deletes to be refused, got %v", err)
+	}
+	if _, err := repo.Store.Stat(digest); err != nil {
+		t.Errorf("Spore was deleted: %v", err)
+	}
+
+	// Uploads are bounded
+	s.MaxUpload = 16
+	_, err = authed.Put(packSpore(t, "v3.0.0", "large content"))
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized upload, got %v", err)
+	}
+}
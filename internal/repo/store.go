@@ -0,0 +1,288 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store holds spore objects by digest. A Repo keeps its spores in a Store and
+// its tags, leases and revocations beside it. FileStore, MemoryStore and
+// HTTPStore implement it; other object storage can sit behind the same
+// interface.
+//
+// A missing object is reported with an error matching fs.ErrNotExist.
+type Store interface {
+	// Put stores an object read from r, which must hash to digest or fail
+	// with ErrDigestMismatch. The object appears whole or not at all.
+	Put(digest string, r io.Reader) error
+	// Open returns a reader for a stored object
+	Open(digest string) (Blob, error)
+	Stat(digest string) (Object, error)
+	// List returns the stored objects, sorted by digest
+	List() ([]Object, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(digest string) error
+}
+
+// Blob is an open object. Spores are zip archives, so they are read at
+// random as well as in sequence.
+type Blob interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// OpenFile opens a spore file as a Blob
+func OpenFile(path string) (Blob, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileBlob{File: file, size: info.Size()}, nil
+}
+
+type fileBlob struct {
+	*os.File
+	size int64
+}
+
+func (b *fileBlob) Size() int64 { return b.size }
+
+// notStored returns the error for a digest a store does not hold
+func notStored(digest string) error {
+	return fmt.Errorf("spore %s not found: %w", digest, fs.ErrNotExist)
+}
+
+// checkDigest returns an error unless digest hashes the data read by hasher
+func checkDigest(hasher interface{ Sum([]byte) []byte }, digest string) error {
+	if got := fmt.Sprintf("%x", hasher.Sum(nil)); got != digest {
+		return fmt.Errorf("%w: spore hashes to %s, expected %s", ErrDigestMismatch, got, digest)
+	}
+	return nil
+}
+
+// FileStore keeps each object in a directory as <digest>.spore
+type FileStore struct {
+	Dir string
+}
+
+// Path returns the file path for a given digest
+func (s *FileStore) Path(digest string) string {
+	return filepath.Join(s.Dir, digest+".spore")
+}
+
+// Put copies r into a temporary file, checks that it hashes to digest, then
+// syncs and renames it into place
+func (s *FileStore) Put(digest string, r io.Reader) (err error) {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(s.Dir, ".put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file in repo: %w", err)
+	}
+	defer func() {
+		tmpFile.Close()
+		if err != nil {
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, hasher), r); err != nil {
+		return fmt.Errorf("failed to copy file to repo: %w", err)
+	}
+	if err := checkDigest(hasher, digest); err != nil {
+		return err
+	}
+	return s.adopt(digest, tmpFile)
+}
+
+// adopt syncs a complete temporary file in Dir and renames it into place
+// as digest, without copying it
+func (s *FileStore) adopt(digest string, tmpFile *os.File) error {
+	if err := tmpFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spore: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close spore: %w", err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set spore permissions: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), s.Path(digest)); err != nil {
+		return fmt.Errorf("failed to move spore into place: %w", err)
+	}
+
+	// Persist the rename
+	return syncDir(s.Dir)
+}
+
+// Open opens a stored object
+func (s *FileStore) Open(digest string) (Blob, error) {
+	if err := CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	blob, err := OpenFile(s.Path(digest))
+	if err != nil {
+		return nil, fmt.Errorf("spore %s not found: %w", digest, err)
+	}
+	return blob, nil
+}
+
+// Stat returns the size and modification time of a stored object
+func (s *FileStore) Stat(digest string) (Object, error) {
+	if err := CheckDigest(digest); err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(s.Path(digest))
+	if err != nil {
+		return Object{}, fmt.Errorf("spore %s not found: %w", digest, err)
+	}
+	return Object{Digest: digest, Size: info.Size(), StoredAt: info.ModTime()}, nil
+}
+
+// List returns the stored objects, sorted by digest
+func (s *FileStore) List() ([]Object, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repo directory: %w", err)
+	}
+
+	var objects []Object
+	for _, e := range entries {
+		digest, ok := strings.CutSuffix(e.Name(), ".spore")
+		if !ok || !e.Type().IsRegular() || !IsDigest(digest) {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue // removed since listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat spore %s: %w", digest, err)
+		}
+		objects = append(objects, Object{Digest: digest, Size: info.Size(), StoredAt: info.ModTime()})
+	}
+	return objects, nil
+}
+
+// Delete removes a stored object
+func (s *FileStore) Delete(digest string) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	if err := os.Remove(s.Path(digest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove spore %s: %w", digest, err)
+	}
+	return nil
+}
+
+// MemoryStore keeps objects in memory, for tests and as a stand-in for
+// remote object storage
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data     []byte
+	storedAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string]memoryObject)}
+}
+
+// Put reads r into memory and stores it if it hashes to digest
+func (s *MemoryStore) Put(digest string, r io.Reader) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(r, hasher))
+	if err != nil {
+		return fmt.Errorf("failed to read spore: %w", err)
+	}
+	if err := checkDigest(hasher, digest); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[digest]; !ok {
+		s.objects[digest] = memoryObject{data: data, storedAt: time.Now()}
+	}
+	return nil
+}
+
+// Open returns a reader over a stored object. Stored data is never modified,
+// so the reader needs no copy.
+func (s *MemoryStore) Open(digest string) (Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[digest]
+	if !ok {
+		return nil, notStored(digest)
+	}
+	return memoryBlob{bytes.NewReader(obj.data)}, nil
+}
+
+type memoryBlob struct {
+	*bytes.Reader
+}
+
+func (memoryBlob) Close() error { return nil }
+
+// Stat returns the size and storage time of a stored object
+func (s *MemoryStore) Stat(digest string) (Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[digest]
+	if !ok {
+		return Object{}, notStored(digest)
+	}
+	return Object{Digest: digest, Size: int64(len(obj.data)), StoredAt: obj.storedAt}, nil
+}
+
+// List returns the stored objects, sorted by digest
+func (s *MemoryStore) List() ([]Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects := make([]Object, 0, len(s.objects))
+	for digest, obj := range s.objects {
+		objects = append(objects, Object{Digest: digest, Size: int64(len(obj.data)), StoredAt: obj.storedAt})
+	}
+	slices.SortFunc(objects, func(a, b Object) int { return strings.Compare(a.Digest, b.Digest) })
+	return objects, nil
+}
+
+// Delete removes a stored object
+func (s *MemoryStore) Delete(digest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, digest)
+	return nil
+}
@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"file":   func(t *testing.T) Store { return &FileStore{Dir: t.TempDir()} },
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"http": func(t *testing.T) Store {
+			_, srv := newTestServer(t)
+			return &HTTPStore{URL: srv.URL, Client: srv.Client()}
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, newStore(t))
+		})
+	}
+}
+
+// testStore checks the behavior every Store must share
+func testStore(t *testing.T, store Store) {
+	data, err := os.ReadFile(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	if _, err := store.Stat(digest); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not found before Put, got %v", err)
+	}
+	if _, err := store.Open(digest); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not found before Put, got %v", err)
+	}
+
+	// Content that does not hash to the digest is refused
+	wrong := fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+	if err := store.Put(wrong, bytes.NewReader(data)); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Expected ErrDigestMismatch, got %v", err)
+	}
+	if _, err := store.Stat(wrong); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Mismatched object should not be stored, got %v", err)
+	}
+
+	for range 2 {
+		if err := store.Put(digest, bytes.NewReader(data)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	obj, err := store.Stat(digest)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if obj.Digest != digest || obj.Size != int64(len(data)) {
+		t.Errorf("Unexpected Stat %+v", obj)
+	}
+
+	objects, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Digest != digest || objects[0].Size != obj.Size {
+		t.Errorf("Unexpected List %+v", objects)
+	}
+
+	blob, err := store.Open(digest)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if blob.Size() != int64(len(data)) {
+		t.Errorf("Blob size %d, expected %d", blob.Size(), len(data))
+	}
+	part := make([]byte, 16)
+	if _, err := blob.ReadAt(part, 100); err != nil || !bytes.Equal(part, data[100:116]) {
+		t.Errorf("ReadAt returned %q, %v", part, err)
+	}
+	if got, err := io.ReadAll(blob); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Read returned %d bytes, %v", len(got), err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	for range 2 {
+		if err := store.Delete(digest); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if _, err := store.Stat(digest); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not found after Delete, got %v", err)
+	}
+}
+
+func TestHTTPStoreDeleteLeased(t *testing.T) {
+	repo, srv := newTestServer(t)
+	store := &HTTPStore{URL: srv.URL, Client: srv.Client()}
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := repo.Lease(digest, "node-1"); err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+
+	var remoteErr *RemoteError
+	if err := store.Delete(digest); !errors.As(err, &remoteErr) || remoteErr.StatusCode != 409 {
+		t.Errorf("Expected 409 deleting a leased spore, got %v", err)
+	}
+
+	if err := repo.Release(digest, "node-1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := store.Delete(digest); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if tags, _ := repo.Tags(); len(tags) != 0 {
+		t.Errorf("Tags of a deleted spore should be dropped, got %v", tags)
+	}
+}
+
+func TestRepoOnMemoryStore(t *testing.T) {
+	dir := t.TempDir()
+	repo := New(dir, NewMemoryStore())
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got, err := repo.Resolve("test-app:v1.0.0"); err != nil || got != digest {
+		t.Errorf("Resolve returned %s, %v", got, err)
+	}
+	if err := repo.Tag("test-app:latest", digest); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	report, err := repo.Fsck(nil, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if report.Checked != 1 || len(report.Corrupt) != 0 || len(report.Orphans) != 0 {
+		t.Errorf("Unexpected fsck report %+v", report)
+	}
+
+	// Only metadata lives in the directory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read repo directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name() != tagsFile && e.Name() != tagsFile+lockSuffix {
+			t.Errorf("Unexpected file in repo directory: %s", e.Name())
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// tagsFile holds the tag index, mapping name:tag references to digests
+const tagsFile = "tags.json"
+
+var (
+	tagNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	tagPattern     = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.+-]{0,127}$`)
+)
+
+// TagConflictError is returned when publishing a spore whose name:version tag
+// already names a different digest, or when Tag would move such a tag.
+// Version tags are immutable unless moved with ForceTag; other tags, such as
+// name:latest, move.
+type TagConflictError struct {
+	Ref      string
+	Existing string
+	Digest   string
+}
+
+func (e *TagConflictError) Error() string {
+	return fmt.Sprintf("tag %s already names %s, refusing to point it at %s", e.Ref, e.Existing, e.Digest)
+}
+
+// RefError is returned for a malformed digest, tag or lease
+type RefError struct {
+	Kind  string
+	Value string
+	Want  string // optional hint at the expected form
+}
+
+func (e *RefError) Error() string {
+	msg := fmt.Sprintf("invalid %s %q", e.Kind, e.Value)
+	if e.Want != "" {
+		msg += ": want " + e.Want
+	}
+	return msg
+}
+
+// ParseRef splits a name:tag reference
+func ParseRef(ref string) (name, tag string, err error) {
+	name, tag, ok := strings.Cut(ref, ":")
+	if !ok || !tagNamePattern.MatchString(name) || !tagPattern.MatchString(tag) {
+		return "", "", &RefError{Kind: "tag", Value: ref, Want: "name:tag, e.g. billing:v0.1.0"}
+	}
+	return name, tag, nil
+}
+
+// Tags returns the tag index, mapping name:tag to digest
+func (r *Repo) Tags() (map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.loadTags()
+}
+
+// Tag points ref, a name:tag reference, at a stored spore. The spore's
+// manifest must carry the same name. An existing tag is moved, unless it is
+// the name:version tag of the spore it names.
+func (r *Repo) Tag(ref, digest string) error {
+	return r.tag(ref, digest, false)
+}
+
+// ForceTag points ref at a stored spore like Tag, but moves version tags too
+func (r *Repo) ForceTag(ref, digest string) error {
+	return r.tag(ref, digest, true)
+}
+
+func (r *Repo) tag(ref, digest string, force bool) error {
+	name, _, err := ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+
+	insp, err := r.inspect(digest)
+	if err != nil {
+		return fmt.Errorf("spore %s: %w", digest, err)
+	}
+	if insp.Manifest.Name != name {
+		return &RefError{Kind: "tag", Value: ref, Want: "a tag for app " + insp.Manifest.Name}
+	}
+
+	unlock, err := r.lockTags()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tags, err := r.loadTags()
+	if err != nil {
+		return err
+	}
+	if existing, ok := tags[ref]; ok && existing != digest && !force {
+		// A spore that is gone no longer pins its version tag
+		if prev, err := r.inspect(existing); err == nil && versionRef(prev.Manifest) == ref {
+			return &TagConflictError{Ref: ref, Existing: existing, Digest: digest}
+		}
+	}
+	tags[ref] = digest
+	return r.saveTags(tags)
+}
+
+// Untag removes a tag. Removing a missing tag is not an error.
+func (r *Repo) Untag(ref string) error {
+	unlock, err := r.lockTags()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tags, err := r.loadTags()
+	if err != nil {
+		return err
+	}
+	if _, ok := tags[ref]; !ok {
+		return nil
+	}
+	delete(tags, ref)
+	return r.saveTags(tags)
+}
+
+// dropTags removes every tag naming one of digests
+func (r *Repo) dropTags(digests map[string]bool) error {
+	unlock, err := r.lockTags()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tags, err := r.loadTags()
+	if err != nil {
+		return err
+	}
+	n := len(tags)
+	maps.DeleteFunc(tags, func(ref, digest string) bool { return digests[digest] })
+	if len(tags) == n {
+		return nil
+	}
+	return r.saveTags(tags)
+}
+
+// Resolve returns the digest a reference names: a digest, optionally
+// prefixed with sha256:, a unique prefix of a stored spore's digest, or a
+// name:tag reference
+func (r *Repo) Resolve(ref string) (string, error) {
+	return resolveRef(ref, r.Tags, r.Store.List)
+}
+
+// resolveRef resolves ref against the tag index returned by tags, which is
+// only read for name:tag references, and the objects returned by list,
+// which are only read for digest prefixes
+func resolveRef(ref string, tags func() (map[string]string, error), list func() ([]Object, error)) (string, error) {
+	if hex, ok := strings.CutPrefix(ref, DigestPrefix); ok {
+		if !digestPrefixPattern.MatchString(hex) {
+			return "", &RefError{Kind: "digest", Value: ref, Want: fmt.Sprintf("at least %d lowercase hex characters after %s", MinPrefixLength, DigestPrefix)}
+		}
+		ref = hex
+	}
+	if IsDigest(ref) {
+		return ref, nil
+	}
+	if digestPrefixPattern.MatchString(ref) {
+		return resolvePrefix(ref, list)
+	}
+	if _, _, err := ParseRef(ref); err != nil {
+		return "", &RefError{Kind: "reference", Value: ref, Want: "a digest, digest prefix or name:tag"}
+	}
+
+	index, err := tags()
+	if err != nil {
+		return "", err
+	}
+	digest, ok := index[ref]
+	if !ok {
+		return "", fmt.Errorf("unknown tag %s", ref)
+	}
+	return digest, nil
+}
+
+// versionRef returns the name:version tag for a manifest
+func versionRef(m *spore.Manifest) string {
+	return m.Name + ":" + m.Version
+}
+
+// tagVersion points the manifest's name:version tag at digest, unless it
+// already names another digest
+func (r *Repo) tagVersion(m *spore.Manifest, digest string) error {
+	ref := versionRef(m)
+
+	unlock, err := r.lockTags()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tags, err := r.loadTags()
+	if err != nil {
+		return err
+	}
+	switch existing, ok := tags[ref]; {
+	case ok && existing == digest:
+		return nil
+	case ok:
+		return &TagConflictError{Ref: ref, Existing: existing, Digest: digest}
+	}
+	tags[ref] = digest
+	return r.saveTags(tags)
+}
+
+// loadTags reads the tag index. A missing file yields an empty index.
+func (r *Repo) loadTags() (map[string]string, error) {
+	tags := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(r.Dir, tagsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("failed to parse tags: %w", err)
+	}
+	return tags, nil
+}
+
+// lockTags takes the tag index for a read-modify-write, in this process and
+// against others sharing the repo directory, and returns a function
+// releasing it
+func (r *Repo) lockTags() (unlock func(), err error) {
+	r.mu.Lock()
+	unlockIndex, err := r.lockIndex(tagsFile)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockIndex()
+		r.mu.Unlock()
+	}, nil
+}
+
+// saveTags replaces the tag index atomically. Callers hold the tags lock
+// across loading and saving the index.
+func (r *Repo) saveTags(tags map[string]string) error {
+	data, err := json.MarshalIndent(tags, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tags: %w", err)
+	}
+	return r.writeIndex(tagsFile, data)
+}
@@ -0,0 +1,288 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPutTagsVersion(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"test-app:v1.0.0", digest, false},
+		{digest, digest, false},
+		{"test-app:v2.0.0", "", true},
+		{"test-app", "", true},
+		{"Test-App:v1.0.0", "", true},
+	}
+	for _, tt := range tests {
+		got, err := repo.Resolve(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestResolveDigestPrefix(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{digest[:7], digest, false},
+		{digest[:MinPrefixLength], digest, false},
+		{"sha256:" + digest, digest, false},
+		{"sha256:" + digest[:12], digest, false},
+		{digest[:MinPrefixLength-1], "", true},
+		{"sha256:", "", true},
+		{"sha256:" + strings.ToUpper(digest), "", true},
+		{strings.Repeat("0", 12), "", true},
+		{digest + "0", "", true},
+	}
+	for _, tt := range tests {
+		got, err := repo.Resolve(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.ref, got, tt.want)
+		}
+	}
+
+	// A prefix matching several spores lists them all
+	objects := []Object{
+		{Digest: "abcd" + strings.Repeat("1", 60)},
+		{Digest: "abcd" + strings.Repeat("0", 60)},
+		{Digest: "abce" + strings.Repeat("0", 60)},
+	}
+	list := func() ([]Object, error) { return objects, nil }
+	_, err = resolveRef("abcd", repo.Tags, list)
+	var ambiguous *AmbiguousDigestError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("Expected AmbiguousDigestError, got %v", err)
+	}
+	want := []string{objects[1].Digest, objects[0].Digest}
+	if !slices.Equal(ambiguous.Candidates, want) {
+		t.Errorf("Candidates = %v, want %v", ambiguous.Candidates, want)
+	}
+	if got, err := resolveRef("abce", repo.Tags, list); err != nil || got != objects[2].Digest {
+		t.Errorf("resolveRef returned %s, %v", got, err)
+	}
+}
+
+func TestPutRejectsVersionConflict(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	digest, err := repo.Put(packSpore(t, "v1.0.0", "test content 1"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// A different build of the same version is refused and not stored
+	rejected, err := repo.Put(packSpore(t, "v1.0.0", "test content 2"))
+	var conflict *TagConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected TagConflictError, got %v", err)
+	}
+	if conflict.Existing != digest {
+		t.Errorf("Conflict names %s, expected %s", conflict.Existing, digest)
+	}
+	if rejected != "" {
+		t.Errorf("Rejected Put returned digest %s", rejected)
+	}
+	if _, err := os.Stat(repo.objectPath(conflict.Digest)); !os.IsNotExist(err) {
+		t.Error("Conflicting spore should not be stored")
+	}
+}
+
+func TestConcurrentPutVersionConflict(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	// Different builds of one version published at once: exactly one wins,
+	// and no loser is left in the store untagged
+	const n = 8
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = packSpore(t, "v1.0.0", fmt.Sprintf("test content %d", i))
+	}
+	digests := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			digests[i], errs[i] = repo.Put(path)
+		}()
+	}
+	wg.Wait()
+
+	var winners []string
+	for i, err := range errs {
+		var conflict *TagConflictError
+		switch {
+		case err == nil:
+			winners = append(winners, digests[i])
+		case !errors.As(err, &conflict):
+			t.Errorf("Expected TagConflictError, got %v", err)
+		}
+	}
+	if len(winners) != 1 {
+		t.Fatalf("Expected one Put to win, got %d", len(winners))
+	}
+	if objects, _ := repo.List(); len(objects) != 1 || objects[0].Digest != winners[0] {
+		t.Errorf("Expected only %s stored, got %d objects", winners[0], len(objects))
+	}
+	if tags, _ := repo.Tags(); tags["test-app:v1.0.0"] != winners[0] {
+		t.Errorf("Version tag names %s, expected %s", tags["test-app:v1.0.0"], winners[0])
+	}
+}
+
+func TestTagAndUntag(t *testing.T) {
+	repo, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	digest1, err := repo.Put(packSpore(t, "v1.0.0", "test content 1"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	digest2, err := repo.Put(packSpore(t, "v1.1.0", "test content 2"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Mutable tags move
+	for _, digest := range []string{digest1, digest2} {
+		if err := repo.Tag("test-app:latest", digest); err != nil {
+			t.Fatalf("Tag failed: %v", err)
+		}
+		got, err := repo.Resolve("test-app:latest")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if got != digest {
+			t.Errorf("test-app:latest = %s, want %s", got, digest)
+		}
+	}
+
+	// Version tags do not move unless forced
+	var conflict *TagConflictError
+	if err := repo.Tag("test-app:v1.0.0", digest2); !errors.As(err, &conflict) || conflict.Existing != digest1 {
+		t.Errorf("Expected TagConflictError moving a version tag, got %v", err)
+	}
+	if got, _ := repo.Resolve("test-app:v1.0.0"); got != digest1 {
+		t.Errorf("test-app:v1.0.0 = %s, want %s", got, digest1)
+	}
+	if err := repo.ForceTag("test-app:v1.0.0", digest2); err != nil {
+		t.Fatalf("ForceTag failed: %v", err)
+	}
+	if got, _ := repo.Resolve("test-app:v1.0.0"); got != digest2 {
+		t.Errorf("test-app:v1.0.0 = %s, want %s", got, digest2)
+	}
+	if err := repo.ForceTag("test-app:v1.0.0", digest1); err != nil {
+		t.Fatalf("ForceTag failed: %v", err)
+	}
+
+	// Tags must match the spore's app and name a stored spore
+	if err := repo.Tag("billing:latest", digest1); err == nil {
+		t.Error("Tag should refuse a name that differs from the spore's app")
+	}
+	if err := repo.Tag("test-app:latest", "0000000000000000000000000000000000000000000000000000000000000000"); err == nil {
+		t.Error("Tag should refuse a digest that is not stored")
+	}
+
+	if err := repo.Untag("test-app:latest"); err != nil {
+		t.Fatalf("Untag failed: %v", err)
+	}
+	tags, err := repo.Tags()
+	if err != nil {
+		t.Fatalf("Tags failed: %v", err)
+	}
+	if _, ok := tags["test-app:latest"]; ok {
+		t.Error("Untagged reference still present")
+	}
+	if len(tags) != 2 {
+		t.Errorf("Expected the 2 version tags, got %v", tags)
+	}
+}
+
+func TestTagConcurrentRepos(t *testing.T) {
+	dir := t.TempDir()
+	first, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	digest, err := first.Put(packSpore(t, "v1.0.0", "test content"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Two Repos on one directory stand in for a publish running beside a
+	// repo server: neither may lose the other's tags
+	second, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	const n = 20
+	var wg sync.WaitGroup
+	for i, r := range []*Repo{first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range n {
+				if err := r.Tag(fmt.Sprintf("test-app:t%d-%d", i, j), digest); err != nil {
+					t.Errorf("Tag failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tags, err := first.Tags()
+	if err != nil {
+		t.Fatalf("Tags failed: %v", err)
+	}
+	if len(tags) != 2*n+1 {
+		t.Errorf("Expected %d tags, got %d", 2*n+1, len(tags))
+	}
+}
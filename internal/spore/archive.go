@@ -0,0 +1,202 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// maxManifestSize bounds how much of manifest.json is read into memory
+	maxManifestSize = 1 << 20
+
+	// maxEntrySize bounds any single archive entry once decompressed
+	maxEntrySize = 16 << 30
+
+	// maxSporeSize bounds all archive entries together once decompressed
+	maxSporeSize = 16 << 30
+)
+
+// ArchiveError is returned when a spore archive is malformed or contains
+// entries that could escape, overwrite or exhaust the extract directory
+type ArchiveError struct {
+	Entry  string
+	Reason string
+}
+
+func (e *ArchiveError) Error() string {
+	if e.Entry == "" {
+		return fmt.Sprintf("malformed spore: %s", e.Reason)
+	}
+	return fmt.Sprintf("malformed spore entry %q: %s", e.Entry, e.Reason)
+}
+
+// readArchive parses manifest.json and indexes the archive entries by name.
+// The archive must contain exactly the entries the manifest describes, each
+// a regular file no larger than declared.
+func readArchive(zr *zip.Reader) (*Manifest, map[string]*zip.File, error) {
+	entries := make(map[string]*zip.File)
+	for _, file := range zr.File {
+		if err := validEntryName(file.Name); err != nil {
+			return nil, nil, err
+		}
+		if _, dup := entries[file.Name]; dup {
+			return nil, nil, &ArchiveError{Entry: file.Name, Reason: "duplicate entry"}
+		}
+		if !file.Mode().IsRegular() {
+			return nil, nil, &ArchiveError{Entry: file.Name, Reason: fmt.Sprintf("not a regular file (%s)", file.Mode().Type())}
+		}
+		entries[file.Name] = file
+	}
+
+	manifestFile, ok := entries["manifest.json"]
+	if !ok {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: "not found"}
+	}
+	if manifestFile.UncompressedSize64 > maxManifestSize {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: fmt.Sprintf("exceeds %d bytes", maxManifestSize)}
+	}
+
+	rc, err := manifestFile.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	manifestData, err := io.ReadAll(io.LimitReader(rc, maxManifestSize+1))
+	rc.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if len(manifestData) > maxManifestSize {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: fmt.Sprintf("exceeds %d bytes", maxManifestSize)}
+	}
+
+	// Parse manifest
+	manifest, err := decodeManifest(manifestData)
+	if err != nil {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: err.Error()}
+	}
+	if !knownAPIVersion(manifest.APIVersion) {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: fmt.Sprintf("unsupported apiVersion %q", manifest.APIVersion)}
+	}
+
+	if err := validCommand(manifest.Command); err != nil {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: err.Error()}
+	}
+
+	var paths []string
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+	}
+	if err := checkFilePaths(manifest, paths); err != nil {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: err.Error()}
+	}
+	if err := checkAttestations(manifest.Attestations); err != nil {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: err.Error()}
+	}
+	if err := checkBinaries(manifest.Binaries); err != nil {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: err.Error()}
+	}
+	if len(manifest.Binaries) > 0 && (manifest.BinarySHA256 != "" || manifest.BinarySize != 0) {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: "both a single binary and per-platform binaries declared"}
+	}
+	if len(manifest.Binaries) > 0 && manifest.Encryption != nil {
+		return nil, nil, &ArchiveError{Entry: "manifest.json", Reason: "per-platform binaries cannot be encrypted"}
+	}
+
+	// Every entry must be declared, and no larger than declared
+	limits := map[string]int64{
+		"manifest.json": maxManifestSize,
+	}
+	if len(manifest.Binaries) == 0 {
+		limits["binary"] = manifest.binaryLimit()
+	}
+	for _, b := range manifest.Binaries {
+		limits[binaryEntryName(b.Platform)] = b.Size
+	}
+	for _, f := range manifest.Files {
+		if f.Size < 0 || f.Size > maxEntrySize {
+			return nil, nil, &ArchiveError{Entry: fileEntryName(f.Path), Reason: fmt.Sprintf("invalid declared size %d", f.Size)}
+		}
+		limits[fileEntryName(f.Path)] = f.Size
+	}
+	for _, a := range manifest.Attestations {
+		limits[attestationEntryName(a.Name)] = a.Size
+	}
+
+	// The zip reader fails an entry that decompresses past its recorded
+	// size, so the recorded sizes bound the whole archive
+	var total uint64
+	for name, file := range entries {
+		limit, ok := limits[name]
+		if !ok {
+			return nil, nil, &ArchiveError{Entry: name, Reason: "not declared in manifest"}
+		}
+		if file.UncompressedSize64 > uint64(limit) {
+			return nil, nil, &ArchiveError{Entry: name, Reason: fmt.Sprintf("exceeds declared size %d", limit)}
+		}
+		total += file.UncompressedSize64
+		if total > maxSporeSize {
+			return nil, nil, &ArchiveError{Reason: fmt.Sprintf("entries exceed %d bytes in total", maxSporeSize)}
+		}
+	}
+
+	for name := range limits {
+		if _, ok := entries[name]; !ok {
+			return nil, nil, &ArchiveError{Entry: name, Reason: "not found"}
+		}
+	}
+
+	return manifest, entries, nil
+}
+
+// copyEntry streams a zip entry to w and returns its SHA-256. Reading stops
+// with an error once more than limit bytes have been decompressed.
+func copyEntry(w io.Writer, file *zip.File, limit int64) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer rc.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hasher), io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	if n > limit {
+		return nil, &ArchiveError{Entry: file.Name, Reason: fmt.Sprintf("exceeds declared size %d", limit)}
+	}
+
+	return hasher.Sum(nil), nil
+}
+
+// binaryLimit returns the largest binary entry the manifest allows
+func (m *Manifest) binaryLimit() int64 {
+	if m.BinarySize > 0 && m.BinarySize <= maxEntrySize {
+		return m.BinarySize
+	}
+	return maxEntrySize
+}
+
+// validEntryName rejects absolute, non-canonical or escaping entry names
+func validEntryName(name string) error {
+	if unsafePath(name) {
+		return &ArchiveError{Entry: name, Reason: "unsafe entry name"}
+	}
+	return nil
+}
+
+// validCommand checks that the command is a plain file name, since it is
+// used as the binary's name inside the extract directory
+func validCommand(command string) error {
+	if command == "" {
+		return fmt.Errorf("manifest command is required")
+	}
+	if command == "." || command == ".." || command == "manifest.json" ||
+		strings.ContainsAny(command, "/\\") || strings.ContainsRune(command, 0) || strings.HasPrefix(command, ".") {
+		return fmt.Errorf("invalid manifest command %q", command)
+	}
+	return nil
+}
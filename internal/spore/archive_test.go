@@ -0,0 +1,238 @@
+package spore
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEntry is a raw entry for a hand-built archive
+type testEntry struct {
+	name string
+	data []byte
+	mode fs.FileMode
+}
+
+// buildArchive writes entries to an in-memory zip exactly as given
+func buildArchive(t testing.TB, entries []testEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			header.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", e.name, err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatalf("Failed to write entry %s: %v", e.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// manifestJSON marshals a manifest for a hand-built archive
+func manifestJSON(t testing.TB, m Manifest) []byte {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	return data
+}
+
+func TestExtractRejectsMaliciousArchives(t *testing.T) {
+	base := Manifest{Name: "test-app", Version: "v1.0.0", Command: "test-binary"}
+	withFile := base
+	withFile.Files = []FileEntry{{Path: "static/app.js", Size: 1, Mode: 0644}}
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"path traversal", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, base)},
+			{name: "binary", data: []byte("bin")},
+			{name: "../../evil", data: []byte("x")},
+		}},
+		{"absolute name", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, base)},
+			{name: "binary", data: []byte("bin")},
+			{name: "/etc/cron.d/evil", data: []byte("x")},
+		}},
+		{"symlink", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, base)},
+			{name: "binary", data: []byte("/etc/passwd"), mode: fs.ModeSymlink | 0777},
+		}},
+		{"duplicate entry", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, base)},
+			{name: "binary", data: []byte("bin")},
+			{name: "binary", data: []byte("other")},
+		}},
+		{"unexpected entry", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, base)},
+			{name: "binary", data: []byte("bin")},
+			{name: "files/extra.sh", data: []byte("x")},
+		}},
+		{"oversized file", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, withFile)},
+			{name: "binary", data: []byte("bin")},
+			{name: "files/static/app.js", data: bytes.Repeat([]byte("a"), 1<<16)},
+		}},
+		{"manifest bomb", []testEntry{
+			{name: "manifest.json", data: make([]byte, 2*maxManifestSize)},
+			{name: "binary", data: []byte("bin")},
+		}},
+		{"command traversal", []testEntry{
+			{name: "manifest.json", data: manifestJSON(t, Manifest{Name: "test-app", Command: "../../bin/sh"})},
+			{name: "binary", data: []byte("bin")},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildArchive(t, tt.entries)
+
+			parent := t.TempDir()
+			destDir := filepath.Join(parent, "extract")
+			_, _, err := ExtractReader(bytes.NewReader(data), int64(len(data)), destDir, nil)
+			if err == nil {
+				t.Fatal("Extract should reject the archive")
+			}
+
+			// Nothing may be written outside destDir
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				t.Fatalf("Failed to read parent: %v", err)
+			}
+			for _, e := range entries {
+				if e.Name() != "extract" {
+					t.Errorf("Unexpected file %s outside destination", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchiveErrorType(t *testing.T) {
+	data := buildArchive(t, []testEntry{
+		{name: "manifest.json", data: manifestJSON(t, Manifest{Name: "test-app", Command: "test-binary"})},
+		{name: "binary", data: []byte("bin")},
+		{name: "../evil", data: []byte("x")},
+	})
+
+	_, err := VerifyReader(bytes.NewReader(data), int64(len(data)), nil)
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) {
+		t.Fatalf("Expected ArchiveError, got %v", err)
+	}
+	if archiveErr.Entry != "../evil" {
+		t.Errorf("Expected entry '../evil', got %q", archiveErr.Entry)
+	}
+}
+
+func TestVerifyRejectsOversizedArchive(t *testing.T) {
+	manifest := Manifest{Name: "test-app", Version: "v1.0.0", Command: "test-binary"}
+	manifest.Files = []FileEntry{
+		{Path: "a", Size: maxSporeSize / 2, Mode: 0644},
+		{Path: "b", Size: maxSporeSize / 2, Mode: 0644},
+	}
+
+	// Each entry is within its declared size, but together they are not
+	// within the spore limit
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []testEntry{
+		{name: "manifest.json", data: manifestJSON(t, manifest)},
+		{name: "binary", data: []byte("bin")},
+	} {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", e.name, err)
+		}
+		w.Write(e.data)
+	}
+	for _, name := range []string{"files/a", "files/b"} {
+		header := &zip.FileHeader{Name: name, Method: zip.Store, UncompressedSize64: maxSporeSize / 2, CompressedSize64: 1}
+		w, err := zw.CreateRaw(header)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", name, err)
+		}
+		w.Write([]byte("a"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	data := buf.Bytes()
+
+	_, err := VerifyReader(bytes.NewReader(data), int64(len(data)), nil)
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) || !strings.Contains(archiveErr.Reason, "in total") {
+		t.Errorf("Expected a total size ArchiveError, got %v", err)
+	}
+}
+
+func FuzzExtract(f *testing.F) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		f.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// Seed with a valid spore and a few malformed ones
+	var valid bytes.Buffer
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	if _, err := PackTo(&valid, Payload{Binary: strings.NewReader("bin")}, manifest, priv); err != nil {
+		f.Fatalf("PackTo failed: %v", err)
+	}
+	f.Add(valid.Bytes())
+	f.Add(buildArchive(f, []testEntry{
+		{name: "manifest.json", data: manifestJSON(f, manifest)},
+		{name: "binary", data: []byte("bin")},
+		{name: "../evil", data: []byte("x")},
+	}))
+	f.Add(buildArchive(f, []testEntry{
+		{name: "manifest.json", data: []byte(`{"command":"x","files":[{"path":"a","size":1}]}`)},
+		{name: "binary", data: []byte("bin")},
+		{name: "files/a", data: []byte("a")},
+	}))
+	f.Add([]byte("PK\x03\x04"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parent := t.TempDir()
+		destDir := filepath.Join(parent, "extract")
+
+		_, binaryPath, err := ExtractReader(bytes.NewReader(data), int64(len(data)), destDir, nil)
+		if err == nil && filepath.Dir(binaryPath) != destDir {
+			t.Fatalf("Binary extracted outside destination: %s", binaryPath)
+		}
+
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatalf("Failed to read parent: %v", err)
+		}
+		for _, e := range entries {
+			if e.Name() != "extract" {
+				t.Fatalf("Unexpected file %s outside destination", e.Name())
+			}
+		}
+	})
+}
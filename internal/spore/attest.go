@@ -0,0 +1,333 @@
+package spore
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+)
+
+const (
+	// attestationsPrefix is the archive directory holding attestations
+	attestationsPrefix = "attestations/"
+
+	// maxAttestationSize bounds a single attestation statement
+	maxAttestationSize = 4 << 20
+
+	// StatementType identifies an in-toto v1 statement
+	StatementType = "https://in-toto.io/Statement/v1"
+
+	// PredicateGoProvenance is the predicate type of GoProvenance
+	PredicateGoProvenance = "https://github.com/karadia10/mycelium-mesh/attestation/go-provenance/v1"
+
+	// PredicateGoModules is the predicate type of GoModules
+	PredicateGoModules = "https://github.com/karadia10/mycelium-mesh/attestation/go-modules/v1"
+)
+
+var attestationNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Attestation is a named in-toto statement about a spore's binary. When
+// packing, only Name, PredicateType and Predicate need to be set; the subject
+// is filled in with the binary's digest. Spores with per-platform binaries
+// must say which binary each attestation is about.
+type Attestation struct {
+	Name      string
+	Platform  string // os/arch of the binary, for per-platform spores
+	Statement Statement
+}
+
+// Statement is an in-toto statement
+type Statement struct {
+	Type          string          `json:"_type"`
+	Subject       []Subject       `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// Subject identifies an artifact a statement is about
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// AttestationEntry records an attestation in the signed manifest
+type AttestationEntry struct {
+	Name          string `json:"name"`
+	PredicateType string `json:"predicate_type"`
+	Platform      string `json:"platform,omitempty"`
+	SHA256        string `json:"sha256"`
+	Size          int64  `json:"size"`
+}
+
+// GoProvenance records how a Go binary was built, as embedded by the Go
+// toolchain
+type GoProvenance struct {
+	GoVersion   string            `json:"go_version"`
+	Path        string            `json:"path"`
+	Module      string            `json:"module"`
+	VCS         string            `json:"vcs,omitempty"`
+	VCSRevision string            `json:"vcs_revision,omitempty"`
+	VCSTime     string            `json:"vcs_time,omitempty"`
+	VCSModified bool              `json:"vcs_modified"`
+	Settings    map[string]string `json:"settings,omitempty"`
+}
+
+// CleanTree reports whether the binary was built from a known, unmodified
+// VCS revision
+func (p *GoProvenance) CleanTree() bool {
+	return p.VCSRevision != "" && !p.VCSModified
+}
+
+// GoModules is a module-list SBOM of a Go binary
+type GoModules struct {
+	Main    Module   `json:"main"`
+	Modules []Module `json:"modules"`
+}
+
+// Module is a Go module linked into a binary
+type Module struct {
+	Path    string  `json:"path"`
+	Version string  `json:"version,omitempty"`
+	Sum     string  `json:"sum,omitempty"`
+	Replace *Module `json:"replace,omitempty"`
+}
+
+// NewAttestation returns an attestation carrying predicate, to be packed
+// into a spore
+func NewAttestation(name, predicateType string, predicate any) (Attestation, error) {
+	data, err := json.Marshal(predicate)
+	if err != nil {
+		return Attestation{}, fmt.Errorf("failed to marshal %s predicate: %w", name, err)
+	}
+	return Attestation{
+		Name:      name,
+		Statement: Statement{PredicateType: predicateType, Predicate: data},
+	}, nil
+}
+
+// GoAttestations reads the build information embedded in a Go binary and
+// returns a provenance attestation and a module-list SBOM for it. For a
+// per-platform binary, pass its platform; the attestations are named after it.
+func GoAttestations(binaryPath, platform string) ([]Attestation, error) {
+	info, err := buildinfo.ReadFile(binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Go build info: %w", err)
+	}
+
+	prov := GoProvenance{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Module:    info.Main.Path,
+		Settings:  make(map[string]string),
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs":
+			prov.VCS = s.Value
+		case "vcs.revision":
+			prov.VCSRevision = s.Value
+		case "vcs.time":
+			prov.VCSTime = s.Value
+		case "vcs.modified":
+			prov.VCSModified = s.Value == "true"
+		default:
+			prov.Settings[s.Key] = s.Value
+		}
+	}
+
+	sbom := GoModules{Main: goModule(&info.Main)}
+	for _, dep := range info.Deps {
+		sbom.Modules = append(sbom.Modules, goModule(dep))
+	}
+
+	suffix := ""
+	if platform != "" {
+		suffix = "-" + strings.ReplaceAll(platform, "/", "-")
+	}
+	provenance, err := NewAttestation("provenance"+suffix, PredicateGoProvenance, prov)
+	if err != nil {
+		return nil, err
+	}
+	modules, err := NewAttestation("sbom"+suffix, PredicateGoModules, sbom)
+	if err != nil {
+		return nil, err
+	}
+	provenance.Platform, modules.Platform = platform, platform
+	return []Attestation{provenance, modules}, nil
+}
+
+// goModule converts a module from debug.BuildInfo
+func goModule(m *debug.Module) Module {
+	mod := Module{Path: m.Path, Version: m.Version, Sum: m.Sum}
+	if m.Replace != nil {
+		replace := goModule(m.Replace)
+		mod.Replace = &replace
+	}
+	return mod
+}
+
+// Decode unmarshals the attestation's predicate into v
+func (a *Attestation) Decode(v any) error {
+	if err := json.Unmarshal(a.Statement.Predicate, v); err != nil {
+		return fmt.Errorf("failed to decode attestation %s: %w", a.Name, err)
+	}
+	return nil
+}
+
+// FindAttestation returns the first attestation with the given predicate
+// type, or nil
+func FindAttestation(atts []Attestation, predicateType string) *Attestation {
+	for i := range atts {
+		if atts[i].Statement.PredicateType == predicateType {
+			return &atts[i]
+		}
+	}
+	return nil
+}
+
+// PlatformAttestations returns the attestations about the binary that runs
+// on platform: those naming it, plus any about a single-binary spore
+func PlatformAttestations(atts []Attestation, platform string) []Attestation {
+	var out []Attestation
+	for _, a := range atts {
+		if a.Platform == "" || a.Platform == platform {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// VerifyAttestations verifies a spore like Verify and returns its
+// attestations, each checked to be about the spore's binary
+func VerifyAttestations(sporePath string, kr *Keyring) (*Manifest, []Attestation, error) {
+	file, err := os.Open(sporePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open spore file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat spore file: %w", err)
+	}
+
+	return verifyReader(file, info.Size(), kr)
+}
+
+// VerifyAttestationsReader is VerifyAttestations for a spore read from r
+func VerifyAttestationsReader(r io.ReaderAt, size int64, kr *Keyring) (*Manifest, []Attestation, error) {
+	return verifyReader(r, size, kr)
+}
+
+// attestationEntryName returns the archive entry name for an attestation
+func attestationEntryName(name string) string {
+	return attestationsPrefix + name + ".json"
+}
+
+// checkAttestations rejects invalid or duplicate attestation names and sizes
+func checkAttestations(entries []AttestationEntry) error {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !attestationNamePattern.MatchString(e.Name) {
+			return fmt.Errorf("invalid attestation name %q", e.Name)
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("duplicate attestation %q", e.Name)
+		}
+		seen[e.Name] = true
+		if e.Size < 0 || e.Size > maxAttestationSize {
+			return fmt.Errorf("attestation %s has invalid size %d", e.Name, e.Size)
+		}
+	}
+	return nil
+}
+
+// writeAttestation adds an attestation about the binary to a spore being
+// written and returns its entry
+func writeAttestation(zw *zip.Writer, a Attestation, m *Manifest) (AttestationEntry, error) {
+	digest, err := m.binaryDigest(a.Platform)
+	if err != nil {
+		return AttestationEntry{}, fmt.Errorf("attestation %s: %w", a.Name, err)
+	}
+
+	statement := a.Statement
+	statement.Type = StatementType
+	statement.Subject = []Subject{{Name: "binary", Digest: map[string]string{"sha256": digest}}}
+
+	data, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return AttestationEntry{}, fmt.Errorf("failed to marshal attestation %s: %w", a.Name, err)
+	}
+	if len(data) > maxAttestationSize {
+		return AttestationEntry{}, fmt.Errorf("attestation %s exceeds %d bytes", a.Name, maxAttestationSize)
+	}
+
+	w, err := zw.Create(attestationEntryName(a.Name))
+	if err != nil {
+		return AttestationEntry{}, fmt.Errorf("failed to create attestation %s in zip: %w", a.Name, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return AttestationEntry{}, fmt.Errorf("failed to write attestation %s: %w", a.Name, err)
+	}
+
+	return AttestationEntry{
+		Name:          a.Name,
+		PredicateType: statement.PredicateType,
+		Platform:      a.Platform,
+		SHA256:        fmt.Sprintf("%x", sha256.Sum256(data)),
+		Size:          int64(len(data)),
+	}, nil
+}
+
+// readAttestation reads and hash-checks an attestation entry. The statement
+// must be about the manifest's binary.
+func readAttestation(m *Manifest, e AttestationEntry, file *zip.File) (Attestation, error) {
+	var buf bytes.Buffer
+	hash, err := copyEntry(&buf, file, e.Size)
+	if err != nil {
+		return Attestation{}, err
+	}
+	if got := fmt.Sprintf("%x", hash); got != e.SHA256 {
+		return Attestation{}, fmt.Errorf("attestation %s %w: expected %s, got %s", e.Name, ErrHashMismatch, e.SHA256, got)
+	}
+
+	a := Attestation{Name: e.Name, Platform: e.Platform}
+	if err := json.Unmarshal(buf.Bytes(), &a.Statement); err != nil {
+		return Attestation{}, fmt.Errorf("failed to parse attestation %s: %w", e.Name, err)
+	}
+	if a.Statement.PredicateType != e.PredicateType {
+		return Attestation{}, fmt.Errorf("attestation %s has predicate type %s, manifest records %s", e.Name, a.Statement.PredicateType, e.PredicateType)
+	}
+	digest, err := m.binaryDigest(e.Platform)
+	if err != nil {
+		return Attestation{}, fmt.Errorf("attestation %s: %w", e.Name, err)
+	}
+	for _, s := range a.Statement.Subject {
+		if s.Digest["sha256"] == digest {
+			return a, nil
+		}
+	}
+	return Attestation{}, fmt.Errorf("attestation %s is not about this spore's binary", e.Name)
+}
+
+// hashAttestations checks every attestation entry against the manifest
+// without keeping its contents
+func hashAttestations(m *Manifest, entries map[string]*zip.File) error {
+	for _, e := range m.Attestations {
+		hash, err := copyEntry(io.Discard, entries[attestationEntryName(e.Name)], e.Size)
+		if err != nil {
+			return err
+		}
+		if got := fmt.Sprintf("%x", hash); got != e.SHA256 {
+			return fmt.Errorf("attestation %s %w: expected %s, got %s", e.Name, ErrHashMismatch, e.SHA256, got)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGoAttestations(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find test binary: %v", err)
+	}
+
+	atts, err := GoAttestations(exe, "")
+	if err != nil {
+		t.Fatalf("GoAttestations failed: %v", err)
+	}
+
+	var prov GoProvenance
+	if err := FindAttestation(atts, PredicateGoProvenance).Decode(&prov); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if prov.GoVersion != runtime.Version() {
+		t.Errorf("Expected Go version %s, got %s", runtime.Version(), prov.GoVersion)
+	}
+
+	var sbom GoModules
+	if err := FindAttestation(atts, PredicateGoModules).Decode(&sbom); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if sbom.Main.Path != "github.com/karadia10/mycelium-mesh" {
+		t.Errorf("Expected main module github.com/karadia10/mycelium-mesh, got %s", sbom.Main.Path)
+	}
+
+	notGo := filepath.Join(t.TempDir(), "script")
+	if err := os.WriteFile(notGo, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := GoAttestations(notGo, ""); err == nil {
+		t.Error("GoAttestations should fail for a non-Go binary")
+	}
+}
+
+// packAttested packs a spore carrying a provenance attestation
+func packAttested(t *testing.T, prov GoProvenance) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	att, err := NewAttestation("provenance", PredicateGoProvenance, prov)
+	if err != nil {
+		t.Fatalf("NewAttestation failed: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	payload := Payload{Binary: strings.NewReader("test binary content"), Attestations: []Attestation{att}}
+	sporePath, _, err := PackPayload(payload, manifest, priv, t.TempDir())
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+	return sporePath
+}
+
+func TestVerifyAttestations(t *testing.T) {
+	sporePath := packAttested(t, GoProvenance{GoVersion: "go1.24.0", VCSRevision: "abc123"})
+
+	manifest, atts, err := VerifyAttestations(sporePath, nil)
+	if err != nil {
+		t.Fatalf("VerifyAttestations failed: %v", err)
+	}
+	if len(manifest.Attestations) != 1 || len(atts) != 1 {
+		t.Fatalf("Expected 1 attestation, got %d in manifest and %d read", len(manifest.Attestations), len(atts))
+	}
+
+	statement := atts[0].Statement
+	if statement.Type != StatementType {
+		t.Errorf("Expected statement type %s, got %s", StatementType, statement.Type)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Digest["sha256"] != manifest.BinarySHA256 {
+		t.Errorf("Expected subject to be the binary, got %+v", statement.Subject)
+	}
+
+	// Extract checks attestations too
+	if _, _, err := Extract(sporePath, filepath.Join(t.TempDir(), "extract"), nil); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperedAttestation(t *testing.T) {
+	sporePath := packAttested(t, GoProvenance{VCSRevision: "abc123"})
+	manifest, atts, err := VerifyAttestations(sporePath, nil)
+	if err != nil {
+		t.Fatalf("VerifyAttestations failed: %v", err)
+	}
+
+	// Rebuild the archive with an edited statement but the signed manifest
+	binary := []byte("test binary content")
+	atts[0].Statement.Predicate = bytes.Replace(atts[0].Statement.Predicate, []byte("abc123"), []byte("def456"), 1)
+	statement, err := json.Marshal(atts[0].Statement)
+	if err != nil {
+		t.Fatalf("Failed to marshal statement: %v", err)
+	}
+	data := buildArchive(t, []testEntry{
+		{name: "binary", data: binary},
+		{name: attestationEntryName("provenance"), data: statement},
+		{name: "manifest.json", data: manifestJSON(t, *manifest)},
+	})
+
+	_, err = VerifyReader(bytes.NewReader(data), int64(len(data)), nil)
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Expected hash mismatch, got %v", err)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	clean := packAttested(t, GoProvenance{VCSRevision: "abc123"})
+	dirty := packAttested(t, GoProvenance{VCSRevision: "abc123", VCSModified: true})
+	noVCS := packAttested(t, GoProvenance{GoVersion: "go1.24.0"})
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	bare := packTestSpore(t, priv)
+
+	policy := &Policy{RequireCleanTree: true}
+	tests := []struct {
+		name    string
+		path    string
+		allowed bool
+	}{
+		{"clean", clean, true},
+		{"modified", dirty, false},
+		{"no vcs", noVCS, false},
+		{"no provenance", bare, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, atts, err := VerifyAttestations(tt.path, nil)
+			if err != nil {
+				t.Fatalf("VerifyAttestations failed: %v", err)
+			}
+			err = policy.Check(m, atts)
+			if tt.allowed && err != nil {
+				t.Errorf("Policy rejected spore: %v", err)
+			}
+			var policyErr *PolicyError
+			if !tt.allowed && !errors.As(err, &policyErr) {
+				t.Errorf("Expected PolicyError, got %v", err)
+			}
+		})
+	}
+
+	// The zero policy allows anything
+	m, atts, err := VerifyAttestations(bare, nil)
+	if err != nil {
+		t.Fatalf("VerifyAttestations failed: %v", err)
+	}
+	if err := (&Policy{}).Check(m, atts); err != nil {
+		t.Errorf("Zero policy rejected spore: %v", err)
+	}
+}
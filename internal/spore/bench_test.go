@@ -0,0 +1,94 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchPayloadSize is the binary size used by the streaming benchmarks
+const benchPayloadSize = 1 << 30
+
+// zeroReader yields an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+var benchManifest = Manifest{
+	Name:      "bench-app",
+	Version:   "v1.0.0",
+	Command:   "bench",
+	Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+}
+
+// packBenchSpore writes a spore with a 1 GB binary to a temp directory
+func packBenchSpore(b *testing.B, priv ed25519.PrivateKey) string {
+	b.Helper()
+
+	sporePath := filepath.Join(b.TempDir(), "bench.spore")
+	file, err := os.Create(sporePath)
+	if err != nil {
+		b.Fatalf("Failed to create spore: %v", err)
+	}
+	defer file.Close()
+
+	payload := Payload{Binary: io.LimitReader(zeroReader{}, benchPayloadSize)}
+	if _, err := PackTo(file, payload, benchManifest, priv); err != nil {
+		b.Fatalf("PackTo failed: %v", err)
+	}
+	return sporePath
+}
+
+func BenchmarkPack1GB(b *testing.B) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatalf("Failed to generate key: %v", err)
+	}
+
+	b.SetBytes(benchPayloadSize)
+	b.ReportAllocs()
+	for b.Loop() {
+		payload := Payload{Binary: io.LimitReader(zeroReader{}, benchPayloadSize)}
+		if _, err := PackTo(io.Discard, payload, benchManifest, priv); err != nil {
+			b.Fatalf("PackTo failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkVerify1GB(b *testing.B) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packBenchSpore(b, priv)
+
+	b.SetBytes(benchPayloadSize)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Verify(sporePath, nil); err != nil {
+			b.Fatalf("Verify failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkExtract1GB(b *testing.B) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packBenchSpore(b, priv)
+	destDir := b.TempDir()
+
+	b.SetBytes(benchPayloadSize)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, err := Extract(sporePath, destDir, nil); err != nil {
+			b.Fatalf("Extract failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// EncryptionX25519AESGCM wraps a random AES-256 key to each recipient
+	// with X25519 and HKDF-SHA256, and seals the binary in AES-256-GCM chunks
+	EncryptionX25519AESGCM = "x25519-hkdf-sha256-aes256gcm-chunked/v1"
+
+	// encryptionChunkSize is the plaintext size of each sealed chunk
+	encryptionChunkSize = 64 << 10
+
+	wrapInfo = "mycelium-mesh spore key v1"
+)
+
+// ErrNotRecipient is returned when extracting an encrypted spore without a
+// node key it was encrypted to
+var ErrNotRecipient = errors.New("spore is not encrypted to this node")
+
+// Encryption records how a spore's binary entry is encrypted. The manifest
+// stays readable; the binary hash and size it records are of the ciphertext,
+// so spores verify without a node key.
+type Encryption struct {
+	Algorithm       string      `json:"algorithm"`
+	ChunkSize       int         `json:"chunk_size"`
+	PlaintextSHA256 string      `json:"plaintext_sha256"`
+	PlaintextSize   int64       `json:"plaintext_size"`
+	Recipients      []Recipient `json:"recipients"`
+}
+
+// Recipient is the binary's data key wrapped to one node key
+type Recipient struct {
+	KeyID        string `json:"key_id"`
+	EphemeralKey string `json:"ephemeral_key"` // X25519 public key, base64
+	WrappedKey   string `json:"wrapped_key"`   // base64
+}
+
+// GenerateNodeKey creates an X25519 key that spores can be encrypted to
+func GenerateNodeKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// NodeKeyID returns a short, stable identifier for a node public key
+func NodeKeyID(pub *ecdh.PublicKey) string {
+	hash := sha256.Sum256(pub.Bytes())
+	return fmt.Sprintf("%x", hash[:8])
+}
+
+// MarshalNodePrivateKey encodes a node key as PKCS#8 PEM
+func MarshalNodePrivateKey(key *ecdh.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+}
+
+// MarshalNodePublicKey encodes a node public key as PKIX PEM
+func MarshalNodePublicKey(pub *ecdh.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+// ParseNodePrivateKey decodes a PEM node key
+func ParseNodePrivateKey(data []byte) (*ecdh.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemPrivateKey {
+		return nil, fmt.Errorf("node key is not a PEM %q block", pemPrivateKey)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node key: %w", err)
+	}
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("node key is %T, not X25519", key)
+	}
+	return priv, nil
+}
+
+// ParseNodePublicKey decodes a PEM node public key. A node private key is
+// accepted as well, in which case its public half is used.
+func ParseNodePublicKey(data []byte) (*ecdh.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("unrecognised node public key format")
+	}
+	if block.Type == pemPrivateKey {
+		priv, err := ParseNodePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return priv.PublicKey(), nil
+	}
+	if block.Type != pemPublicKey {
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node public key: %w", err)
+	}
+	pub, ok := key.(*ecdh.PublicKey)
+	if !ok || pub.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("node public key is %T, not X25519", key)
+	}
+	return pub, nil
+}
+
+// Encrypt re-packs a verified, unencrypted spore in place with its binary
+// encrypted to recipients, signing it again with priv. Cosignatures do not
+// carry over, since the signed binary hash changes.
+func Encrypt(sporePath string, priv ed25519.PrivateKey, recipients []*ecdh.PublicKey) (*Manifest, error) {
+	file, err := os.Open(sporePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spore file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat spore file: %w", err)
+	}
+
+	manifest, atts, err := verifyReader(file, info.Size(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("spore verification failed: %w", err)
+	}
+	if manifest.Encryption != nil {
+		return nil, fmt.Errorf("spore is already encrypted")
+	}
+	if len(manifest.Binaries) > 0 {
+		return nil, fmt.Errorf("spores with per-platform binaries cannot be encrypted")
+	}
+
+	zipReader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spore file: %w", err)
+	}
+	_, entries, err := readArchive(zipReader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repack every entry as it is, encrypting the binary
+	binaryReader, err := entries["binary"].Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open binary: %w", err)
+	}
+	defer binaryReader.Close()
+
+	payload := Payload{Binary: binaryReader, Attestations: atts, CreatedAt: manifest.CreatedAt, Recipients: recipients}
+	for _, f := range manifest.Files {
+		entry := entries[fileEntryName(f.Path)]
+		payload.Files = append(payload.Files, File{Path: f.Path, Mode: f.Mode, Open: entry.Open})
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(sporePath), ".spore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	out, err := PackTo(tmpFile, payload, *manifest, priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close spore: %w", err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to set spore permissions: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), sporePath); err != nil {
+		return nil, fmt.Errorf("failed to replace spore: %w", err)
+	}
+
+	return out, nil
+}
+
+// encryptBinary streams r to w encrypted to recipients, recording the
+// encryption in m, and returns the ciphertext size
+func encryptBinary(w io.Writer, r io.Reader, recipients []*ecdh.PublicKey, m *Manifest) (int64, error) {
+	enc, dataKey, err := newEncryption(recipients)
+	if err != nil {
+		return 0, err
+	}
+	encWriter, err := newEncryptWriter(w, dataKey, enc.ChunkSize)
+	if err != nil {
+		return 0, err
+	}
+
+	plainHasher := sha256.New()
+	n, err := io.Copy(encWriter, io.TeeReader(r, plainHasher))
+	if err != nil {
+		return 0, err
+	}
+	if err := encWriter.Close(); err != nil {
+		return 0, err
+	}
+	enc.PlaintextSHA256 = fmt.Sprintf("%x", plainHasher.Sum(nil))
+	enc.PlaintextSize = n
+	m.Encryption = enc
+
+	// Every chunk, including a final empty one, carries an authentication tag
+	chunks := (n + int64(enc.ChunkSize) - 1) / int64(enc.ChunkSize)
+	if chunks == 0 {
+		chunks = 1
+	}
+	return n + chunks*int64(encWriter.aead.Overhead()), nil
+}
+
+// newEncryption generates a data key and wraps it to every recipient
+func newEncryption(recipients []*ecdh.PublicKey) (*Encryption, []byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	enc := &Encryption{Algorithm: EncryptionX25519AESGCM, ChunkSize: encryptionChunkSize}
+	seen := make(map[string]bool)
+	for _, pub := range recipients {
+		keyID := NodeKeyID(pub)
+		if seen[keyID] {
+			return nil, nil, fmt.Errorf("duplicate recipient %s", keyID)
+		}
+		seen[keyID] = true
+
+		ephemeral, err := GenerateNodeKey()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		aead, err := wrapCipher(ephemeral, pub, ephemeral.PublicKey(), pub)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		enc.Recipients = append(enc.Recipients, Recipient{
+			KeyID:        keyID,
+			EphemeralKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+			WrappedKey:   base64.StdEncoding.EncodeToString(aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, nil)),
+		})
+	}
+
+	return enc, dataKey, nil
+}
+
+// dataKey unwraps the binary's data key with a recipient's node key
+func (e *Encryption) dataKey(nodeKey *ecdh.PrivateKey) ([]byte, error) {
+	if e.Algorithm != EncryptionX25519AESGCM {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+	}
+	if e.ChunkSize <= 0 || e.ChunkSize > maxManifestSize {
+		return nil, fmt.Errorf("invalid encryption chunk size %d", e.ChunkSize)
+	}
+	if nodeKey == nil {
+		return nil, fmt.Errorf("%w: no node key", ErrNotRecipient)
+	}
+
+	keyID := NodeKeyID(nodeKey.PublicKey())
+	for _, r := range e.Recipients {
+		if r.KeyID != keyID {
+			continue
+		}
+
+		ephemeralData, err := base64.StdEncoding.DecodeString(r.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(r.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wrapped key: %w", err)
+		}
+
+		aead, err := wrapCipher(nodeKey, ephemeral, ephemeral, nodeKey.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key for %s: %w", keyID, err)
+		}
+		return dataKey, nil
+	}
+
+	return nil, fmt.Errorf("%w: node key %s is not a recipient", ErrNotRecipient, keyID)
+}
+
+// wrapCipher derives the key-wrapping cipher shared by an ephemeral key and
+// a recipient key
+func wrapCipher(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, wrapInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	return newGCM(key)
+}
+
+// newGCM returns AES-256-GCM under key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce numbers each chunk and marks the last one, so chunks cannot be
+// reordered, dropped or truncated undetected
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// chunkWriter seals or opens a stream of chunks, writing the result to w.
+// The final, possibly short, chunk is processed by Close.
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	seal    bool
+	size    int // input chunk size
+	buf     []byte
+	counter uint64
+	plain   hash.Hash // hashes plaintext when opening
+}
+
+// newEncryptWriter returns a writer that encrypts plaintext to w
+func newEncryptWriter(w io.Writer, dataKey []byte, chunkSize int) (*chunkWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkWriter{w: w, aead: aead, seal: true, size: chunkSize}, nil
+}
+
+// newDecryptWriter returns a writer that decrypts ciphertext to w, hashing
+// the plaintext
+func newDecryptWriter(w io.Writer, dataKey []byte, chunkSize int) (*chunkWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkWriter{w: w, aead: aead, size: chunkSize + aead.Overhead(), plain: sha256.New()}, nil
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	// Keep at least one byte back so Close always has the final chunk
+	for len(c.buf) > c.size {
+		if err := c.process(c.buf[:c.size], false); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[c.size:]...)
+	}
+	return len(p), nil
+}
+
+// Close processes the final chunk
+func (c *chunkWriter) Close() error {
+	return c.process(c.buf, true)
+}
+
+func (c *chunkWriter) process(chunk []byte, last bool) error {
+	nonce := chunkNonce(c.counter, last)
+	c.counter++
+
+	if c.seal {
+		_, err := c.w.Write(c.aead.Seal(nil, nonce, chunk, nil))
+		return err
+	}
+
+	plaintext, err := c.aead.Open(nil, nonce, chunk, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt binary chunk %d: %w", c.counter-1, err)
+	}
+	c.plain.Write(plaintext)
+	_, err = c.w.Write(plaintext)
+	return err
+}
@@ -0,0 +1,205 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// packEncrypted packs a spore whose binary is encrypted to recipients
+func packEncrypted(t *testing.T, binary []byte, recipients ...*ecdh.PublicKey) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	payload := Payload{Binary: bytes.NewReader(binary), Recipients: recipients}
+	sporePath, _, err := PackPayload(payload, manifest, priv, t.TempDir())
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+	return sporePath
+}
+
+func generateNodeKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := GenerateNodeKey()
+	if err != nil {
+		t.Fatalf("GenerateNodeKey failed: %v", err)
+	}
+	return key
+}
+
+func TestEncryptedSpore(t *testing.T) {
+	nodeA, nodeB, outsider := generateNodeKey(t), generateNodeKey(t), generateNodeKey(t)
+
+	// Span several chunks, ending mid-chunk
+	binary := make([]byte, 3*encryptionChunkSize+123)
+	if _, err := rand.Read(binary); err != nil {
+		t.Fatalf("Failed to generate binary: %v", err)
+	}
+	sporePath := packEncrypted(t, binary, nodeA.PublicKey(), nodeB.PublicKey())
+
+	// Verification and inspection need no node key
+	manifest, err := Verify(sporePath, nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if manifest.Encryption == nil || len(manifest.Encryption.Recipients) != 2 {
+		t.Fatalf("Expected encryption to 2 recipients, got %+v", manifest.Encryption)
+	}
+	data, err := os.ReadFile(sporePath)
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	if bytes.Contains(data, binary[:64]) {
+		t.Error("Spore contains plaintext binary")
+	}
+
+	// Every recipient can extract the plaintext
+	for _, key := range []*ecdh.PrivateKey{nodeA, nodeB} {
+		_, binaryPath, err := ExtractWithKey(sporePath, filepath.Join(t.TempDir(), "extract"), nil, key)
+		if err != nil {
+			t.Fatalf("ExtractWithKey failed: %v", err)
+		}
+		got, err := os.ReadFile(binaryPath)
+		if err != nil {
+			t.Fatalf("Failed to read binary: %v", err)
+		}
+		if !bytes.Equal(got, binary) {
+			t.Error("Decrypted binary does not match")
+		}
+	}
+
+	// Others cannot, and nothing is written
+	for name, key := range map[string]*ecdh.PrivateKey{"no key": nil, "outsider": outsider} {
+		destDir := filepath.Join(t.TempDir(), "extract")
+		_, _, err := ExtractWithKey(sporePath, destDir, nil, key)
+		if !errors.Is(err, ErrNotRecipient) {
+			t.Errorf("%s: expected ErrNotRecipient, got %v", name, err)
+		}
+		if Reason(err) != ReasonNotRecipient {
+			t.Errorf("%s: expected reason %q, got %q", name, ReasonNotRecipient, Reason(err))
+		}
+		if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+			t.Errorf("%s: extract directory should not exist", name)
+		}
+	}
+}
+
+func TestEncryptedSporeChunkBoundaries(t *testing.T) {
+	node := generateNodeKey(t)
+	for _, size := range []int{0, 1, encryptionChunkSize, 2 * encryptionChunkSize} {
+		binary := bytes.Repeat([]byte{0x5a}, size)
+		sporePath := packEncrypted(t, binary, node.PublicKey())
+
+		_, binaryPath, err := ExtractWithKey(sporePath, filepath.Join(t.TempDir(), "extract"), nil, node)
+		if err != nil {
+			t.Fatalf("size %d: ExtractWithKey failed: %v", size, err)
+		}
+		got, err := os.ReadFile(binaryPath)
+		if err != nil {
+			t.Fatalf("Failed to read binary: %v", err)
+		}
+		if !bytes.Equal(got, binary) {
+			t.Errorf("size %d: decrypted binary does not match", size)
+		}
+	}
+}
+
+func TestEncryptExistingSpore(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	node := generateNodeKey(t)
+
+	sporePath := packAttested(t, GoProvenance{GoVersion: "go1.24.0"})
+	before, atts, err := VerifyAttestations(sporePath, nil)
+	if err != nil {
+		t.Fatalf("VerifyAttestations failed: %v", err)
+	}
+
+	if _, err := Encrypt(sporePath, priv, []*ecdh.PublicKey{node.PublicKey()}); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if _, err := Encrypt(sporePath, priv, []*ecdh.PublicKey{node.PublicKey()}); err == nil {
+		t.Error("Encrypting twice should fail")
+	}
+
+	// Attestations still refer to the plaintext binary
+	after, encAtts, err := VerifyAttestations(sporePath, nil)
+	if err != nil {
+		t.Fatalf("VerifyAttestations failed: %v", err)
+	}
+	if after.Encryption.PlaintextSHA256 != before.BinarySHA256 {
+		t.Errorf("Expected plaintext hash %s, got %s", before.BinarySHA256, after.Encryption.PlaintextSHA256)
+	}
+	if len(encAtts) != len(atts) {
+		t.Errorf("Expected %d attestations, got %d", len(atts), len(encAtts))
+	}
+	if after.KeyID != Fingerprint(priv.Public().(ed25519.PublicKey)) {
+		t.Errorf("Expected spore re-signed by the new key, got %s", after.KeyID)
+	}
+
+	_, binaryPath, err := ExtractWithKey(sporePath, filepath.Join(t.TempDir(), "extract"), nil, node)
+	if err != nil {
+		t.Fatalf("ExtractWithKey failed: %v", err)
+	}
+	got, err := os.ReadFile(binaryPath)
+	if err != nil {
+		t.Fatalf("Failed to read binary: %v", err)
+	}
+	if string(got) != "test binary content" {
+		t.Errorf("Unexpected binary %q", got)
+	}
+}
+
+func TestNodeKeyRoundTrip(t *testing.T) {
+	key := generateNodeKey(t)
+
+	privData, err := MarshalNodePrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalNodePrivateKey failed: %v", err)
+	}
+	pubData, err := MarshalNodePublicKey(key.PublicKey())
+	if err != nil {
+		t.Fatalf("MarshalNodePublicKey failed: %v", err)
+	}
+
+	parsed, err := ParseNodePrivateKey(privData)
+	if err != nil || !parsed.Equal(key) {
+		t.Errorf("ParseNodePrivateKey round trip failed: %v", err)
+	}
+	for _, data := range [][]byte{pubData, privData} {
+		pub, err := ParseNodePublicKey(data)
+		if err != nil || !pub.Equal(key.PublicKey()) {
+			t.Errorf("ParseNodePublicKey round trip failed: %v", err)
+		}
+	}
+
+	// Signing keys are not node keys
+	_, signing, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signingData, err := MarshalPrivateKey(signing, KeyFormatPEM, nil)
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey failed: %v", err)
+	}
+	if _, err := ParseNodePrivateKey(signingData); err == nil {
+		t.Error("ParseNodePrivateKey should reject an ed25519 key")
+	}
+}
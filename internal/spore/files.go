@@ -0,0 +1,216 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// filesPrefix is the archive directory holding extra files
+const filesPrefix = "files/"
+
+// File is an extra file to pack into a spore, such as a static asset,
+// template or CA bundle
+type File struct {
+	Path string      // slash-separated path relative to the extract root
+	Mode fs.FileMode // permission bits
+	Open func() (io.ReadCloser, error)
+}
+
+// FileEntry records an extra file in the signed manifest
+type FileEntry struct {
+	Path   string      `json:"path"`
+	SHA256 string      `json:"sha256"`
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+}
+
+// OSFile returns a File reading src from disk, packed at path
+func OSFile(src, path string) (File, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return File{}, fmt.Errorf("%s is not a regular file", src)
+	}
+
+	return File{
+		Path: path,
+		Mode: info.Mode().Perm(),
+		Open: func() (io.ReadCloser, error) { return os.Open(src) },
+	}, nil
+}
+
+// DirFiles returns every regular file under root, packed at prefix joined
+// with its path relative to root. Symlinks and other special files are
+// rejected.
+func DirFiles(root, prefix string) ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", p)
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := OSFile(p, path.Join(prefix, filepath.ToSlash(rel)))
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// fileEntryName returns the archive entry name for a file path
+func fileEntryName(p string) string {
+	return filesPrefix + p
+}
+
+// validFilePath checks that p is a clean, relative, slash-separated path
+func validFilePath(p string) error {
+	if unsafePath(p) {
+		return fmt.Errorf("invalid file path %q", p)
+	}
+	return nil
+}
+
+// unsafePath reports whether p is empty, absolute, non-canonical or escapes
+// its root
+func unsafePath(p string) bool {
+	return p == "" || p != path.Clean(p) || path.IsAbs(p) || strings.ContainsAny(p, "\\\x00") ||
+		p == ".." || strings.HasPrefix(p, "../")
+}
+
+// checkFilePaths rejects invalid, duplicate or reserved file paths, which
+// would otherwise collide when the spore is extracted
+func checkFilePaths(m *Manifest, paths []string) error {
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if err := validFilePath(p); err != nil {
+			return err
+		}
+		if p == "manifest.json" || p == m.Command || strings.HasPrefix(p, m.Command+"/") {
+			return fmt.Errorf("file path %q is reserved", p)
+		}
+		if seen[p] {
+			return fmt.Errorf("duplicate file path %q", p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+// check compares a computed hash with the manifest entry
+func (e FileEntry) check(hash []byte) error {
+	if got := fmt.Sprintf("%x", hash); got != e.SHA256 {
+		return fmt.Errorf("file %s %w: expected %s, got %s", e.Path, ErrHashMismatch, e.SHA256, got)
+	}
+	return nil
+}
+
+// writeFile adds a file to a spore being written and returns its entry
+func writeFile(zw *zip.Writer, f File) (FileEntry, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("failed to open file %s: %w", f.Path, err)
+	}
+	defer rc.Close()
+
+	mode := f.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+
+	header := &zip.FileHeader{Name: fileEntryName(f.Path), Method: zip.Deflate}
+	header.SetMode(mode)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("failed to create file %s in zip: %w", f.Path, err)
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hasher), rc)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("failed to write file %s: %w", f.Path, err)
+	}
+
+	return FileEntry{
+		Path:   f.Path,
+		SHA256: fmt.Sprintf("%x", hasher.Sum(nil)),
+		Size:   n,
+		Mode:   mode,
+	}, nil
+}
+
+// extractFile writes a file entry under destDir, verifying its hash. It
+// returns every path it created so the caller can clean up on failure.
+func extractFile(destDir string, e FileEntry, file *zip.File) ([]string, error) {
+	var created []string
+
+	destDir = filepath.Clean(destDir)
+	destPath := filepath.Join(destDir, filepath.FromSlash(e.Path))
+
+	// Create parent directories, remembering which ones are new
+	var dirs []string
+	for dir := filepath.Dir(destPath); dir != destDir; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Mkdir(dirs[i], 0755); err != nil {
+			return created, fmt.Errorf("failed to create directory for %s: %w", e.Path, err)
+		}
+		created = append(created, dirs[i])
+	}
+
+	destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, e.Mode.Perm())
+	if err != nil {
+		return created, fmt.Errorf("failed to create file %s: %w", destPath, err)
+	}
+	created = append(created, destPath)
+
+	hash, err := copyEntry(destFile, file, e.Size)
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return created, fmt.Errorf("failed to extract file %s: %w", e.Path, err)
+	}
+
+	if err := e.check(hash); err != nil {
+		return created, err
+	}
+
+	// Apply the recorded mode regardless of umask
+	if err := os.Chmod(destPath, e.Mode.Perm()); err != nil {
+		return created, fmt.Errorf("failed to set mode on %s: %w", e.Path, err)
+	}
+
+	return created, nil
+}
@@ -0,0 +1,207 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files under root from a path -> content map
+func writeTree(t *testing.T, root string, files map[string]string, mode os.FileMode) {
+	t.Helper()
+	for p, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), mode); err != nil {
+			t.Fatalf("Failed to write %s: %v", p, err)
+		}
+	}
+}
+
+func TestPackAndExtractFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Build a tree of assets
+	assetsDir := filepath.Join(tempDir, "assets")
+	writeTree(t, assetsDir, map[string]string{
+		"static/index.html":   "<html></html>",
+		"templates/page.tmpl": "{{.Title}}",
+	}, 0644)
+	writeTree(t, assetsDir, map[string]string{"bin/hook.sh": "#!/bin/sh\n"}, 0750)
+
+	files, err := DirFiles(assetsDir, "")
+	if err != nil {
+		t.Fatalf("DirFiles failed: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(files))
+	}
+
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	payload := Payload{Binary: strings.NewReader("test binary content"), Files: files}
+	sporePath, packed, err := PackPayload(payload, manifest, privKey, tempDir)
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+	if len(packed.Files) != 3 {
+		t.Fatalf("Expected 3 file entries in manifest, got %d", len(packed.Files))
+	}
+
+	if _, err := Verify(sporePath, nil); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	extractDir := filepath.Join(tempDir, "extracted")
+	if _, _, err := Extract(sporePath, extractDir, nil); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(extractDir, "templates", "page.tmpl"))
+	if err != nil {
+		t.Fatalf("Failed to read extracted template: %v", err)
+	}
+	if string(data) != "{{.Title}}" {
+		t.Errorf("Unexpected template content %q", data)
+	}
+
+	info, err := os.Stat(filepath.Join(extractDir, "bin", "hook.sh"))
+	if err != nil {
+		t.Fatalf("Failed to stat extracted hook: %v", err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("Expected mode 0750, got %o", info.Mode().Perm())
+	}
+}
+
+func TestExtractRejectsTamperedFile(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTree(t, tempDir, map[string]string{"ca.pem": "trusted"}, 0644)
+
+	f, err := OSFile(filepath.Join(tempDir, "ca.pem"), "certs/ca.pem")
+	if err != nil {
+		t.Fatalf("OSFile failed: %v", err)
+	}
+
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	payload := Payload{Binary: strings.NewReader("test binary content"), Files: []File{f}}
+	sporePath, packed, err := PackPayload(payload, manifest, privKey, tempDir)
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+
+	// Swap the file's content; the signed manifest still verifies, so the
+	// file check must catch it and leave nothing behind
+	tampered := filepath.Join(tempDir, "tampered.spore")
+	if err := rewriteEntry(sporePath, tampered, "files/certs/ca.pem", []byte("evilcrt")); err != nil {
+		t.Fatalf("rewriteEntry failed: %v", err)
+	}
+	extractDir := filepath.Join(tempDir, "extracted")
+	if _, _, err := Extract(tampered, extractDir, nil); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("Expected file hash mismatch, got %v", err)
+	}
+	entries, err := os.ReadDir(extractDir)
+	if err != nil {
+		t.Fatalf("Failed to read destination: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected empty destination after failed extract, found %d entries", len(entries))
+	}
+
+	// Claim a different hash for the file instead; the signature no longer
+	// matches, and is checked before anything is written
+	packed.Files[0].SHA256 = strings.Repeat("0", 64)
+	if err := rewriteManifest(sporePath, packed); err != nil {
+		t.Fatalf("rewriteManifest failed: %v", err)
+	}
+	extractDir = filepath.Join(tempDir, "forged")
+	if _, _, err := Extract(sporePath, extractDir, nil); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Expected ErrInvalidSignature, got %v", err)
+	}
+	if _, err := os.Stat(extractDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no destination for a forged manifest, got %v", err)
+	}
+}
+
+// rewriteEntry copies a spore to dst with one entry's content replaced
+func rewriteEntry(src, dst, name string, data []byte) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, file := range zr.File {
+		if file.Name != name {
+			if err := zw.Copy(file); err != nil {
+				return err
+			}
+			continue
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func TestPackRejectsReservedFilePaths(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+
+	for _, p := range []string{"manifest.json", "test-binary", "../escape", "/abs", "a/../b"} {
+		f := File{Path: p, Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("x")), nil
+		}}
+		payload := Payload{Binary: strings.NewReader("bin"), Files: []File{f}}
+		if _, err := PackTo(io.Discard, payload, manifest, privKey); err == nil {
+			t.Errorf("PackTo should reject file path %q", p)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Reasons reported for a spore that fails verification
+const (
+	ReasonMalformed        = "malformed_archive"
+	ReasonHashMismatch     = "hash_mismatch"
+	ReasonInvalidSignature = "invalid_signature"
+	ReasonUntrustedKey     = "untrusted_key"
+	ReasonThreshold        = "threshold_not_met"
+	ReasonInvalidManifest  = "invalid_manifest"
+	ReasonPolicy           = "policy_violation"
+	ReasonPlatform         = "no_matching_platform"
+	ReasonExpired          = "expired"
+	ReasonNotYetValid      = "not_yet_valid"
+	ReasonRevoked          = "revoked"
+	ReasonRuntime          = "missing_runtime"
+	ReasonNotRecipient     = "not_recipient"
+	ReasonNotFound         = "not_found"
+	ReasonUnknown          = "verification_failed"
+)
+
+// Inspection describes what is inside a spore. It is read without checking
+// hashes or signatures; use Verify for that.
+type Inspection struct {
+	Digest    string       `json:"digest"` // sha256 of the spore file
+	Size      int64        `json:"size"`
+	CreatedAt time.Time    `json:"created_at"`
+	Manifest  *Manifest    `json:"manifest"`
+	Signers   []SignerInfo `json:"signers"`
+	Entries   []EntryInfo  `json:"entries"`
+}
+
+// SignerInfo identifies one key that signed a spore
+type SignerInfo struct {
+	KeyID      string `json:"key_id"`
+	PublicKey  string `json:"public_key"` // base64
+	Cosigner   bool   `json:"cosigner"`
+	EndorsedBy string `json:"endorsed_by,omitempty"` // key ID of the rotated-from key
+}
+
+// EntryInfo describes one archive entry
+type EntryInfo struct {
+	Name           string      `json:"name"`
+	Size           int64       `json:"size"`
+	CompressedSize int64       `json:"compressed_size"`
+	Mode           fs.FileMode `json:"mode"`
+}
+
+// Inspect reads a spore's manifest, signers and entry list
+func Inspect(sporePath string) (*Inspection, error) {
+	file, err := os.Open(sporePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spore file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat spore file: %w", err)
+	}
+
+	return InspectReader(file, info.Size())
+}
+
+// InspectReader reads a spore's manifest, signers and entry list from r
+func InspectReader(r io.ReaderAt, size int64) (*Inspection, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spore file: %w", err)
+	}
+
+	manifest, _, err := readArchive(zipReader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Digest the whole file, as the repo does
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, fmt.Errorf("failed to compute digest: %w", err)
+	}
+
+	insp := &Inspection{
+		Digest:    fmt.Sprintf("%x", hasher.Sum(nil)),
+		Size:      size,
+		CreatedAt: manifest.CreatedAt,
+		Manifest:  manifest,
+	}
+
+	for i, sig := range manifest.signatures() {
+		signer := SignerInfo{KeyID: sig.fingerprint(), PublicKey: sig.PublicKey, Cosigner: i > 0}
+		if e := manifest.Endorsement; e != nil && e.KeyID == signer.KeyID {
+			signer.EndorsedBy = e.PreviousKeyID
+		}
+		insp.Signers = append(insp.Signers, signer)
+	}
+
+	for _, file := range zipReader.File {
+		insp.Entries = append(insp.Entries, EntryInfo{
+			Name:           file.Name,
+			Size:           int64(file.UncompressedSize64),
+			CompressedSize: int64(file.CompressedSize64),
+			Mode:           file.Mode(),
+		})
+	}
+
+	return insp, nil
+}
+
+// fingerprint returns the key ID of the signature's public key, falling back
+// to the recorded key ID when the key cannot be decoded
+func (s Signature) fingerprint() string {
+	pub, err := base64.StdEncoding.DecodeString(s.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return s.KeyID
+	}
+	return Fingerprint(pub)
+}
+
+// Reason classifies a Verify, Extract, policy or revocation error as one of
+// the Reason codes
+func Reason(err error) string {
+	var (
+		archiveErr    *ArchiveError
+		untrustedErr  *UntrustedKeyError
+		thresholdErr  *ThresholdError
+		validationErr ValidationErrors
+		policyErr     *PolicyError
+		platformErr   *PlatformError
+		validityErr   *ValidityError
+		revokedErr    *RevokedError
+		runtimeErr    *RuntimeError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &untrustedErr):
+		return ReasonUntrustedKey
+	case errors.As(err, &thresholdErr):
+		return ReasonThreshold
+	case errors.As(err, &policyErr):
+		return ReasonPolicy
+	case errors.As(err, &platformErr):
+		return ReasonPlatform
+	case errors.As(err, &revokedErr):
+		return ReasonRevoked
+	case errors.As(err, &runtimeErr):
+		return ReasonRuntime
+	case errors.As(err, &validityErr):
+		if validityErr.Expired() {
+			return ReasonExpired
+		}
+		return ReasonNotYetValid
+	case errors.Is(err, ErrNotRecipient):
+		return ReasonNotRecipient
+	case errors.Is(err, ErrInvalidSignature):
+		return ReasonInvalidSignature
+	case errors.Is(err, ErrHashMismatch):
+		return ReasonHashMismatch
+	case errors.As(err, &archiveErr), errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum):
+		return ReasonMalformed
+	case errors.As(err, &validationErr):
+		return ReasonInvalidManifest
+	case errors.Is(err, fs.ErrNotExist):
+		return ReasonNotFound
+	default:
+		return ReasonUnknown
+	}
+}
@@ -0,0 +1,132 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	builderPub, builderPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	approverPub, approverPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, builderPriv)
+	if _, err := Cosign(sporePath, approverPriv); err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+
+	insp, err := Inspect(sporePath)
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+
+	data, err := os.ReadFile(sporePath)
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(data)); insp.Digest != want {
+		t.Errorf("Expected digest %s, got %s", want, insp.Digest)
+	}
+	if insp.Manifest.Name != "test-app" {
+		t.Errorf("Expected name test-app, got %s", insp.Manifest.Name)
+	}
+
+	if len(insp.Signers) != 2 {
+		t.Fatalf("Expected 2 signers, got %d", len(insp.Signers))
+	}
+	if insp.Signers[0].KeyID != Fingerprint(builderPub) || insp.Signers[0].Cosigner {
+		t.Errorf("Unexpected primary signer %+v", insp.Signers[0])
+	}
+	if insp.Signers[1].KeyID != Fingerprint(approverPub) || !insp.Signers[1].Cosigner {
+		t.Errorf("Unexpected cosigner %+v", insp.Signers[1])
+	}
+
+	names := make(map[string]int64)
+	for _, e := range insp.Entries {
+		names[e.Name] = e.Size
+	}
+	if names["binary"] != int64(len("test binary content")) {
+		t.Errorf("Expected binary entry of %d bytes, got %v", len("test binary content"), names)
+	}
+	if _, ok := names["manifest.json"]; !ok {
+		t.Error("Expected manifest.json entry")
+	}
+}
+
+func TestReason(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, priv)
+
+	untrusted := &Keyring{}
+	if err := untrusted.Add("other", otherPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	threshold := &Keyring{}
+	if err := threshold.Add("ci", pub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := threshold.SetThreshold("*", 2); err != nil {
+		t.Fatalf("SetThreshold failed: %v", err)
+	}
+
+	// A copy whose binary hash was rewritten after signing
+	tampered := filepath.Join(t.TempDir(), "tampered.spore")
+	data, err := os.ReadFile(sporePath)
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	if err := os.WriteFile(tampered, data, 0644); err != nil {
+		t.Fatalf("Failed to write spore: %v", err)
+	}
+	m, err := Verify(tampered, nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	m.Version = "v9.9.9"
+	if err := rewriteManifest(tampered, m); err != nil {
+		t.Fatalf("rewriteManifest failed: %v", err)
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.spore")
+	if err := os.WriteFile(garbage, []byte("not a zip"), 0644); err != nil {
+		t.Fatalf("Failed to write spore: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		kr   *Keyring
+		want string
+	}{
+		{"ok", sporePath, nil, ""},
+		{"untrusted", sporePath, untrusted, ReasonUntrustedKey},
+		{"threshold", sporePath, threshold, ReasonThreshold},
+		{"tampered", tampered, nil, ReasonInvalidSignature},
+		{"garbage", garbage, nil, ReasonMalformed},
+		{"missing", filepath.Join(t.TempDir(), "missing.spore"), nil, ReasonNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(tt.path, tt.kr)
+			if got := Reason(err); got != tt.want {
+				t.Errorf("Expected reason %q, got %q (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TrustedKey is a publisher key allowed to sign spores
+type TrustedKey struct {
+	Name      string   `json:"name"`
+	PublicKey string   `json:"public_key"`     // base64
+	Apps      []string `json:"apps,omitempty"` // empty means any app
+}
+
+// Keyring is the set of publisher keys an agent trusts, along with how many
+// of them must sign a spore before it is accepted
+type Keyring struct {
+	Keys       []TrustedKey   `json:"keys"`
+	Thresholds map[string]int `json:"thresholds,omitempty"` // app (or "*") -> required signers
+}
+
+// UntrustedKeyError is returned when a spore is signed by a key that is not
+// in the keyring, or is not trusted for the spore's app
+type UntrustedKeyError struct {
+	App         string
+	Fingerprint string
+}
+
+func (e *UntrustedKeyError) Error() string {
+	return fmt.Sprintf("spore for app %s signed by untrusted key %s", e.App, e.Fingerprint)
+}
+
+// Fingerprint returns a short, stable identifier for a public key
+func Fingerprint(pub ed25519.PublicKey) string {
+	hash := sha256.Sum256(pub)
+	return fmt.Sprintf("%x", hash[:8])
+}
+
+// LoadKeyring reads a keyring file. A missing file yields an empty keyring.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Keyring{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var kr Keyring
+	if err := json.Unmarshal(data, &kr); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	for _, key := range kr.Keys {
+		if _, err := key.publicKey(); err != nil {
+			return nil, fmt.Errorf("invalid key %s in keyring: %w", key.Name, err)
+		}
+	}
+
+	return &kr, nil
+}
+
+// Save writes the keyring to a file
+func (k *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keyring: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+
+	return nil
+}
+
+// Add trusts a public key under a name, optionally scoped to some apps
+func (k *Keyring) Add(name string, pub ed25519.PublicKey, apps []string) error {
+	if name == "" {
+		return fmt.Errorf("key name is required")
+	}
+
+	for _, key := range k.Keys {
+		if key.Name == name {
+			return fmt.Errorf("key %s already in keyring", name)
+		}
+	}
+
+	k.Keys = append(k.Keys, TrustedKey{
+		Name:      name,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		Apps:      apps,
+	})
+	return nil
+}
+
+// Remove drops a key from the keyring by name
+func (k *Keyring) Remove(name string) error {
+	for i, key := range k.Keys {
+		if key.Name == name {
+			k.Keys = append(k.Keys[:i], k.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("key %s not in keyring", name)
+}
+
+// SetThreshold requires n distinct trusted signatures on spores for app.
+// Use "*" as the app to set the default for apps without their own threshold.
+func (k *Keyring) SetThreshold(app string, n int) error {
+	if n < 1 {
+		return fmt.Errorf("threshold must be at least 1, got %d", n)
+	}
+	if k.Thresholds == nil {
+		k.Thresholds = make(map[string]int)
+	}
+	k.Thresholds[app] = n
+	return nil
+}
+
+// Threshold returns how many distinct trusted signatures app requires
+func (k *Keyring) Threshold(app string) int {
+	if n, ok := k.Thresholds[app]; ok {
+		return n
+	}
+	if n, ok := k.Thresholds["*"]; ok {
+		return n
+	}
+	return 1
+}
+
+// Lookup returns the trusted key matching pub that may sign spores for app
+func (k *Keyring) Lookup(pub ed25519.PublicKey, app string) (*TrustedKey, bool) {
+	for i := range k.Keys {
+		key := &k.Keys[i]
+		keyPub, err := key.publicKey()
+		if err != nil || !keyPub.Equal(pub) {
+			continue
+		}
+		if key.allows(app) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// publicKey decodes the stored public key
+func (t *TrustedKey) publicKey() (ed25519.PublicKey, error) {
+	return decodePublicKey(t.PublicKey)
+}
+
+// allows reports whether the key is scoped to app
+func (t *TrustedKey) allows(app string) bool {
+	if len(t.Apps) == 0 {
+		return true
+	}
+	for _, a := range t.Apps {
+		if a == app {
+			return true
+		}
+	}
+	return false
+}
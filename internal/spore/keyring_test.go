@@ -0,0 +1,151 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// packTestSpore packs a small spore for app "test-app" signed by priv
+func packTestSpore(t *testing.T, priv ed25519.PrivateKey) string {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	binaryPath := filepath.Join(tempDir, "test-binary")
+	if err := os.WriteFile(binaryPath, []byte("test binary content"), 0755); err != nil {
+		t.Fatalf("Failed to create test binary: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+
+	sporePath, _, err := Pack(binaryPath, manifest, priv, tempDir)
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+	return sporePath
+}
+
+func TestVerifyWithTrustedKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, priv)
+
+	kr := &Keyring{}
+	if err := kr.Add("ci", pub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if _, err := Verify(sporePath, kr); err != nil {
+		t.Fatalf("Verify with trusted key failed: %v", err)
+	}
+}
+
+func TestVerifyRejectsUntrustedKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, priv)
+
+	kr := &Keyring{}
+	if err := kr.Add("other", otherPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	_, err = Verify(sporePath, kr)
+	var untrusted *UntrustedKeyError
+	if !errors.As(err, &untrusted) {
+		t.Fatalf("Expected UntrustedKeyError, got %v", err)
+	}
+	if untrusted.App != "test-app" {
+		t.Errorf("Expected app 'test-app', got '%s'", untrusted.App)
+	}
+
+	// Extract must refuse as well
+	_, _, err = Extract(sporePath, t.TempDir(), kr)
+	if !errors.As(err, &untrusted) {
+		t.Fatalf("Expected Extract to fail with UntrustedKeyError, got %v", err)
+	}
+}
+
+func TestKeyringAppScope(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, priv)
+
+	kr := &Keyring{}
+	if err := kr.Add("billing-team", pub, []string{"billing"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	var untrusted *UntrustedKeyError
+	if _, err := Verify(sporePath, kr); !errors.As(err, &untrusted) {
+		t.Fatalf("Expected key scoped to billing to be rejected for test-app, got %v", err)
+	}
+
+	kr.Keys[0].Apps = append(kr.Keys[0].Apps, "test-app")
+	if _, err := Verify(sporePath, kr); err != nil {
+		t.Fatalf("Verify with scoped key failed: %v", err)
+	}
+}
+
+func TestKeyringSaveLoadRemove(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trust.json")
+
+	// Missing file is an empty keyring
+	kr, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring failed: %v", err)
+	}
+	if len(kr.Keys) != 0 {
+		t.Fatalf("Expected empty keyring, got %d keys", len(kr.Keys))
+	}
+
+	if err := kr.Add("ci", pub, []string{"billing"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := kr.Add("ci", pub, nil); err == nil {
+		t.Error("Adding a duplicate name should fail")
+	}
+	if err := kr.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring failed: %v", err)
+	}
+	if _, ok := loaded.Lookup(pub, "billing"); !ok {
+		t.Error("Loaded keyring should trust key for billing")
+	}
+
+	if err := loaded.Remove("ci"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, ok := loaded.Lookup(pub, "billing"); ok {
+		t.Error("Removed key should no longer be trusted")
+	}
+	if err := loaded.Remove("ci"); err == nil {
+		t.Error("Removing a missing key should fail")
+	}
+}
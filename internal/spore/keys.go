@@ -0,0 +1,540 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyFormat is an on-disk encoding for ed25519 keys
+type KeyFormat string
+
+const (
+	// KeyFormatRaw is the bare 64-byte private or 32-byte public key
+	KeyFormatRaw KeyFormat = "raw"
+	// KeyFormatPEM is PKCS#8 (private) or PKIX (public) in PEM armour
+	KeyFormatPEM KeyFormat = "pem"
+	// KeyFormatOpenSSH is the openssh-key-v1 private key and authorized_keys public key format
+	KeyFormatOpenSSH KeyFormat = "openssh"
+)
+
+const (
+	pemPrivateKey          = "PRIVATE KEY"
+	pemPublicKey           = "PUBLIC KEY"
+	pemOpenSSHPrivateKey   = "OPENSSH PRIVATE KEY"
+	pemEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+
+	sshKeyType     = "ssh-ed25519"
+	sshKeyMagic    = "openssh-key-v1\x00"
+	keyIterations  = 600000
+	keySaltSize    = 16
+	endorsementTag = "mycelium-mesh key endorsement v1\x00"
+)
+
+// ErrPassphraseRequired is returned when parsing an encrypted private key
+// without a passphrase
+var ErrPassphraseRequired = errors.New("private key is encrypted, passphrase required")
+
+var errWrongPassphrase = errors.New("failed to decrypt private key: wrong passphrase")
+
+// Endorsement declares that a new signing key is vouched for by the key it
+// replaces, so agents that trust the previous key accept the new one
+type Endorsement struct {
+	KeyID             string `json:"key_id"`              // endorsed key
+	PublicKey         string `json:"public_key"`          // endorsed key, base64
+	PreviousKeyID     string `json:"previous_key_id"`     // endorsing key
+	PreviousPublicKey string `json:"previous_public_key"` // endorsing key, base64
+	Signature         string `json:"signature"`           // by the previous key, base64
+}
+
+// MarshalPrivateKey encodes a private key. A non-empty passphrase encrypts it
+// as standard encrypted PKCS#8, which is only supported for the PEM format.
+func MarshalPrivateKey(priv ed25519.PrivateKey, format KeyFormat, passphrase []byte) ([]byte, error) {
+	if len(passphrase) > 0 && format != KeyFormatPEM {
+		return nil, fmt.Errorf("passphrase encryption requires the %s format", KeyFormatPEM)
+	}
+
+	switch format {
+	case KeyFormatRaw:
+		return bytes.Clone(priv), nil
+	case KeyFormatPEM:
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal private key: %w", err)
+		}
+		if len(passphrase) == 0 {
+			return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+		}
+		block, err := encryptKeyBlock(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(block), nil
+	case KeyFormatOpenSSH:
+		return pem.EncodeToMemory(&pem.Block{Type: pemOpenSSHPrivateKey, Bytes: marshalOpenSSHPrivateKey(priv)}), nil
+	default:
+		return nil, fmt.Errorf("unknown key format %q", format)
+	}
+}
+
+// ParsePrivateKey decodes a private key in any supported format
+func ParsePrivateKey(data, passphrase []byte) (ed25519.PrivateKey, error) {
+	if len(data) == ed25519.PrivateKeySize {
+		return ed25519.PrivateKey(bytes.Clone(data)), nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("unrecognised private key format")
+	}
+
+	switch block.Type {
+	case pemPrivateKey:
+		return parsePKCS8(block.Bytes)
+	case pemEncryptedPrivateKey:
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		der, err := decryptKeyBlock(block, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return parsePKCS8(der)
+	case pemOpenSSHPrivateKey:
+		return parseOpenSSHPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// MarshalPublicKey encodes a public key
+func MarshalPublicKey(pub ed25519.PublicKey, format KeyFormat) ([]byte, error) {
+	switch format {
+	case KeyFormatRaw:
+		return []byte(base64.StdEncoding.EncodeToString(pub) + "\n"), nil
+	case KeyFormatPEM:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal public key: %w", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+	case KeyFormatOpenSSH:
+		blob := append(sshString([]byte(sshKeyType)), sshString(pub)...)
+		return []byte(fmt.Sprintf("%s %s %s\n", sshKeyType, base64.StdEncoding.EncodeToString(blob), Fingerprint(pub))), nil
+	default:
+		return nil, fmt.Errorf("unknown key format %q", format)
+	}
+}
+
+// ParsePublicKey decodes an ed25519 public key from raw, base64, PEM or
+// OpenSSH bytes. An unencrypted private key is accepted as well, in which
+// case its public half is used.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	trimmed := strings.TrimSpace(string(data))
+
+	if strings.HasPrefix(trimmed, sshKeyType+" ") {
+		return parseOpenSSHPublicKey(trimmed)
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type == pemPublicKey {
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key: %w", err)
+			}
+			pub, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("public key is %T, not ed25519", key)
+			}
+			return pub, nil
+		}
+		priv, err := ParsePrivateKey(data, nil)
+		if err != nil {
+			return nil, err
+		}
+		return priv.Public().(ed25519.PublicKey), nil
+	}
+
+	if len(data) != ed25519.PublicKeySize && len(data) != ed25519.PrivateKeySize {
+		decoded, err := base64.StdEncoding.DecodeString(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode public key: %w", err)
+		}
+		data = decoded
+	}
+
+	switch len(data) {
+	case ed25519.PublicKeySize:
+		return ed25519.PublicKey(data), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data).Public().(ed25519.PublicKey), nil
+	default:
+		return nil, fmt.Errorf("invalid public key length %d", len(data))
+	}
+}
+
+// Endorse has prev vouch for next, for use while rotating signing keys
+func Endorse(prev ed25519.PrivateKey, next ed25519.PublicKey) *Endorsement {
+	prevPub := prev.Public().(ed25519.PublicKey)
+	return &Endorsement{
+		KeyID:             Fingerprint(next),
+		PublicKey:         base64.StdEncoding.EncodeToString(next),
+		PreviousKeyID:     Fingerprint(prevPub),
+		PreviousPublicKey: base64.StdEncoding.EncodeToString(prevPub),
+		Signature:         base64.StdEncoding.EncodeToString(ed25519.Sign(prev, endorsementMessage(next))),
+	}
+}
+
+// Verify checks the endorsement signature and returns the endorsed and
+// endorsing keys
+func (e *Endorsement) Verify() (next, prev ed25519.PublicKey, err error) {
+	next, err = decodePublicKey(e.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid endorsed key: %w", err)
+	}
+	prev, err = decodePublicKey(e.PreviousPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid endorsing key: %w", err)
+	}
+	if e.KeyID != Fingerprint(next) || e.PreviousKeyID != Fingerprint(prev) {
+		return nil, nil, fmt.Errorf("endorsement key IDs do not match its keys")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode endorsement signature: %w", err)
+	}
+	if !ed25519.Verify(prev, endorsementMessage(next), signature) {
+		return nil, nil, fmt.Errorf("%w: endorsement signature verification failed", ErrInvalidSignature)
+	}
+
+	return next, prev, nil
+}
+
+// endorsementMessage is what a previous key signs to endorse its successor
+func endorsementMessage(next ed25519.PublicKey) []byte {
+	return append([]byte(endorsementTag), next...)
+}
+
+// decodePublicKey decodes a base64 ed25519 public key
+func decodePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// parsePKCS8 extracts an ed25519 key from PKCS#8 DER
+func parsePKCS8(der []byte) (ed25519.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not ed25519", key)
+	}
+	return priv, nil
+}
+
+// pbes2Params, pbkdf2Params and encryptedPrivateKeyInfo are the ASN.1
+// structures of an encrypted PKCS#8 key (RFC 8018, RFC 5958)
+type pbes2Params struct {
+	KDF    pkix.AlgorithmIdentifier
+	Scheme pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"` // hmacWithSHA1 if absent
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// asn1Null is the NULL parameters of an algorithm identifier
+var asn1Null = asn1.RawValue{Tag: asn1.TagNull}
+
+// encryptKeyBlock encrypts PKCS#8 DER as an ENCRYPTED PRIVATE KEY block:
+// PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, as openssl writes it
+func encryptKeyBlock(der, passphrase []byte) (*pem.Block, error) {
+	salt := make([]byte, keySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, keyIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(bytes.Clone(der), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: keyIterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1Null},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key derivation parameters: %w", err)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal IV: %w", err)
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KDF:    pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		Scheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encryption parameters: %w", err)
+	}
+	encrypted, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted private key: %w", err)
+	}
+	return &pem.Block{Type: pemEncryptedPrivateKey, Bytes: encrypted}, nil
+}
+
+// decryptKeyBlock decrypts an ENCRYPTED PRIVATE KEY block to PKCS#8 DER. It
+// takes the PBES2 schemes openssl and other tools write: PBKDF2 with
+// HMAC-SHA1 or HMAC-SHA256, and AES-CBC.
+func decryptKeyBlock(block *pem.Block, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(block.Bytes, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid encrypted private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %s, expected PBES2", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+	if !params.KDF.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s, expected PBKDF2", params.KDF.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %w", err)
+	}
+	if kdf.Iterations < 1 {
+		return nil, fmt.Errorf("invalid key iterations %d", kdf.Iterations)
+	}
+	prf := sha1.New
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 function %s", kdf.PRF.Algorithm)
+	}
+
+	var keyLen int
+	switch alg := params.Scheme.Algorithm; {
+	case alg.Equal(oidAES128CBC):
+		keyLen = 16
+	case alg.Equal(oidAES192CBC):
+		keyLen = 24
+	case alg.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported key cipher %s", alg)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Scheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid key cipher IV")
+	}
+	if len(info.Data) == 0 || len(info.Data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted private key length %d", len(info.Data))
+	}
+
+	key, err := pbkdf2.Key(prf, string(passphrase), kdf.Salt, kdf.Iterations, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	der := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(aesBlock, iv).CryptBlocks(der, info.Data)
+
+	// A wrong passphrase shows up as bad padding or, rarely, as DER that
+	// does not parse
+	padding := int(der[len(der)-1])
+	if padding < 1 || padding > aes.BlockSize || !bytes.Equal(der[len(der)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errWrongPassphrase
+	}
+	der = der[:len(der)-padding]
+	if _, err := x509.ParsePKCS8PrivateKey(der); err != nil {
+		return nil, errWrongPassphrase
+	}
+	return der, nil
+}
+
+// marshalOpenSSHPrivateKey encodes an unencrypted openssh-key-v1 blob
+func marshalOpenSSHPrivateKey(priv ed25519.PrivateKey) []byte {
+	pub := priv.Public().(ed25519.PublicKey)
+	pubBlob := append(sshString([]byte(sshKeyType)), sshString(pub)...)
+
+	var check [4]byte
+	rand.Read(check[:])
+
+	var private []byte
+	private = append(private, check[:]...)
+	private = append(private, check[:]...)
+	private = append(private, sshString([]byte(sshKeyType))...)
+	private = append(private, sshString(pub)...)
+	private = append(private, sshString(priv)...)
+	private = append(private, sshString([]byte(Fingerprint(pub)))...)
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	var out []byte
+	out = append(out, sshKeyMagic...)
+	out = append(out, sshString([]byte("none"))...)
+	out = append(out, sshString([]byte("none"))...)
+	out = append(out, sshString(nil)...)
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = append(out, sshString(pubBlob)...)
+	out = append(out, sshString(private)...)
+	return out
+}
+
+// parseOpenSSHPrivateKey decodes an unencrypted openssh-key-v1 blob
+func parseOpenSSHPrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	if !bytes.HasPrefix(data, []byte(sshKeyMagic)) {
+		return nil, fmt.Errorf("invalid OpenSSH private key")
+	}
+	r := &sshReader{buf: data[len(sshKeyMagic):]}
+
+	cipherName, kdfName := string(r.string()), string(r.string())
+	r.string() // kdf options
+	if r.err == nil && (cipherName != "none" || kdfName != "none") {
+		return nil, fmt.Errorf("encrypted OpenSSH keys are not supported, convert the key with ssh-keygen -p -N '' or use the %s format for passphrase protection", KeyFormatPEM)
+	}
+	if n := r.uint32(); r.err == nil && n != 1 {
+		return nil, fmt.Errorf("OpenSSH key file holds %d keys, expected 1", n)
+	}
+	r.string() // public key blob
+
+	private := &sshReader{buf: r.string()}
+	check1, check2 := private.uint32(), private.uint32()
+	keyType := string(private.string())
+	pub := private.string()
+	priv := private.string()
+	if r.err != nil || private.err != nil {
+		return nil, fmt.Errorf("truncated OpenSSH private key")
+	}
+	if check1 != check2 {
+		return nil, fmt.Errorf("corrupt OpenSSH private key")
+	}
+	if keyType != sshKeyType {
+		return nil, fmt.Errorf("OpenSSH key type %s, expected %s", keyType, sshKeyType)
+	}
+	if len(priv) != ed25519.PrivateKeySize || !bytes.Equal(priv[32:], pub) {
+		return nil, fmt.Errorf("invalid OpenSSH ed25519 key")
+	}
+
+	return ed25519.PrivateKey(bytes.Clone(priv)), nil
+}
+
+// parseOpenSSHPublicKey decodes an authorized_keys style line
+func parseOpenSSHPublicKey(line string) (ed25519.PublicKey, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid OpenSSH public key")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode OpenSSH public key: %w", err)
+	}
+
+	r := &sshReader{buf: blob}
+	keyType := string(r.string())
+	pub := r.string()
+	if r.err != nil || keyType != sshKeyType || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid OpenSSH ed25519 public key")
+	}
+	return ed25519.PublicKey(bytes.Clone(pub)), nil
+}
+
+// sshString encodes b as an SSH wire-format string
+func sshString(b []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+// sshReader decodes SSH wire-format values, recording the first error
+type sshReader struct {
+	buf []byte
+	err error
+}
+
+// uint32 reads a big-endian uint32
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = fmt.Errorf("truncated")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+// string reads a length-prefixed byte string
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if r.err != nil || uint64(n) > uint64(len(r.buf)) {
+		r.err = fmt.Errorf("truncated")
+		return nil
+	}
+	v := r.buf[:n]
+	r.buf = r.buf[n:]
+	return v
+}
@@ -0,0 +1,162 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+)
+
+// EnvVar is the variable holding the overlay environment name, available to
+// substitution in every composed manifest
+const EnvVar = "MESH_ENV"
+
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Overlay is a JSON merge patch (RFC 7386) applied on top of a base manifest,
+// such as the prod settings of an app
+type Overlay struct {
+	Name string // file name, recorded in the manifest
+	Data []byte
+}
+
+// OverlayRecord is stamped into the signed manifest to show which base and
+// overlays produced it
+type OverlayRecord struct {
+	Env      string          `json:"env,omitempty"`
+	Base     string          `json:"base_sha256"`
+	Overlays []OverlaySource `json:"overlays,omitempty"`
+	Vars     []string        `json:"vars,omitempty"` // names only; values may be secret
+}
+
+// OverlaySource identifies one overlay applied to a manifest
+type OverlaySource struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// ComposeManifest applies overlays to a base manifest in order, then
+// replaces ${NAME} in every string value with vars[NAME]; EnvVar is set to
+// env. The result is strictly decoded and migrated like ParseManifest, and
+// records its sources in Overlay.
+func ComposeManifest(base []byte, env string, overlays []Overlay, vars map[string]string) (*Manifest, error) {
+	record := &OverlayRecord{Env: env, Base: fmt.Sprintf("%x", sha256.Sum256(base))}
+
+	doc, err := decodeJSON("base manifest", base)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overlays {
+		patch, err := decodeJSON("overlay "+o.Name, o.Data)
+		if err != nil {
+			return nil, err
+		}
+		doc = mergePatch(doc, patch)
+		record.Overlays = append(record.Overlays, OverlaySource{Name: o.Name, SHA256: fmt.Sprintf("%x", sha256.Sum256(o.Data))})
+	}
+
+	// Substitute variables inside string values only, so they cannot change
+	// the manifest's structure
+	vars = maps.Clone(vars)
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	vars[EnvVar] = env
+	used := make(map[string]bool)
+	doc, err = substitute(doc, vars, used)
+	if err != nil {
+		return nil, err
+	}
+	record.Vars = slices.Sorted(maps.Keys(used))
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal composed manifest: %w", err)
+	}
+	m, err := ParseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	m.Overlay = record
+	return m, nil
+}
+
+// decodeJSON decodes a JSON document, keeping numbers exact
+func decodeJSON(what string, data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", what, err)
+	}
+	return v, nil
+}
+
+// mergePatch applies a JSON merge patch to target: objects merge
+// recursively, null deletes a member and anything else replaces it
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// substitute replaces ${NAME} references in every string of v, recording the
+// variables used. An undefined variable is an error.
+func substitute(v any, vars map[string]string, used map[string]bool) (any, error) {
+	switch v := v.(type) {
+	case string:
+		var missing string
+		out := variablePattern.ReplaceAllStringFunc(v, func(ref string) string {
+			name := variablePattern.FindStringSubmatch(ref)[1]
+			value, ok := vars[name]
+			if !ok {
+				missing = name
+				return ref
+			}
+			used[name] = true
+			return value
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("undefined variable ${%s} in manifest", missing)
+		}
+		return out, nil
+	case map[string]any:
+		for key, value := range v {
+			sub, err := substitute(value, vars, used)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = sub
+		}
+		return v, nil
+	case []any:
+		for i, value := range v {
+			sub, err := substitute(value, vars, used)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = sub
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
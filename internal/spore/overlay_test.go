@@ -0,0 +1,75 @@
+package spore
+
+import (
+	"strings"
+	"testing"
+)
+
+const baseManifest = `{
+  "apiVersion": "mesh.mycelium/v1",
+  "name": "billing",
+  "version": "v0.1.0",
+  "command": "billing",
+  "env": {"GREETING": "hello", "DEBUG": "1"},
+  "nutrients": {"cpu_milli": 200, "memory_mb": 128},
+  "slo": {"p99_budget_ms": 300}
+}`
+
+func TestComposeManifest(t *testing.T) {
+	prod := Overlay{Name: "billing.prod.json", Data: []byte(`{
+  "env": {"DEBUG": null, "GREETING": "hello from ${MESH_ENV} in ${REGION}"},
+  "nutrients": {"cpu_milli": 500},
+  "slo": {"p99_budget_ms": 150}
+}`)}
+	pinned := Overlay{Name: "pin.json", Data: []byte(`{"version": "v0.1.1"}`)}
+
+	m, err := ComposeManifest([]byte(baseManifest), "prod", []Overlay{prod, pinned}, map[string]string{"REGION": "eu-west-1"})
+	if err != nil {
+		t.Fatalf("ComposeManifest failed: %v", err)
+	}
+
+	if _, ok := m.Env["DEBUG"]; ok {
+		t.Error("Expected null in overlay to delete DEBUG")
+	}
+	if got := m.Env["GREETING"]; got != "hello from prod in eu-west-1" {
+		t.Errorf("Unexpected substituted GREETING %q", got)
+	}
+	if m.Nutrients.CPUMilli != 500 || m.Nutrients.MemoryMB != 128 {
+		t.Errorf("Expected nutrients merged to 500/128, got %+v", m.Nutrients)
+	}
+	if m.Version != "v0.1.1" {
+		t.Errorf("Expected later overlay to win, got version %s", m.Version)
+	}
+
+	o := m.Overlay
+	if o == nil || o.Env != "prod" || len(o.Overlays) != 2 || o.Overlays[0].Name != "billing.prod.json" {
+		t.Fatalf("Unexpected overlay record %+v", o)
+	}
+	if len(o.Vars) != 2 || o.Vars[0] != EnvVar || o.Vars[1] != "REGION" {
+		t.Errorf("Expected vars [%s REGION], got %v", EnvVar, o.Vars)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Composed manifest should be valid: %v", err)
+	}
+}
+
+func TestComposeManifestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		overlay string
+		want    string
+	}{
+		{"undefined variable", `{"env": {"GREETING": "${MISSING}"}}`, "undefined variable ${MISSING}"},
+		{"unknown field", `{"nutrient": {"cpu_milli": 1}}`, "unknown field"},
+		{"malformed", `{"env": `, "failed to parse overlay bad.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ComposeManifest([]byte(baseManifest), "", []Overlay{{Name: "bad.json", Data: []byte(tt.overlay)}}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// binariesPrefix is the archive directory holding per-platform binaries
+const binariesPrefix = "binaries/"
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9]+$`)
+
+// PlatformBinary is a binary built for one GOOS/GOARCH, such as "linux/arm64"
+type PlatformBinary struct {
+	Platform string
+	Binary   io.Reader
+}
+
+// BinaryEntry records a per-platform binary in the signed manifest
+type BinaryEntry struct {
+	Platform string `json:"platform"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// PlatformError is returned when a spore carries no binary for a node's
+// platform
+type PlatformError struct {
+	App       string
+	Platform  string
+	Available []string
+}
+
+func (e *PlatformError) Error() string {
+	return fmt.Sprintf("spore for app %s has no binary for %s (available: %s)", e.App, e.Platform, strings.Join(e.Available, ", "))
+}
+
+// HostPlatform returns the GOOS/GOARCH of the running process
+func HostPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// binaryEntryName returns the archive entry name for a platform's binary
+func binaryEntryName(platform string) string {
+	return binariesPrefix + strings.ReplaceAll(platform, "/", "-")
+}
+
+// checkBinaries rejects invalid or duplicate platforms and sizes
+func checkBinaries(entries []BinaryEntry) error {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !platformPattern.MatchString(e.Platform) {
+			return fmt.Errorf("invalid platform %q, expected os/arch", e.Platform)
+		}
+		if seen[e.Platform] {
+			return fmt.Errorf("duplicate binary for platform %s", e.Platform)
+		}
+		seen[e.Platform] = true
+		if e.Size < 0 || e.Size > maxEntrySize {
+			return fmt.Errorf("binary for %s has invalid size %d", e.Platform, e.Size)
+		}
+	}
+	return nil
+}
+
+// writeBinary adds a platform's binary to a spore being written, hashing it
+// as it streams, and returns its entry
+func writeBinary(zw *zip.Writer, b PlatformBinary) (BinaryEntry, error) {
+	if !platformPattern.MatchString(b.Platform) {
+		return BinaryEntry{}, fmt.Errorf("invalid platform %q, expected os/arch", b.Platform)
+	}
+
+	w, err := zw.Create(binaryEntryName(b.Platform))
+	if err != nil {
+		return BinaryEntry{}, fmt.Errorf("failed to create %s binary in zip: %w", b.Platform, err)
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hasher), b.Binary)
+	if err != nil {
+		return BinaryEntry{}, fmt.Errorf("failed to write %s binary: %w", b.Platform, err)
+	}
+
+	return BinaryEntry{Platform: b.Platform, SHA256: fmt.Sprintf("%x", hasher.Sum(nil)), Size: n}, nil
+}
+
+// binaryFor returns the archive entry name, size limit and expected hash of
+// the binary to run on platform. Single-binary spores run anywhere; their
+// hash is checked against BinarySHA256 by verifyManifest, so want is empty.
+func (m *Manifest) binaryFor(platform string) (name string, limit int64, want string, err error) {
+	if len(m.Binaries) == 0 {
+		return "binary", m.binaryLimit(), "", nil
+	}
+
+	var available []string
+	for _, b := range m.Binaries {
+		if b.Platform == platform {
+			return binaryEntryName(b.Platform), b.Size, b.SHA256, nil
+		}
+		available = append(available, b.Platform)
+	}
+	return "", 0, "", &PlatformError{App: m.Name, Platform: platform, Available: available}
+}
+
+// binaryDigest returns the hash of the binary built for platform, or of the
+// single binary when platform is empty
+func (m *Manifest) binaryDigest(platform string) (string, error) {
+	if platform == "" {
+		if len(m.Binaries) > 0 {
+			return "", fmt.Errorf("spore has per-platform binaries, a platform is required")
+		}
+		// Attestations are about the binary itself, not its ciphertext
+		if m.Encryption != nil {
+			return m.Encryption.PlaintextSHA256, nil
+		}
+		return m.BinarySHA256, nil
+	}
+	for _, b := range m.Binaries {
+		if b.Platform == platform {
+			return b.SHA256, nil
+		}
+	}
+	return "", fmt.Errorf("spore has no binary for %s", platform)
+}
+
+// Platforms lists the platforms a spore has binaries for. A single-binary
+// spore returns nil.
+func (m *Manifest) Platforms() []string {
+	var platforms []string
+	for _, b := range m.Binaries {
+		platforms = append(platforms, b.Platform)
+	}
+	return platforms
+}
@@ -0,0 +1,161 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// otherPlatform is a platform no test host runs on
+const otherPlatform = "plan9/mips"
+
+// packPlatforms packs a spore with one binary per platform, each containing
+// its platform name
+func packPlatforms(t *testing.T, platforms ...string) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	var binaries []PlatformBinary
+	for _, p := range platforms {
+		binaries = append(binaries, PlatformBinary{Platform: p, Binary: strings.NewReader("binary for " + p)})
+	}
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	sporePath, _, err := PackPayload(Payload{Binaries: binaries}, manifest, priv, t.TempDir())
+	if err != nil {
+		t.Fatalf("PackPayload failed: %v", err)
+	}
+	return sporePath
+}
+
+func TestMultiPlatformSpore(t *testing.T) {
+	sporePath := packPlatforms(t, HostPlatform(), otherPlatform)
+
+	manifest, err := Verify(sporePath, nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if got := manifest.Platforms(); len(got) != 2 || got[0] != HostPlatform() || got[1] != otherPlatform {
+		t.Errorf("Expected platforms [%s %s], got %v", HostPlatform(), otherPlatform, got)
+	}
+	if manifest.BinarySHA256 != "" {
+		t.Errorf("Expected no single binary hash, got %s", manifest.BinarySHA256)
+	}
+
+	// Extract picks the host's binary
+	_, binaryPath, err := Extract(sporePath, filepath.Join(t.TempDir(), "extract"), nil)
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	content, err := os.ReadFile(binaryPath)
+	if err != nil {
+		t.Fatalf("Failed to read binary: %v", err)
+	}
+	if string(content) != "binary for "+HostPlatform() {
+		t.Errorf("Extracted wrong binary: %q", content)
+	}
+
+	// Cosignatures cover per-platform spores too
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := Cosign(sporePath, priv); err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+	if _, err := Verify(sporePath, nil); err != nil {
+		t.Fatalf("Verify after cosign failed: %v", err)
+	}
+}
+
+func TestExtractRefusesMissingPlatform(t *testing.T) {
+	sporePath := packPlatforms(t, otherPlatform)
+
+	destDir := filepath.Join(t.TempDir(), "extract")
+	_, _, err := Extract(sporePath, destDir, nil)
+	var platformErr *PlatformError
+	if !errors.As(err, &platformErr) {
+		t.Fatalf("Expected PlatformError, got %v", err)
+	}
+	if platformErr.Platform != HostPlatform() || len(platformErr.Available) != 1 || platformErr.Available[0] != otherPlatform {
+		t.Errorf("Unexpected error details: %+v", platformErr)
+	}
+	if Reason(err) != ReasonPlatform {
+		t.Errorf("Expected reason %s, got %s", ReasonPlatform, Reason(err))
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Error("Extract should not create anything for a missing platform")
+	}
+}
+
+func TestPackPlatformErrors(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+
+	tests := []struct {
+		name    string
+		payload Payload
+	}{
+		{"both", Payload{
+			Binary:   strings.NewReader("bin"),
+			Binaries: []PlatformBinary{{Platform: "linux/amd64", Binary: strings.NewReader("bin")}},
+		}},
+		{"neither", Payload{}},
+		{"bad platform", Payload{Binaries: []PlatformBinary{{Platform: "linux", Binary: strings.NewReader("bin")}}}},
+		{"duplicate", Payload{Binaries: []PlatformBinary{
+			{Platform: "linux/amd64", Binary: strings.NewReader("a")},
+			{Platform: "linux/amd64", Binary: strings.NewReader("b")},
+		}}},
+		{"attestation without platform", Payload{
+			Binaries:     []PlatformBinary{{Platform: "linux/amd64", Binary: strings.NewReader("bin")}},
+			Attestations: []Attestation{{Name: "provenance", Statement: Statement{PredicateType: PredicateGoProvenance}}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := PackTo(&buf, tt.payload, manifest, priv); err == nil {
+				t.Error("PackTo should fail")
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedPlatformBinary(t *testing.T) {
+	sporePath := packPlatforms(t, HostPlatform(), otherPlatform)
+	manifest, err := Verify(sporePath, nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// Swap in a different binary for the other platform
+	data := buildArchive(t, []testEntry{
+		{name: binaryEntryName(HostPlatform()), data: []byte("binary for " + HostPlatform())},
+		{name: binaryEntryName(otherPlatform), data: []byte("binary for evil")},
+		{name: "manifest.json", data: manifestJSON(t, *manifest)},
+	})
+	if _, err := VerifyReader(bytes.NewReader(data), int64(len(data)), nil); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Expected hash mismatch, got %v", err)
+	}
+}
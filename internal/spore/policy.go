@@ -0,0 +1,54 @@
+package spore
+
+import "fmt"
+
+// Policy restricts which verified spores may run, based on their
+// attestations. The zero Policy allows everything.
+type Policy struct {
+	RequireProvenance bool `json:"require_provenance"`
+	RequireCleanTree  bool `json:"require_clean_tree"` // built from an unmodified VCS revision
+}
+
+// PolicyError is returned when a spore violates a policy rule
+type PolicyError struct {
+	App    string
+	Rule   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("spore for app %s violates policy %s: %s", e.App, e.Rule, e.Reason)
+}
+
+// Check applies the policy to a verified manifest and the attestations about
+// the binary that will run on this host
+func (p *Policy) Check(m *Manifest, atts []Attestation) error {
+	if !p.RequireProvenance && !p.RequireCleanTree {
+		return nil
+	}
+
+	att := FindAttestation(PlatformAttestations(atts, HostPlatform()), PredicateGoProvenance)
+	if att == nil {
+		rule := "require_provenance"
+		if !p.RequireProvenance {
+			rule = "require_clean_tree"
+		}
+		return &PolicyError{App: m.Name, Rule: rule, Reason: "no provenance attestation"}
+	}
+
+	var prov GoProvenance
+	if err := att.Decode(&prov); err != nil {
+		return &PolicyError{App: m.Name, Rule: "require_provenance", Reason: err.Error()}
+	}
+
+	if p.RequireCleanTree {
+		switch {
+		case prov.VCSRevision == "":
+			return &PolicyError{App: m.Name, Rule: "require_clean_tree", Reason: "binary was not built from version control"}
+		case prov.VCSModified:
+			return &PolicyError{App: m.Name, Rule: "require_clean_tree", Reason: fmt.Sprintf("binary was built from a modified tree at %s", prov.VCSRevision)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package spore
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// SourceDateEpoch returns the build time set by $SOURCE_DATE_EPOCH, in
+// seconds since the Unix epoch, or the zero time when it is unset. Pass it
+// as Payload.CreatedAt so identical inputs pack into identical spores.
+func SourceDateEpoch() (time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || secs < 0 {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", value)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// sorted returns a copy of the payload with its binaries, files and
+// attestations in canonical order, so the archive layout does not depend on
+// the order they were collected in
+func (p Payload) sorted() Payload {
+	p.Binaries = slices.SortedStableFunc(slices.Values(p.Binaries), func(a, b PlatformBinary) int {
+		return cmp.Compare(a.Platform, b.Platform)
+	})
+	p.Files = slices.SortedStableFunc(slices.Values(p.Files), func(a, b File) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+	p.Attestations = slices.SortedStableFunc(slices.Values(p.Attestations), func(a, b Attestation) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return p
+}
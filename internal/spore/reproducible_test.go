@@ -0,0 +1,79 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReproduciblePack(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Env:       map[string]string{"B": "2", "A": "1"},
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	file := func(path, content string) File {
+		return File{Path: path, Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		}}
+	}
+	createdAt := time.Unix(1700000000, 0)
+
+	// Pack the same inputs twice, collecting the files in a different order
+	pack := func(files ...File) []byte {
+		var buf bytes.Buffer
+		payload := Payload{Binary: strings.NewReader("test binary content"), Files: files, CreatedAt: createdAt}
+		if _, err := PackTo(&buf, payload, manifest, priv); err != nil {
+			t.Fatalf("PackTo failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	first := pack(file("a.txt", "a"), file("static/b.css", "b"))
+	second := pack(file("static/b.css", "b"), file("a.txt", "a"))
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("Packing identical inputs produced different spores")
+	}
+
+	m, err := VerifyReader(bytes.NewReader(first), int64(len(first)), nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !m.CreatedAt.Equal(createdAt) {
+		t.Errorf("Expected created_at %v, got %v", createdAt, m.CreatedAt)
+	}
+	if m.Files[0].Path != "a.txt" {
+		t.Errorf("Expected files in canonical order, got %v", m.Files)
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	if got, err := SourceDateEpoch(); err != nil || !got.IsZero() {
+		t.Errorf("Expected zero time when unset, got %v, %v", got, err)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	got, err := SourceDateEpoch()
+	if err != nil {
+		t.Fatalf("SourceDateEpoch failed: %v", err)
+	}
+	if !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Expected 1700000000, got %v", got.Unix())
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, err := SourceDateEpoch(); err == nil {
+		t.Error("SourceDateEpoch should reject a non-numeric value")
+	}
+}
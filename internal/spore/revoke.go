@@ -0,0 +1,231 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RevocationList blocks spores mesh-wide, either by spore digest or by the
+// key that signed them. It is signed by a publisher key that is trusted for
+// every app.
+type RevocationList struct {
+	IssuedAt time.Time    `json:"issued_at"`
+	Digests  []Revocation `json:"digests,omitempty"`
+	Keys     []Revocation `json:"keys,omitempty"` // by key ID
+	Signer   *Signature   `json:"signer,omitempty"`
+}
+
+// Revocation records one revoked digest or key ID
+type Revocation struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// RevokedError is returned for a spore whose digest or signer is revoked
+type RevokedError struct {
+	App    string
+	Digest string
+	KeyID  string // set when the signer rather than the digest is revoked
+	Reason string
+}
+
+func (e *RevokedError) Error() string {
+	msg := fmt.Sprintf("spore %s for app %s is revoked", e.Digest, e.App)
+	if e.KeyID != "" {
+		msg = fmt.Sprintf("spore %s for app %s is signed by revoked key %s", e.Digest, e.App, e.KeyID)
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// LoadRevocationList reads a revocation list file. A missing file yields an
+// empty list.
+func LoadRevocationList(path string) (*RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &RevocationList{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+
+	var rl RevocationList
+	if err := json.Unmarshal(data, &rl); err != nil {
+		return nil, fmt.Errorf("failed to parse revocation list: %w", err)
+	}
+	return &rl, nil
+}
+
+// Save writes the revocation list to a file, replacing it atomically so
+// readers never see a partial list
+func (rl *RevocationList) Save(path string) error {
+	data, err := json.MarshalIndent(rl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal revocation list: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create revocation list directory: %w", err)
+	}
+
+	// Each writer stages its own temp file, so concurrent writers never
+	// collide
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for revocation list: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write revocation list: %w", err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set revocation list permissions: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace revocation list: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeDigest revokes a spore by its repo digest
+func (rl *RevocationList) RevokeDigest(digest, reason string) {
+	rl.Digests = revoke(rl.Digests, digest, reason)
+}
+
+// RevokeKey revokes every spore signed by a key, by key ID
+func (rl *RevocationList) RevokeKey(keyID, reason string) {
+	rl.Keys = revoke(rl.Keys, keyID, reason)
+}
+
+// revoke adds or updates the revocation of id
+func revoke(list []Revocation, id, reason string) []Revocation {
+	r := Revocation{ID: id, Reason: reason, RevokedAt: time.Now().UTC()}
+	for i := range list {
+		if list[i].ID == id {
+			list[i] = r
+			return list
+		}
+	}
+	return append(list, r)
+}
+
+// Sign stamps the issue time and signs the list with priv
+func (rl *RevocationList) Sign(priv ed25519.PrivateKey) error {
+	rl.IssuedAt = time.Now().UTC()
+
+	hash, err := rl.signingHash()
+	if err != nil {
+		return err
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	rl.Signer = &Signature{
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		KeyID:     Fingerprint(pub),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, hash[:])),
+	}
+	return nil
+}
+
+// Verify checks the list's signature and, when kr is non-nil, that it was
+// signed by a key trusted for every app. An empty, unsigned list is valid.
+func (rl *RevocationList) Verify(kr *Keyring) error {
+	if rl.Signer == nil {
+		if len(rl.Digests) == 0 && len(rl.Keys) == 0 {
+			return nil
+		}
+		return fmt.Errorf("revocation list is not signed")
+	}
+
+	hash, err := rl.signingHash()
+	if err != nil {
+		return err
+	}
+	pub, err := rl.Signer.verify(hash[:])
+	if err != nil {
+		return fmt.Errorf("revocation list: %w", err)
+	}
+
+	// Keys scoped to some apps may not revoke spores mesh-wide
+	if kr != nil {
+		if _, ok := kr.Lookup(pub, ""); !ok {
+			return fmt.Errorf("revocation list signed by key %s, which is not trusted for every app", Fingerprint(pub))
+		}
+	}
+
+	return nil
+}
+
+// CheckRollback fails if rl would roll back prev, a list accepted earlier:
+// once a signed list has been seen, later lists must be signed and no older.
+// Otherwise whoever serves the list could erase revocations by serving an
+// empty or stale one.
+func (rl *RevocationList) CheckRollback(prev *RevocationList) error {
+	if prev == nil || prev.Signer == nil {
+		return nil
+	}
+	if rl.Signer == nil {
+		return fmt.Errorf("revocation list is not signed, but a signed list issued at %s was seen", prev.IssuedAt.Format(time.RFC3339))
+	}
+	if rl.IssuedAt.Before(prev.IssuedAt) {
+		return fmt.Errorf("revocation list issued at %s is older than the list issued at %s",
+			rl.IssuedAt.Format(time.RFC3339), prev.IssuedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Check returns a RevokedError if the spore's digest, any key that signed
+// its manifest, or the key that endorsed its signing key is revoked. Revoking
+// a key also revokes the keys it endorsed.
+func (rl *RevocationList) Check(digest string, m *Manifest) error {
+	for _, r := range rl.Digests {
+		if r.ID == digest {
+			return &RevokedError{App: m.Name, Digest: digest, Reason: r.Reason}
+		}
+	}
+
+	var keyIDs []string
+	for _, sig := range m.signatures() {
+		keyIDs = append(keyIDs, sig.fingerprint())
+	}
+	if m.Endorsement != nil {
+		keyIDs = append(keyIDs, m.Endorsement.PreviousKeyID)
+	}
+	for _, keyID := range keyIDs {
+		for _, r := range rl.Keys {
+			if r.ID == keyID {
+				return &RevokedError{App: m.Name, Digest: digest, KeyID: keyID, Reason: r.Reason}
+			}
+		}
+	}
+
+	return nil
+}
+
+// signingHash computes the sha256 of the list without its signature
+func (rl *RevocationList) signingHash() ([32]byte, error) {
+	unsigned := *rl
+	unsigned.Signer = nil
+
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to marshal revocation list: %w", err)
+	}
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,177 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRevocationListSignAndVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	rl := &RevocationList{}
+	if err := rl.Verify(nil); err != nil {
+		t.Errorf("Empty unsigned list should verify: %v", err)
+	}
+
+	rl.RevokeDigest("abc123", "compromised build")
+	if err := rl.Verify(nil); err == nil {
+		t.Error("Unsigned list with revocations should not verify")
+	}
+	if err := rl.Sign(priv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// Round trip through a file
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	if err := rl.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadRevocationList(path)
+	if err != nil {
+		t.Fatalf("LoadRevocationList failed: %v", err)
+	}
+
+	trusted := &Keyring{}
+	if err := trusted.Add("ci", pub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := loaded.Verify(trusted); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	// A key trusted only for some apps may not revoke mesh-wide
+	scoped := &Keyring{}
+	if err := scoped.Add("ci", pub, []string{"billing"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := loaded.Verify(scoped); err == nil {
+		t.Error("Verify should reject a list signed by an app-scoped key")
+	}
+
+	// Dropping a revocation breaks the signature
+	loaded.Digests = nil
+	loaded.RevokeKey("0000000000000000", "")
+	if err := loaded.Verify(nil); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a tampered list, got %v", err)
+	}
+}
+
+func TestRevocationListCheck(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	m, err := Verify(packTestSpore(t, priv), nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	rl := &RevocationList{}
+	if err := rl.Check("digest", m); err != nil {
+		t.Errorf("Nothing revoked, got %v", err)
+	}
+
+	rl.RevokeDigest("digest", "bad build")
+	var revokedErr *RevokedError
+	if err := rl.Check("digest", m); !errors.As(err, &revokedErr) || revokedErr.KeyID != "" {
+		t.Errorf("Expected digest RevokedError, got %v", err)
+	}
+	if err := rl.Check("other-digest", m); err != nil {
+		t.Errorf("Other digest should not be revoked, got %v", err)
+	}
+
+	rl.RevokeKey(m.KeyID, "key leaked")
+	err = rl.Check("other-digest", m)
+	if !errors.As(err, &revokedErr) || revokedErr.KeyID != m.KeyID {
+		t.Errorf("Expected signer RevokedError, got %v", err)
+	}
+	if got := Reason(err); got != ReasonRevoked {
+		t.Errorf("Expected reason %q, got %q", ReasonRevoked, got)
+	}
+}
+
+func TestRevocationListCheckEndorser(t *testing.T) {
+	oldPub, oldPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	newPub, newPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:        "test-app",
+		Version:     "v1.0.0",
+		Command:     "test-binary",
+		Nutrients:   Nutrients{CPUMilli: 100, MemoryMB: 64},
+		Endorsement: Endorse(oldPriv, newPub),
+	}
+	binaryPath := filepath.Join(t.TempDir(), "test-binary")
+	if err := os.WriteFile(binaryPath, []byte("test binary content"), 0755); err != nil {
+		t.Fatalf("Failed to create test binary: %v", err)
+	}
+	sporePath, _, err := Pack(binaryPath, manifest, newPriv, t.TempDir())
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+	m, err := Verify(sporePath, nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// A leaked key revokes the keys it endorsed, too
+	rl := &RevocationList{}
+	rl.RevokeKey(Fingerprint(oldPub), "key leaked")
+	var revokedErr *RevokedError
+	if err := rl.Check("digest", m); !errors.As(err, &revokedErr) || revokedErr.KeyID != Fingerprint(oldPub) {
+		t.Errorf("Expected endorser RevokedError, got %v", err)
+	}
+}
+
+func TestRevocationListCheckRollback(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	older := &RevocationList{}
+	older.RevokeDigest("digest", "bad build")
+	if err := older.Sign(priv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	newer := &RevocationList{}
+	newer.RevokeDigest("digest", "bad build")
+	newer.RevokeDigest("other-digest", "bad build")
+	if err := newer.Sign(priv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		rl      *RevocationList
+		prev    *RevocationList
+		wantErr bool
+	}{
+		{"first list", &RevocationList{}, nil, false},
+		{"unsigned after unsigned", &RevocationList{}, &RevocationList{}, false},
+		{"signed after unsigned", older, &RevocationList{}, false},
+		{"newer", newer, older, false},
+		{"same", newer, newer, false},
+		{"older", older, newer, true},
+		{"unsigned after signed", &RevocationList{}, older, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rl.CheckRollback(tt.prev); (err != nil) != tt.wantErr {
+				t.Errorf("CheckRollback error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
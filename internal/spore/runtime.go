@@ -0,0 +1,216 @@
+package spore
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Runtime types a manifest can declare
+const (
+	RuntimeNative = "native" // the binary is executed directly
+	RuntimeScript = "script" // the binary is a script run by an interpreter on the node
+)
+
+var versionNumberPattern = regexp.MustCompile(`[0-9]+(\.[0-9]+)*`)
+
+// Runtime says how a spore's binary is launched. A nil Runtime is native.
+// For scripts, the binary is the entry point script; supporting files such
+// as a requirements directory are packed as extra files.
+type Runtime struct {
+	Type        string `json:"type"`
+	Interpreter string `json:"interpreter,omitempty"` // name on $PATH or absolute path, e.g. "python3"
+	Version     string `json:"version,omitempty"`     // constraint, e.g. ">=3.10,<4"
+}
+
+// Interpreter is an interpreter found on the node
+type Interpreter struct {
+	Path    string
+	Version string
+}
+
+// RuntimeError is returned when a node lacks the interpreter a spore needs
+type RuntimeError struct {
+	App         string
+	Interpreter string
+	Reason      string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("spore for app %s needs interpreter %s: %s", e.App, e.Interpreter, e.Reason)
+}
+
+// IsScript reports whether the spore's binary must be run by an interpreter
+func (m *Manifest) IsScript() bool {
+	return m.Runtime != nil && m.Runtime.Type == RuntimeScript
+}
+
+// validate checks the runtime fields, reporting problems through add
+func (r *Runtime) validate(add func(field, format string, args ...any)) {
+	switch r.Type {
+	case RuntimeNative:
+		if r.Interpreter != "" || r.Version != "" {
+			add("runtime", "native runtime takes no interpreter or version")
+		}
+	case RuntimeScript:
+		if r.Interpreter == "" {
+			add("runtime.interpreter", "is required for script runtime")
+		}
+		if _, err := parseConstraint(r.Version); err != nil {
+			add("runtime.version", "%v", err)
+		}
+	default:
+		add("runtime.type", "must be %q or %q, got %q", RuntimeNative, RuntimeScript, r.Type)
+	}
+}
+
+// FindInterpreter locates the interpreter a script spore declares and checks
+// its version against the constraint. Native spores return nil.
+func (m *Manifest) FindInterpreter() (*Interpreter, error) {
+	if !m.IsScript() {
+		return nil, nil
+	}
+	rt := m.Runtime
+
+	path, err := exec.LookPath(rt.Interpreter)
+	if err != nil {
+		return nil, &RuntimeError{App: m.Name, Interpreter: rt.Interpreter, Reason: "not found on this node"}
+	}
+	interp := &Interpreter{Path: path}
+
+	if rt.Version == "" {
+		return interp, nil
+	}
+	constraint, err := parseConstraint(rt.Version)
+	if err != nil {
+		return nil, &RuntimeError{App: m.Name, Interpreter: rt.Interpreter, Reason: err.Error()}
+	}
+
+	// Interpreters print their version to stdout or stderr
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return nil, &RuntimeError{App: m.Name, Interpreter: rt.Interpreter, Reason: fmt.Sprintf("failed to read version: %v", err)}
+	}
+	interp.Version = versionNumberPattern.FindString(string(out))
+	if interp.Version == "" {
+		return nil, &RuntimeError{App: m.Name, Interpreter: rt.Interpreter, Reason: fmt.Sprintf("no version in %q", strings.TrimSpace(string(out)))}
+	}
+	if !constraint.allows(interp.Version) {
+		return nil, &RuntimeError{App: m.Name, Interpreter: rt.Interpreter, Reason: fmt.Sprintf("version %s does not satisfy %s", interp.Version, rt.Version)}
+	}
+
+	return interp, nil
+}
+
+// Command returns the argv that launches the binary at binaryPath: the
+// binary itself, or the interpreter running it
+func (i *Interpreter) Command(binaryPath string, args []string) (string, []string) {
+	if i == nil {
+		return binaryPath, args
+	}
+	return i.Path, append([]string{binaryPath}, args...)
+}
+
+// versionConstraint is a list of comparisons that must all hold
+type versionConstraint []versionComparison
+
+type versionComparison struct {
+	op      string
+	version []int
+}
+
+// parseConstraint parses comma-separated comparisons such as ">=3.10,<4".
+// A bare version matches that version and any release under it, so "3.11"
+// allows 3.11.4.
+func parseConstraint(s string) (versionConstraint, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var c versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := ""
+		for _, candidate := range []string{">=", "<=", "==", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				break
+			}
+		}
+		version, err := parseVersionNumber(strings.TrimSpace(strings.TrimPrefix(part, op)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		c = append(c, versionComparison{op: op, version: version})
+	}
+	return c, nil
+}
+
+// allows reports whether version satisfies every comparison
+func (c versionConstraint) allows(version string) bool {
+	v, err := parseVersionNumber(version)
+	if err != nil {
+		return false
+	}
+
+	for _, cmp := range c {
+		n := compareVersions(v, cmp.version)
+		var ok bool
+		switch cmp.op {
+		case ">=":
+			ok = n >= 0
+		case ">":
+			ok = n > 0
+		case "<=":
+			ok = n <= 0
+		case "<":
+			ok = n < 0
+		case "=", "==":
+			ok = n == 0
+		default:
+			ok = len(v) >= len(cmp.version) && compareVersions(v[:len(cmp.version)], cmp.version) == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseVersionNumber parses a dotted version such as "3.11.4"
+func parseVersionNumber(s string) ([]int, error) {
+	if versionNumberPattern.FindString(s) != s {
+		return nil, fmt.Errorf("invalid version %q", s)
+	}
+	var v []int
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+// compareVersions compares dotted versions, treating missing parts as zero
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
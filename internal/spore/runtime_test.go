@@ -0,0 +1,116 @@
+package spore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{">=3.10", "3.11.4", true},
+		{">=3.10", "3.9.18", false},
+		{">=3.10,<4", "4.0.1", false},
+		{">=3.10, <4", "3.12", true},
+		{"3.11", "3.11.4", true},
+		{"3.11", "3.1", false},
+		{"==5.2", "5.2.0", true},
+		{">5", "5", false},
+		{"<=5.2", "5.2.15", false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("parseConstraint(%q) failed: %v", tt.constraint, err)
+		}
+		if got := c.allows(tt.version); got != tt.want {
+			t.Errorf("%q allows %s = %v, expected %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{">=", "~3.10", "3.x", ">=3.10,"} {
+		if _, err := parseConstraint(bad); err == nil {
+			t.Errorf("parseConstraint(%q) should fail", bad)
+		}
+	}
+}
+
+func TestValidateRuntime(t *testing.T) {
+	tests := []struct {
+		name    string
+		runtime Runtime
+		field   string
+	}{
+		{"script", Runtime{Type: RuntimeScript, Interpreter: "python3", Version: ">=3.10"}, ""},
+		{"native", Runtime{Type: RuntimeNative}, ""},
+		{"unknown type", Runtime{Type: "jvm"}, "runtime.type"},
+		{"no interpreter", Runtime{Type: RuntimeScript}, "runtime.interpreter"},
+		{"bad version", Runtime{Type: RuntimeScript, Interpreter: "python3", Version: "~3"}, "runtime.version"},
+		{"native interpreter", Runtime{Type: RuntimeNative, Interpreter: "python3"}, "runtime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validManifest()
+			m.Runtime = &tt.runtime
+			err := m.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Expected valid runtime, got %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || errs[0].Field != tt.field {
+				t.Errorf("Expected error on %s, got %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestFindInterpreter(t *testing.T) {
+	// A fake interpreter that reports its version like python does
+	interpPath := filepath.Join(t.TempDir(), "fakepython")
+	if err := os.WriteFile(interpPath, []byte("#!/bin/sh\necho 'Python 3.11.4'\n"), 0755); err != nil {
+		t.Fatalf("Failed to write interpreter: %v", err)
+	}
+
+	m := validManifest()
+	if interp, err := m.FindInterpreter(); interp != nil || err != nil {
+		t.Errorf("Native spore should need no interpreter, got %v, %v", interp, err)
+	}
+
+	m.Runtime = &Runtime{Type: RuntimeScript, Interpreter: interpPath, Version: ">=3.10"}
+	interp, err := m.FindInterpreter()
+	if err != nil {
+		t.Fatalf("FindInterpreter failed: %v", err)
+	}
+	if interp.Version != "3.11.4" {
+		t.Errorf("Expected version 3.11.4, got %s", interp.Version)
+	}
+	name, args := interp.Command("/run/app.py", []string{"--port", "80"})
+	if name != interpPath || len(args) != 3 || args[0] != "/run/app.py" {
+		t.Errorf("Unexpected command %s %v", name, args)
+	}
+
+	var runtimeErr *RuntimeError
+	m.Runtime.Version = ">=3.12"
+	if _, err := m.FindInterpreter(); !errors.As(err, &runtimeErr) {
+		t.Errorf("Expected RuntimeError for a too old interpreter, got %v", err)
+	}
+
+	m.Runtime = &Runtime{Type: RuntimeScript, Interpreter: "no-such-interpreter-on-this-node"}
+	_, err = m.FindInterpreter()
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("Expected RuntimeError for a missing interpreter, got %v", err)
+	}
+	if got := Reason(err); got != ReasonRuntime {
+		t.Errorf("Expected reason %q, got %q", ReasonRuntime, got)
+	}
+}
@@ -0,0 +1,200 @@
+package spore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// APIVersion is the manifest schema version written by this package
+const APIVersion = "mesh.mycelium/v1"
+
+// apiVersionV1Alpha1 is the implicit version of manifests written before
+// apiVersion existed
+const apiVersionV1Alpha1 = "mesh.mycelium/v1alpha1"
+
+// migrations upgrade a manifest from one schema version to the next, in order
+var migrations = []struct {
+	from, to string
+	apply    func(m *Manifest)
+}{
+	{apiVersionV1Alpha1, APIVersion, func(m *Manifest) {
+		if m.Kind == "" {
+			m.Kind = "Spore"
+		}
+	}},
+}
+
+var (
+	namePattern    = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	versionPattern = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// maxNameLength keeps app names usable as DNS labels
+const maxNameLength = 63
+
+// ValidationError describes one invalid manifest field
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// ValidationErrors collects every problem found in a manifest
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "invalid manifest: " + strings.Join(msgs, "; ")
+}
+
+// ParseManifest strictly decodes a manifest, rejecting unknown fields, and
+// migrates it to the current schema version
+func ParseManifest(data []byte) (*Manifest, error) {
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadManifest reads and parses a manifest file
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return ParseManifest(data)
+}
+
+// decodeManifest decodes exactly one manifest object, rejecting unknown
+// fields and trailing data
+func decodeManifest(data []byte) (*Manifest, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var m Manifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("failed to parse manifest: unexpected data after manifest")
+	}
+	return &m, nil
+}
+
+// Migrate upgrades a manifest in place to APIVersion. A manifest without an
+// apiVersion predates versioning and is treated as v1alpha1.
+func Migrate(m *Manifest) error {
+	if m.APIVersion == "" {
+		m.APIVersion = apiVersionV1Alpha1
+	}
+	for _, mig := range migrations {
+		if m.APIVersion == mig.from {
+			mig.apply(m)
+			m.APIVersion = mig.to
+		}
+	}
+	if m.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported manifest apiVersion %q", m.APIVersion)
+	}
+	return nil
+}
+
+// knownAPIVersion reports whether a manifest's schema version can be read
+func knownAPIVersion(version string) bool {
+	if version == "" || version == APIVersion {
+		return true
+	}
+	for _, mig := range migrations {
+		if version == mig.from {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields a publisher writes. It returns ValidationErrors
+// listing every invalid field, or nil.
+func (m *Manifest) Validate() error {
+	var errs ValidationErrors
+	add := func(field, format string, args ...any) {
+		errs = append(errs, &ValidationError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if m.APIVersion != APIVersion {
+		add("apiVersion", "must be %q, got %q", APIVersion, m.APIVersion)
+	}
+	if m.Kind != "" && m.Kind != "Spore" {
+		add("kind", "must be \"Spore\", got %q", m.Kind)
+	}
+
+	// Names end up in spore file names, DNS and process names
+	switch {
+	case m.Name == "":
+		add("name", "is required")
+	case len(m.Name) > maxNameLength:
+		add("name", "must be at most %d characters", maxNameLength)
+	case !namePattern.MatchString(m.Name):
+		add("name", "must be lowercase letters, digits and '-', starting and ending with a letter or digit")
+	}
+
+	switch {
+	case m.Version == "":
+		add("version", "is required")
+	case !versionPattern.MatchString(m.Version):
+		add("version", "%q is not a semantic version (e.g. v1.2.3)", m.Version)
+	}
+
+	if err := validCommand(m.Command); err != nil {
+		add("command", "%v", err)
+	}
+	if m.Runtime != nil {
+		m.Runtime.validate(add)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.Env)) {
+		if !envNamePattern.MatchString(name) {
+			add("env", "invalid variable name %q", name)
+		}
+	}
+	for i, p := range m.Provides {
+		if p == "" {
+			add(fmt.Sprintf("provides[%d]", i), "must not be empty")
+		}
+	}
+
+	if m.Nutrients.CPUMilli <= 0 {
+		add("nutrients.cpu_milli", "must be positive")
+	}
+	if m.Nutrients.MemoryMB <= 0 {
+		add("nutrients.memory_mb", "must be positive")
+	}
+	if m.SLO.P99BudgetMs < 0 {
+		add("slo.p99_budget_ms", "must not be negative")
+	}
+	if !m.NotBefore.IsZero() && !m.NotAfter.IsZero() && !m.NotAfter.After(m.NotBefore) {
+		add("not_after", "must be after not_before")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,191 @@
+package spore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func validManifest() Manifest {
+	return Manifest{
+		APIVersion: APIVersion,
+		Name:       "test-app",
+		Version:    "v1.0.0",
+		Command:    "test-binary",
+		Nutrients:  Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+}
+
+func TestParseManifestRejectsUnknownFields(t *testing.T) {
+	data := []byte(`{"name":"test-app","version":"v1.0.0","command":"app","nutrients":{"cpu_mili":100,"memory_mb":64}}`)
+
+	_, err := ParseManifest(data)
+	if err == nil {
+		t.Fatal("ParseManifest should reject unknown fields")
+	}
+	if !strings.Contains(err.Error(), "cpu_mili") {
+		t.Errorf("Expected error to name the unknown field, got %v", err)
+	}
+
+	if _, err := ParseManifest([]byte(`{"name":"test-app"} {"name":"other"}`)); err == nil {
+		t.Error("ParseManifest should reject trailing data")
+	}
+}
+
+func TestParseManifestMigratesLegacy(t *testing.T) {
+	data := []byte(`{"name":"test-app","version":"v1.0.0","command":"app","nutrients":{"cpu_milli":100,"memory_mb":64}}`)
+
+	m, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %v", err)
+	}
+	if m.APIVersion != APIVersion {
+		t.Errorf("Expected apiVersion %s, got %s", APIVersion, m.APIVersion)
+	}
+	if m.Kind != "Spore" {
+		t.Errorf("Expected kind Spore, got %s", m.Kind)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Migrated manifest should be valid: %v", err)
+	}
+
+	if _, err := ParseManifest([]byte(`{"apiVersion":"mesh.mycelium/v9"}`)); err == nil {
+		t.Error("ParseManifest should reject an unknown apiVersion")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(m *Manifest)
+		field  string
+	}{
+		{"valid", func(m *Manifest) {}, ""},
+		{"prerelease version", func(m *Manifest) { m.Version = "1.2.3-rc.1+build.5" }, ""},
+		{"missing name", func(m *Manifest) { m.Name = "" }, "name"},
+		{"uppercase name", func(m *Manifest) { m.Name = "Test_App" }, "name"},
+		{"long name", func(m *Manifest) { m.Name = strings.Repeat("a", 64) }, "name"},
+		{"bad version", func(m *Manifest) { m.Version = "latest" }, "version"},
+		{"missing command", func(m *Manifest) { m.Command = "" }, "command"},
+		{"command path", func(m *Manifest) { m.Command = "bin/app" }, "command"},
+		{"zero cpu", func(m *Manifest) { m.Nutrients.CPUMilli = 0 }, "nutrients.cpu_milli"},
+		{"negative memory", func(m *Manifest) { m.Nutrients.MemoryMB = -1 }, "nutrients.memory_mb"},
+		{"bad env name", func(m *Manifest) { m.Env = map[string]string{"1X": "y"} }, "env"},
+		{"wrong kind", func(m *Manifest) { m.Kind = "Pod" }, "kind"},
+		{"unmigrated", func(m *Manifest) { m.APIVersion = "" }, "apiVersion"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validManifest()
+			tt.modify(&m)
+
+			err := m.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Validate failed: %v", err)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Expected ValidationErrors, got %v", err)
+			}
+			if len(errs) != 1 || errs[0].Field != tt.field {
+				t.Errorf("Expected one error for %s, got %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryField(t *testing.T) {
+	var m Manifest
+	if err := Migrate(&m); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	var errs ValidationErrors
+	if !errors.As(m.Validate(), &errs) {
+		t.Fatal("Expected ValidationErrors for an empty manifest")
+	}
+	if len(errs) != 5 {
+		t.Errorf("Expected 5 errors (name, version, command, cpu, memory), got %d: %v", len(errs), errs)
+	}
+}
+
+func TestPackRejectsInvalidManifest(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	m := validManifest()
+	m.Nutrients = Nutrients{}
+	var buf bytes.Buffer
+	if _, err := PackTo(&buf, Payload{Binary: strings.NewReader("bin")}, m, priv); err == nil {
+		t.Fatal("PackTo should reject a manifest without nutrients")
+	}
+	if buf.Len() != 0 {
+		t.Error("PackTo should not write anything for an invalid manifest")
+	}
+
+	// Legacy manifests are upgraded when packed
+	m = validManifest()
+	m.APIVersion = ""
+	out, err := PackTo(&buf, Payload{Binary: strings.NewReader("bin")}, m, priv)
+	if err != nil {
+		t.Fatalf("PackTo failed: %v", err)
+	}
+	if out.APIVersion != APIVersion {
+		t.Errorf("Expected apiVersion %s, got %s", APIVersion, out.APIVersion)
+	}
+}
+
+func TestVerifyLegacySpore(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// Sign a manifest the way releases before apiVersion did
+	binary := []byte("bin")
+	binaryHash := sha256.Sum256(binary)
+	m := validManifest()
+	m.APIVersion = ""
+	m.Kind = "Spore"
+	m.BinarySHA256 = fmt.Sprintf("%x", binaryHash)
+	m.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	hash, err := signingHash(m, binaryHash[:])
+	if err != nil {
+		t.Fatalf("signingHash failed: %v", err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, hash[:]))
+
+	data := buildArchive(t, []testEntry{
+		{name: "manifest.json", data: manifestJSON(t, m)},
+		{name: "binary", data: binary},
+	})
+	verified, err := VerifyReader(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatalf("Verify of legacy spore failed: %v", err)
+	}
+	if verified.APIVersion != "" {
+		t.Errorf("Verify should return the manifest as signed, got apiVersion %q", verified.APIVersion)
+	}
+
+	// Spores from a newer schema are refused rather than misread
+	m.APIVersion = "mesh.mycelium/v9"
+	data = buildArchive(t, []testEntry{
+		{name: "manifest.json", data: manifestJSON(t, m)},
+		{name: "binary", data: binary},
+	})
+	if _, err := VerifyReader(bytes.NewReader(data), int64(len(data)), nil); err == nil {
+		t.Error("Verify should reject an unknown apiVersion")
+	}
+}
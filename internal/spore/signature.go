@@ -0,0 +1,248 @@
+package spore
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidSignature is returned when a signature does not verify against
+// its public key
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Signature is an independent signature over a spore's manifest and binary
+type Signature struct {
+	PublicKey string `json:"public_key"` // base64
+	KeyID     string `json:"key_id,omitempty"`
+	Signature string `json:"signature"` // base64
+}
+
+// ThresholdError is returned when fewer trusted keys signed a spore than its
+// app requires
+type ThresholdError struct {
+	App      string
+	Required int
+	Trusted  int
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("spore for app %s has %d trusted signatures, %d required", e.App, e.Trusted, e.Required)
+}
+
+// signingHash computes sha256(manifest_without_sigs || binary_hash), the value
+// every signer signs
+func signingHash(m Manifest, binaryHash []byte) ([32]byte, error) {
+	m.Signature = ""
+	m.Cosignatures = nil
+
+	manifestData, err := json.Marshal(m)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to marshal manifest without signature: %w", err)
+	}
+
+	signingData := append(manifestData, binaryHash...)
+	return sha256.Sum256(signingData), nil
+}
+
+// signatures returns the primary signature followed by all cosignatures
+func (m *Manifest) signatures() []Signature {
+	sigs := []Signature{{PublicKey: m.PublicKey, KeyID: m.KeyID, Signature: m.Signature}}
+	return append(sigs, m.Cosignatures...)
+}
+
+// verifySignatures checks every signature on the manifest and, when kr is
+// non-nil, that enough distinct trusted keys signed it
+func verifySignatures(m *Manifest, binaryHash []byte, kr *Keyring) error {
+	hash, err := signingHash(*m, binaryHash)
+	if err != nil {
+		return err
+	}
+
+	var signers []ed25519.PublicKey
+	for i, sig := range m.signatures() {
+		pub, err := sig.verify(hash[:])
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			return fmt.Errorf("cosignature %d: %w", i, err)
+		}
+		signers = append(signers, pub)
+	}
+
+	if kr == nil {
+		return nil
+	}
+
+	// A rotated key is trusted when a trusted previous key endorsed it
+	var endorsed, endorser ed25519.PublicKey
+	if m.Endorsement != nil {
+		endorsed, endorser, err = m.Endorsement.Verify()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Count distinct trusted signers. An endorsed key is its endorser's
+	// identity, so one key holder cannot mint a second signer for itself.
+	seen := make(map[string]bool)
+	for _, pub := range signers {
+		identity := Fingerprint(pub)
+		_, trusted := kr.Lookup(pub, m.Name)
+		if endorsed.Equal(pub) {
+			identity = Fingerprint(endorser)
+			if _, ok := kr.Lookup(endorser, m.Name); ok {
+				trusted = true
+			}
+		}
+		if trusted {
+			seen[identity] = true
+		}
+	}
+
+	if len(seen) == 0 {
+		return &UntrustedKeyError{App: m.Name, Fingerprint: Fingerprint(signers[0])}
+	}
+
+	if required := kr.Threshold(m.Name); len(seen) < required {
+		return &ThresholdError{App: m.Name, Required: required, Trusted: len(seen)}
+	}
+
+	return nil
+}
+
+// verify checks the signature against hash and returns the signing key
+func (s Signature) verify(hash []byte) (ed25519.PublicKey, error) {
+	pubKeyData, err := base64.StdEncoding.DecodeString(s.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode public key: %v", ErrInvalidSignature, err)
+	}
+	if len(pubKeyData) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: invalid public key length %d", ErrInvalidSignature, len(pubKeyData))
+	}
+	if s.KeyID != "" && s.KeyID != Fingerprint(pubKeyData) {
+		return nil, fmt.Errorf("%w: key ID %s does not match public key", ErrInvalidSignature, s.KeyID)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode signature: %v", ErrInvalidSignature, err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(pubKeyData), hash, signature) {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidSignature)
+	}
+
+	return ed25519.PublicKey(pubKeyData), nil
+}
+
+// Cosign appends a signature by priv to an existing spore in place
+func Cosign(sporePath string, priv ed25519.PrivateKey) (*Manifest, error) {
+	// Only cosign spores that are intact
+	manifest, err := Verify(sporePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("spore verification failed: %w", err)
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	pubB64 := base64.StdEncoding.EncodeToString(pub)
+	for _, sig := range manifest.signatures() {
+		if sig.PublicKey == pubB64 {
+			return nil, fmt.Errorf("spore already signed by key %s", Fingerprint(pub))
+		}
+	}
+
+	binaryHash, err := hex.DecodeString(manifest.BinarySHA256)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary hash: %w", err)
+	}
+
+	hash, err := signingHash(*manifest, binaryHash)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Cosignatures = append(manifest.Cosignatures, Signature{
+		PublicKey: pubB64,
+		KeyID:     Fingerprint(pub),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, hash[:])),
+	})
+
+	if err := rewriteManifest(sporePath, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// rewriteManifest replaces manifest.json in a spore, copying every other
+// entry as-is, and atomically swaps the result into place
+func rewriteManifest(sporePath string, m *Manifest) error {
+	zipReader, err := zip.OpenReader(sporePath)
+	if err != nil {
+		return fmt.Errorf("failed to open spore file: %w", err)
+	}
+	defer zipReader.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(sporePath), ".spore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	zipWriter := zip.NewWriter(tmpFile)
+	for _, file := range zipReader.File {
+		if file.Name == "manifest.json" {
+			if err := writeManifest(zipWriter, m); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := zipWriter.Copy(file); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", file.Name, err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish spore: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close spore: %w", err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set spore permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), sporePath); err != nil {
+		return fmt.Errorf("failed to replace spore: %w", err)
+	}
+
+	return nil
+}
+
+// writeManifest adds manifest.json to a spore being written
+func writeManifest(zw *zip.Writer, m *Manifest) error {
+	manifestWriter, err := zw.Create("manifest.json")
+	if err != nil {
+		return fmt.Errorf("failed to create manifest in zip: %w", err)
+	}
+
+	finalManifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal final manifest: %w", err)
+	}
+
+	if _, err := manifestWriter.Write(finalManifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
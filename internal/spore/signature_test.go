@@ -0,0 +1,180 @@
+package spore
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCosignThreshold(t *testing.T) {
+	builderPub, builderPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	approverPub, approverPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, builderPriv)
+
+	kr := &Keyring{}
+	if err := kr.Add("builder", builderPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := kr.Add("approver", approverPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := kr.SetThreshold("test-app", 2); err != nil {
+		t.Fatalf("SetThreshold failed: %v", err)
+	}
+
+	// Only the builder has signed so far
+	_, err = Verify(sporePath, kr)
+	var threshold *ThresholdError
+	if !errors.As(err, &threshold) {
+		t.Fatalf("Expected ThresholdError, got %v", err)
+	}
+	if threshold.Required != 2 || threshold.Trusted != 1 {
+		t.Errorf("Expected 1 of 2 signatures, got %d of %d", threshold.Trusted, threshold.Required)
+	}
+
+	manifest, err := Cosign(sporePath, approverPriv)
+	if err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+	if len(manifest.Cosignatures) != 1 {
+		t.Fatalf("Expected 1 cosignature, got %d", len(manifest.Cosignatures))
+	}
+
+	if _, err := Verify(sporePath, kr); err != nil {
+		t.Fatalf("Verify with 2 of 2 signatures failed: %v", err)
+	}
+
+	// The same key cannot sign twice
+	if _, err := Cosign(sporePath, approverPriv); err == nil {
+		t.Error("Cosigning twice with the same key should fail")
+	}
+}
+
+func TestThresholdIgnoresUntrustedCosigners(t *testing.T) {
+	builderPub, builderPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	_, strangerPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, builderPriv)
+
+	if _, err := Cosign(sporePath, strangerPriv); err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+
+	kr := &Keyring{}
+	if err := kr.Add("builder", builderPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	// One trusted signer satisfies the default threshold
+	if _, err := Verify(sporePath, kr); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// An untrusted cosigner does not count towards a higher threshold
+	if err := kr.SetThreshold("*", 2); err != nil {
+		t.Fatalf("SetThreshold failed: %v", err)
+	}
+	var threshold *ThresholdError
+	if _, err := Verify(sporePath, kr); !errors.As(err, &threshold) {
+		t.Fatalf("Expected ThresholdError, got %v", err)
+	}
+}
+
+func TestThresholdCountsEndorsedKeyOnce(t *testing.T) {
+	oldPub, oldPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	newPub, newPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	approverPub, approverPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	kr := &Keyring{}
+	if err := kr.Add("old", oldPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := kr.Add("approver", approverPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := kr.SetThreshold("test-app", 2); err != nil {
+		t.Fatalf("SetThreshold failed: %v", err)
+	}
+
+	// The holder of the old key endorses a key of its own and cosigns with
+	// both: still one signer
+	manifest := Manifest{
+		Name:        "test-app",
+		Version:     "v1.0.0",
+		Command:     "test-binary",
+		Nutrients:   Nutrients{CPUMilli: 100, MemoryMB: 64},
+		Endorsement: Endorse(oldPriv, newPub),
+	}
+	binaryPath := filepath.Join(t.TempDir(), "test-binary")
+	if err := os.WriteFile(binaryPath, []byte("test binary content"), 0755); err != nil {
+		t.Fatalf("Failed to create test binary: %v", err)
+	}
+	sporePath, _, err := Pack(binaryPath, manifest, newPriv, t.TempDir())
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+	if _, err := Cosign(sporePath, oldPriv); err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+	var threshold *ThresholdError
+	if _, err := Verify(sporePath, kr); !errors.As(err, &threshold) || threshold.Trusted != 1 {
+		t.Fatalf("Expected 1 of 2 trusted signers, got %v", err)
+	}
+
+	// A second key holder meets the threshold
+	if _, err := Cosign(sporePath, approverPriv); err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+	if _, err := Verify(sporePath, kr); err != nil {
+		t.Fatalf("Verify with the rotated key and an approver failed: %v", err)
+	}
+}
+
+func TestVerifyRejectsForgedCosignature(t *testing.T) {
+	_, builderPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	_, approverPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, builderPriv)
+
+	manifest, err := Cosign(sporePath, approverPriv)
+	if err != nil {
+		t.Fatalf("Cosign failed: %v", err)
+	}
+
+	// Swap the cosignature for the builder's own signature under the approver's key
+	manifest.Cosignatures[0].Signature = manifest.Signature
+	if err := rewriteManifest(sporePath, manifest); err != nil {
+		t.Fatalf("rewriteManifest failed: %v", err)
+	}
+
+	if _, err := Verify(sporePath, nil); err == nil {
+		t.Fatal("Verify should fail with a forged cosignature")
+	}
+}
@@ -2,10 +2,13 @@ package spore
 
 import (
 	"archive/zip"
+	"crypto/ecdh"
 	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,22 +16,61 @@ import (
 	"time"
 )
 
+// ErrHashMismatch is returned when the binary or a file does not match the
+// hash recorded in the manifest
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// ValidityError is returned when a spore is used outside the validity period
+// its publisher signed
+type ValidityError struct {
+	App       string
+	NotBefore time.Time
+	NotAfter  time.Time
+	Now       time.Time
+}
+
+func (e *ValidityError) Error() string {
+	if e.Expired() {
+		return fmt.Sprintf("spore for app %s expired at %s", e.App, e.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("spore for app %s is not valid before %s", e.App, e.NotBefore.Format(time.RFC3339))
+}
+
+// Expired reports whether the spore is past its validity rather than not yet
+// valid
+func (e *ValidityError) Expired() bool {
+	return !e.NotAfter.IsZero() && e.Now.After(e.NotAfter)
+}
+
 // Manifest represents the DNA of a spore
 type Manifest struct {
-	Kind         string            `json:"kind"` // "Spore"
-	Name         string            `json:"name"`
-	Version      string            `json:"version"`
-	Command      string            `json:"command"`
-	Args         []string          `json:"args"`
-	Env          map[string]string `json:"env"`
-	Provides     []string          `json:"provides"`
-	Nutrients    Nutrients         `json:"nutrients"`
-	SLO          SLO               `json:"slo"`
-	Security     Security          `json:"security"`
-	CreatedAt    time.Time         `json:"created_at"`
-	BinarySHA256 string            `json:"binary_sha256"`
-	Signature    string            `json:"signature"`  // base64
-	PublicKey    string            `json:"public_key"` // base64
+	APIVersion   string             `json:"apiVersion,omitempty"`
+	Kind         string             `json:"kind"` // "Spore"
+	Name         string             `json:"name"`
+	Version      string             `json:"version"`
+	Command      string             `json:"command"`
+	Runtime      *Runtime           `json:"runtime,omitempty"`
+	Args         []string           `json:"args"`
+	Env          map[string]string  `json:"env"`
+	Provides     []string           `json:"provides"`
+	Nutrients    Nutrients          `json:"nutrients"`
+	SLO          SLO                `json:"slo"`
+	Security     Security           `json:"security"`
+	CreatedAt    time.Time          `json:"created_at"`
+	NotBefore    time.Time          `json:"not_before,omitzero"`
+	NotAfter     time.Time          `json:"not_after,omitzero"`
+	Overlay      *OverlayRecord     `json:"overlay,omitempty"`
+	BinarySHA256 string             `json:"binary_sha256"`
+	BinarySize   int64              `json:"binary_size,omitempty"`
+	Signature    string             `json:"signature"`  // base64
+	PublicKey    string             `json:"public_key"` // base64
+	KeyID        string             `json:"key_id,omitempty"`
+	Endorsement  *Endorsement       `json:"endorsement,omitempty"`
+	Cosignatures []Signature        `json:"cosignatures,omitempty"`
+	Binaries     []BinaryEntry      `json:"binaries,omitempty"`
+	Encryption   *Encryption        `json:"encryption,omitempty"`
+	Files        []FileEntry        `json:"files,omitempty"`
+	Attestations []AttestationEntry `json:"attestations,omitempty"`
 }
 
 type Nutrients struct {
@@ -45,228 +87,491 @@ type Security struct {
 	ReadOnlyFS bool   `json:"read_only_fs"`
 }
 
+// Payload is the content packed into a spore alongside its manifest. It
+// carries either a single Binary or one binary per platform in Binaries.
+type Payload struct {
+	Binary       io.Reader
+	Binaries     []PlatformBinary
+	Files        []File
+	Attestations []Attestation
+
+	// CreatedAt is stamped into the manifest; the zero value means now. Set
+	// it (see SourceDateEpoch) to make the spore reproducible.
+	CreatedAt time.Time
+
+	// Recipients, if any, are the node keys the single Binary is encrypted
+	// to. Encrypted spores are not reproducible.
+	Recipients []*ecdh.PublicKey
+}
+
 // Pack creates a signed spore bundle
 func Pack(binaryPath string, m Manifest, priv ed25519.PrivateKey, outDir string) (sporePath string, out *Manifest, err error) {
-	// Read the binary file
-	binaryData, err := os.ReadFile(binaryPath)
+	// Open the binary file
+	binaryFile, err := os.Open(binaryPath)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to read binary: %w", err)
 	}
+	defer binaryFile.Close()
 
-	// Compute binary SHA256
-	hash := sha256.Sum256(binaryData)
-	m.BinarySHA256 = fmt.Sprintf("%x", hash)
-
-	// Set creation time
-	m.CreatedAt = time.Now()
-	m.Kind = "Spore"
-
-	// Get public key
-	pubKey := priv.Public().(ed25519.PublicKey)
-	m.PublicKey = base64.StdEncoding.EncodeToString(pubKey)
-
-	// Create manifest without signature for signing
-	manifestData, err := json.Marshal(m)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal manifest: %w", err)
-	}
-
-	// Create signing data: sha256(manifest_without_sig || binary_hash)
-	signingData := append(manifestData, hash[:]...)
-	signingHash := sha256.Sum256(signingData)
-
-	// Sign the hash
-	signature := ed25519.Sign(priv, signingHash[:])
-	m.Signature = base64.StdEncoding.EncodeToString(signature)
+	return PackPayload(Payload{Binary: binaryFile}, m, priv, outDir)
+}
 
+// PackPayload creates a signed spore bundle named after the manifest in outDir
+func PackPayload(p Payload, m Manifest, priv ed25519.PrivateKey, outDir string) (sporePath string, out *Manifest, err error) {
 	// Create spore file
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
 	sporeName := fmt.Sprintf("%s-%s.spore", m.Name, m.Version)
 	sporePath = filepath.Join(outDir, sporeName)
 
-	// Create zip file
 	zipFile, err := os.Create(sporePath)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to create spore file: %w", err)
 	}
 	defer zipFile.Close()
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	// Add manifest.json
-	manifestWriter, err := zipWriter.Create("manifest.json")
+	out, err = PackTo(zipFile, p, m, priv)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create manifest in zip: %w", err)
+		return "", nil, err
 	}
 
-	finalManifest, err := json.MarshalIndent(m, "", "  ")
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal final manifest: %w", err)
+	if err := zipFile.Close(); err != nil {
+		return "", nil, fmt.Errorf("failed to close spore file: %w", err)
 	}
 
-	if _, err := manifestWriter.Write(finalManifest); err != nil {
-		return "", nil, fmt.Errorf("failed to write manifest: %w", err)
+	return sporePath, out, nil
+}
+
+// PackTo streams a signed spore to w. The binary is hashed while it is
+// written, so it is never held in memory; manifest.json is written last,
+// once the hash is known. Entries are written in canonical order without
+// modification times, so with a fixed CreatedAt the same payload, manifest
+// and key always produce the same bytes.
+func PackTo(w io.Writer, p Payload, m Manifest, priv ed25519.PrivateKey) (*Manifest, error) {
+	// Upgrade older manifests and reject invalid ones before writing anything
+	if err := Migrate(&m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
 	}
 
-	// Add binary
-	binaryWriter, err := zipWriter.Create("binary")
+	if (p.Binary == nil) == (len(p.Binaries) == 0) {
+		return nil, fmt.Errorf("spore needs either a binary or per-platform binaries")
+	}
+	if len(p.Recipients) > 0 && p.Binary == nil {
+		return nil, fmt.Errorf("only single-binary spores can be encrypted")
+	}
+
+	p = p.sorted()
+	zipWriter := zip.NewWriter(w)
+
+	// Add the binary, or one binary per platform, hashing as they stream.
+	// Per-platform hashes are recorded in the manifest, so only a single
+	// binary's hash is appended to the signed data.
+	var hash []byte
+	m.BinarySHA256, m.BinarySize, m.Binaries, m.Encryption = "", 0, nil, nil
+	if p.Binary != nil {
+		binaryWriter, err := zipWriter.Create("binary")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create binary in zip: %w", err)
+		}
+
+		// The recorded hash is of the entry as stored, encrypted or not
+		hasher := sha256.New()
+		var n int64
+		if len(p.Recipients) > 0 {
+			n, err = encryptBinary(io.MultiWriter(binaryWriter, hasher), p.Binary, p.Recipients, &m)
+		} else {
+			n, err = io.Copy(io.MultiWriter(binaryWriter, hasher), p.Binary)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write binary: %w", err)
+		}
+		hash = hasher.Sum(nil)
+		m.BinarySHA256 = fmt.Sprintf("%x", hash)
+		m.BinarySize = n
+	}
+	for _, b := range p.Binaries {
+		entry, err := writeBinary(zipWriter, b)
+		if err != nil {
+			return nil, err
+		}
+		m.Binaries = append(m.Binaries, entry)
+	}
+	if err := checkBinaries(m.Binaries); err != nil {
+		return nil, err
+	}
+	if m.IsScript() && len(m.Binaries) > 0 {
+		return nil, fmt.Errorf("script spores carry one entry point script, not per-platform binaries")
+	}
+
+	// Add extra files, recording each one in the manifest
+	var paths []string
+	for _, f := range p.Files {
+		paths = append(paths, f.Path)
+	}
+	if err := checkFilePaths(&m, paths); err != nil {
+		return nil, err
+	}
+
+	m.Files = nil
+	for _, f := range p.Files {
+		entry, err := writeFile(zipWriter, f)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, entry)
+	}
+
+	// Add attestations about the binary, recording their hashes so the
+	// signature covers them
+	m.Attestations = nil
+	for _, a := range p.Attestations {
+		entry, err := writeAttestation(zipWriter, a, &m)
+		if err != nil {
+			return nil, err
+		}
+		m.Attestations = append(m.Attestations, entry)
+	}
+	if err := checkAttestations(m.Attestations); err != nil {
+		return nil, err
+	}
+
+	// Set creation time
+	m.CreatedAt = time.Now()
+	if !p.CreatedAt.IsZero() {
+		m.CreatedAt = p.CreatedAt.UTC()
+	}
+	m.Kind = "Spore"
+
+	// Get public key
+	pubKey := priv.Public().(ed25519.PublicKey)
+	m.PublicKey = base64.StdEncoding.EncodeToString(pubKey)
+	m.KeyID = Fingerprint(pubKey)
+	m.Cosignatures = nil
+
+	// An endorsement must vouch for the signing key
+	if m.Endorsement != nil {
+		next, _, err := m.Endorsement.Verify()
+		if err != nil {
+			return nil, err
+		}
+		if !next.Equal(pubKey) {
+			return nil, fmt.Errorf("endorsement is for key %s, not signing key %s", m.Endorsement.KeyID, m.KeyID)
+		}
+	}
+
+	// Sign sha256(manifest_without_sig || binary_hash)
+	signingHash, err := signingHash(m, hash)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create binary in zip: %w", err)
+		return nil, err
+	}
+	signature := ed25519.Sign(priv, signingHash[:])
+	m.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	// Add manifest.json
+	if err := writeManifest(zipWriter, &m); err != nil {
+		return nil, err
 	}
 
-	if _, err := binaryWriter.Write(binaryData); err != nil {
-		return "", nil, fmt.Errorf("failed to write binary: %w", err)
+	if err := zipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish spore: %w", err)
 	}
 
-	return sporePath, &m, nil
+	return &m, nil
 }
 
-// Verify verifies a spore's signature
-func Verify(sporePath string) (*Manifest, error) {
-	// Open zip file
-	zipReader, err := zip.OpenReader(sporePath)
+// Verify verifies a spore's signatures. When kr is non-nil the signers must
+// also be trusted for the spore's app, in the number the keyring requires.
+func Verify(sporePath string, kr *Keyring) (*Manifest, error) {
+	file, err := os.Open(sporePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open spore file: %w", err)
 	}
-	defer zipReader.Close()
+	defer file.Close()
 
-	var manifestData []byte
-	var binaryData []byte
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat spore file: %w", err)
+	}
 
-	// Extract manifest and binary
-	for _, file := range zipReader.File {
-		switch file.Name {
-		case "manifest.json":
-			rc, err := file.Open()
-			if err != nil {
-				return nil, fmt.Errorf("failed to open manifest: %w", err)
-			}
-			manifestData, err = io.ReadAll(rc)
-			rc.Close()
-			if err != nil {
-				return nil, fmt.Errorf("failed to read manifest: %w", err)
-			}
-		case "binary":
-			rc, err := file.Open()
-			if err != nil {
-				return nil, fmt.Errorf("failed to open binary: %w", err)
-			}
-			binaryData, err = io.ReadAll(rc)
-			rc.Close()
-			if err != nil {
-				return nil, fmt.Errorf("failed to read binary: %w", err)
-			}
-		}
+	return VerifyReader(file, info.Size(), kr)
+}
+
+// VerifyReader verifies a spore read from r in a single pass, streaming the
+// binary through the hasher rather than buffering it
+func VerifyReader(r io.ReaderAt, size int64, kr *Keyring) (*Manifest, error) {
+	manifest, _, err := verifyReader(r, size, kr)
+	return manifest, err
+}
+
+// verifyReader verifies a spore read from r and returns its attestations
+func verifyReader(r io.ReaderAt, size int64, kr *Keyring) (*Manifest, []Attestation, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open spore file: %w", err)
 	}
 
-	if manifestData == nil {
-		return nil, fmt.Errorf("manifest.json not found in spore")
+	manifest, entries, err := readArchive(zipReader)
+	if err != nil {
+		return nil, nil, err
 	}
-	if binaryData == nil {
-		return nil, fmt.Errorf("binary not found in spore")
+	if err := verifyDeclared(manifest, kr); err != nil {
+		return nil, nil, err
 	}
 
-	// Parse manifest
-	var manifest Manifest
-	if err := json.Unmarshal(manifestData, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	// Hash the binary, or every per-platform binary
+	var hash []byte
+	if len(manifest.Binaries) == 0 {
+		hash, err = copyEntry(io.Discard, entries["binary"], manifest.binaryLimit())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, b := range manifest.Binaries {
+		binaryHash, err := copyEntry(io.Discard, entries[binaryEntryName(b.Platform)], b.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		if got := fmt.Sprintf("%x", binaryHash); got != b.SHA256 {
+			return nil, nil, fmt.Errorf("%s binary %w: expected %s, got %s", b.Platform, ErrHashMismatch, b.SHA256, got)
+		}
 	}
 
-	// Verify binary hash
-	hash := sha256.Sum256(binaryData)
-	expectedHash := fmt.Sprintf("%x", hash)
-	if manifest.BinarySHA256 != expectedHash {
-		return nil, fmt.Errorf("binary hash mismatch: expected %s, got %s", expectedHash, manifest.BinarySHA256)
+	// Hash every extra file
+	for _, f := range manifest.Files {
+		fileHash, err := copyEntry(io.Discard, entries[fileEntryName(f.Path)], f.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := f.check(fileHash); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	// Decode public key
-	pubKeyData, err := base64.StdEncoding.DecodeString(manifest.PublicKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	// Read every attestation
+	var atts []Attestation
+	for _, e := range manifest.Attestations {
+		a, err := readAttestation(manifest, e, entries[attestationEntryName(e.Name)])
+		if err != nil {
+			return nil, nil, err
+		}
+		atts = append(atts, a)
+	}
+
+	if err := verifyManifest(manifest, hash, kr); err != nil {
+		return nil, nil, err
 	}
 
-	// Decode signature
-	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	return manifest, atts, nil
+}
+
+// Extract extracts a spore to a destination directory, verifying it against
+// kr first. Spores with per-platform binaries extract the binary for the
+// host platform, or fail with a PlatformError.
+func Extract(sporePath, destDir string, kr *Keyring) (*Manifest, string, error) {
+	return ExtractWithKey(sporePath, destDir, kr, nil)
+}
+
+// ExtractWithKey extracts a spore like Extract, decrypting an encrypted
+// binary with nodeKey
+func ExtractWithKey(sporePath, destDir string, kr *Keyring, nodeKey *ecdh.PrivateKey) (*Manifest, string, error) {
+	file, err := os.Open(sporePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode signature: %w", err)
+		return nil, "", fmt.Errorf("failed to open spore file: %w", err)
 	}
+	defer file.Close()
 
-	// Create manifest without signature for verification
-	manifestCopy := manifest
-	manifestCopy.Signature = ""
-	manifestWithoutSig, err := json.Marshal(manifestCopy)
+	info, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal manifest without signature: %w", err)
+		return nil, "", fmt.Errorf("failed to stat spore file: %w", err)
 	}
 
-	// Create signing data: sha256(manifest_without_sig || binary_hash)
-	signingData := append(manifestWithoutSig, hash[:]...)
-	signingHash := sha256.Sum256(signingData)
+	return extractReader(file, info.Size(), destDir, kr, nodeKey)
+}
 
-	// Verify signature
-	if !ed25519.Verify(ed25519.PublicKey(pubKeyData), signingHash[:], signature) {
-		return nil, fmt.Errorf("signature verification failed")
-	}
+// ExtractReader extracts a spore read from r, hashing the binary and files
+// while they are written out. Nothing is left in destDir unless the spore
+// verifies.
+func ExtractReader(r io.ReaderAt, size int64, destDir string, kr *Keyring) (*Manifest, string, error) {
+	return extractReader(r, size, destDir, kr, nil)
+}
 
-	return &manifest, nil
+// ExtractReaderWithKey extracts a spore read from r like ExtractReader,
+// decrypting an encrypted binary with nodeKey
+func ExtractReaderWithKey(r io.ReaderAt, size int64, destDir string, kr *Keyring, nodeKey *ecdh.PrivateKey) (*Manifest, string, error) {
+	return extractReader(r, size, destDir, kr, nodeKey)
 }
 
-// Extract extracts a spore to a destination directory
-func Extract(sporePath, destDir string) (*Manifest, string, error) {
-	// Verify the spore first
-	manifest, err := Verify(sporePath)
+// extractReader extracts a spore read from r, decrypting its binary with
+// nodeKey if it is encrypted
+func extractReader(r io.ReaderAt, size int64, destDir string, kr *Keyring, nodeKey *ecdh.PrivateKey) (manifest *Manifest, binaryPath string, err error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open spore file: %w", err)
+	}
+
+	manifest, entries, err := readArchive(zipReader)
 	if err != nil {
 		return nil, "", fmt.Errorf("spore verification failed: %w", err)
 	}
 
-	// Open zip file
-	zipReader, err := zip.OpenReader(sporePath)
+	// The signed manifest declares every entry's hash and size, so a forged
+	// or untrusted one is refused before anything is written
+	if err := verifyDeclared(manifest, kr); err != nil {
+		return nil, "", fmt.Errorf("spore verification failed: %w", err)
+	}
+
+	// Pick the binary for this platform before writing anything
+	binaryName, binaryLimit, binaryWant, err := manifest.binaryFor(HostPlatform())
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to open spore file: %w", err)
+		return nil, "", err
+	}
+	var dataKey []byte
+	if manifest.Encryption != nil {
+		dataKey, err = manifest.Encryption.dataKey(nodeKey)
+		if err != nil {
+			return nil, "", err
+		}
 	}
-	defer zipReader.Close()
 
 	// Create destination directory
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return nil, "", fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	// Extract files
-	for _, file := range zipReader.File {
-		rc, err := file.Open()
+	// Remove everything written so far if any step below fails
+	var created []string
+	defer func() {
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to open file %s: %w", file.Name, err)
+			for i := len(created) - 1; i >= 0; i-- {
+				os.Remove(created[i])
+			}
 		}
+	}()
 
-		destPath := filepath.Join(destDir, file.Name)
-		destFile, err := os.Create(destPath)
+	// Stream the binary to a temporary name while hashing it
+	tmpFile, err := os.CreateTemp(destDir, ".binary-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create file for binary: %w", err)
+	}
+	created = append(created, tmpFile.Name())
+
+	var (
+		binaryWriter io.Writer = tmpFile
+		decrypter    *chunkWriter
+	)
+	if dataKey != nil {
+		decrypter, err = newDecryptWriter(tmpFile, dataKey, manifest.Encryption.ChunkSize)
 		if err != nil {
-			rc.Close()
-			return nil, "", fmt.Errorf("failed to create file %s: %w", destPath, err)
+			return nil, "", err
 		}
+		binaryWriter = decrypter
+	}
+	hash, err := copyEntry(binaryWriter, entries[binaryName], binaryLimit)
+	if decrypter != nil && err == nil {
+		err = decrypter.Close()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to extract binary: %w", err)
+	}
+	if decrypter != nil {
+		if got := fmt.Sprintf("%x", decrypter.plain.Sum(nil)); got != manifest.Encryption.PlaintextSHA256 {
+			return nil, "", fmt.Errorf("spore verification failed: decrypted binary %w: expected %s, got %s", ErrHashMismatch, manifest.Encryption.PlaintextSHA256, got)
+		}
+	}
+	if binaryWant != "" {
+		if got := fmt.Sprintf("%x", hash); got != binaryWant {
+			return nil, "", fmt.Errorf("spore verification failed: %s binary %w: expected %s, got %s", HostPlatform(), ErrHashMismatch, binaryWant, got)
+		}
+		// The manifest records per-platform hashes; none is appended to the signed data
+		hash = nil
+	}
 
-		_, err = io.Copy(destFile, rc)
-		rc.Close()
-		destFile.Close()
-
+	// Reproduce the file tree
+	for _, f := range manifest.Files {
+		paths, err := extractFile(destDir, f, entries[fileEntryName(f.Path)])
+		created = append(created, paths...)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to extract file %s: %w", file.Name, err)
+			return nil, "", err
 		}
+	}
 
-		// Make binary executable
-		if file.Name == "binary" {
-			if err := os.Chmod(destPath, 0755); err != nil {
-				return nil, "", fmt.Errorf("failed to make binary executable: %w", err)
-			}
-		}
+	// Attestations are not extracted, but must still match the manifest
+	if err := hashAttestations(manifest, entries); err != nil {
+		return nil, "", fmt.Errorf("spore verification failed: %w", err)
 	}
 
-	// Rename binary to match the command name
-	oldBinaryPath := filepath.Join(destDir, "binary")
-	newBinaryPath := filepath.Join(destDir, manifest.Command)
-	if err := os.Rename(oldBinaryPath, newBinaryPath); err != nil {
+	if err := verifyManifest(manifest, hash, kr); err != nil {
+		return nil, "", fmt.Errorf("spore verification failed: %w", err)
+	}
+
+	// Write manifest.json alongside the binary
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	manifestPath := filepath.Join(destDir, "manifest.json")
+	if err := os.WriteFile(manifestPath, manifestData, 0644); err != nil {
+		return nil, "", fmt.Errorf("failed to write manifest: %w", err)
+	}
+	created = append(created, manifestPath)
+
+	// Make binary executable and rename it to match the command name
+	if err := os.Chmod(tmpFile.Name(), 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to make binary executable: %w", err)
+	}
+	binaryPath = filepath.Join(destDir, manifest.Command)
+	if err := os.Rename(tmpFile.Name(), binaryPath); err != nil {
 		return nil, "", fmt.Errorf("failed to rename binary to %s: %w", manifest.Command, err)
 	}
 
-	return manifest, newBinaryPath, nil
+	return manifest, binaryPath, nil
+}
+
+// verifyManifest checks the binary hash, signatures and validity period of a
+// manifest
+func verifyManifest(m *Manifest, binaryHash []byte, kr *Keyring) error {
+	// Verify binary hash. Spores with per-platform binaries have neither a
+	// single binary hash nor a single binary.
+	expectedHash := fmt.Sprintf("%x", binaryHash)
+	if m.BinarySHA256 != expectedHash {
+		return fmt.Errorf("binary %w: expected %s, got %s", ErrHashMismatch, expectedHash, m.BinarySHA256)
+	}
+
+	// Verify all signatures and check signers against the keyring
+	if err := verifySignatures(m, binaryHash, kr); err != nil {
+		return err
+	}
+
+	// The signed validity period is only meaningful once the signature holds
+	return m.checkValidity(time.Now())
+}
+
+// verifyDeclared checks a manifest's signatures and validity period against
+// the binary hash it declares, before any entry is read. The entries must
+// still be hashed and checked with verifyManifest.
+func verifyDeclared(m *Manifest, kr *Keyring) error {
+	declared, err := hex.DecodeString(m.BinarySHA256)
+	if err != nil {
+		return fmt.Errorf("binary %w: invalid declared hash %q", ErrHashMismatch, m.BinarySHA256)
+	}
+	if err := verifySignatures(m, declared, kr); err != nil {
+		return err
+	}
+	return m.checkValidity(time.Now())
+}
+
+// checkValidity returns a ValidityError if now is outside the manifest's
+// validity period
+func (m *Manifest) checkValidity(now time.Time) error {
+	if (!m.NotBefore.IsZero() && now.Before(m.NotBefore)) || (!m.NotAfter.IsZero() && now.After(m.NotAfter)) {
+		return &ValidityError{App: m.Name, NotBefore: m.NotBefore, NotAfter: m.NotAfter, Now: now}
+	}
+	return nil
 }
@@ -1,10 +1,13 @@
 package spore
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPackAndVerify(t *testing.T) {
@@ -56,7 +59,7 @@ func TestPackAndVerify(t *testing.T) {
 	}
 
 	// Verify spore
-	verifiedManifest, err := Verify(sporePath)
+	verifiedManifest, err := Verify(sporePath, nil)
 	if err != nil {
 		t.Fatalf("Verify failed: %v", err)
 	}
@@ -129,7 +132,7 @@ func TestVerifyFailsWithWrongSignature(t *testing.T) {
 
 	// This test is a bit complex because we need to actually modify the spore file
 	// For now, let's test with a non-existent file
-	_, err = Verify("non-existent-file.spore")
+	_, err = Verify("non-existent-file.spore", nil)
 	if err == nil {
 		t.Error("Verify should have failed with non-existent file")
 	}
@@ -177,7 +180,7 @@ func TestExtract(t *testing.T) {
 
 	// Extract spore
 	extractDir := filepath.Join(tempDir, "extracted")
-	extractedManifest, extractedBinaryPath, err := Extract(sporePath, extractDir)
+	extractedManifest, extractedBinaryPath, err := Extract(sporePath, extractDir, nil)
 	if err != nil {
 		t.Fatalf("Extract failed: %v", err)
 	}
@@ -205,3 +208,125 @@ func TestExtract(t *testing.T) {
 		t.Error("Extracted binary should be executable")
 	}
 }
+
+func TestPackToAndVerifyReader(t *testing.T) {
+	// Generate key pair
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	manifest := Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+
+	// Pack into memory from a reader
+	var buf bytes.Buffer
+	payload := Payload{Binary: strings.NewReader("streamed binary content")}
+	packed, err := PackTo(&buf, payload, manifest, privKey)
+	if err != nil {
+		t.Fatalf("PackTo failed: %v", err)
+	}
+
+	verified, err := VerifyReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+	if err != nil {
+		t.Fatalf("VerifyReader failed: %v", err)
+	}
+	if verified.BinarySHA256 != packed.BinarySHA256 {
+		t.Errorf("Expected binary hash %s, got %s", packed.BinarySHA256, verified.BinarySHA256)
+	}
+
+	// Altering the signed manifest must fail verification
+	sporePath := filepath.Join(t.TempDir(), "test.spore")
+	if err := os.WriteFile(sporePath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write spore: %v", err)
+	}
+	tampered := *packed
+	tampered.Env = map[string]string{"INJECTED": "1"}
+	if err := rewriteManifest(sporePath, &tampered); err != nil {
+		t.Fatalf("rewriteManifest failed: %v", err)
+	}
+	data, err := os.ReadFile(sporePath)
+	if err != nil {
+		t.Fatalf("Failed to read spore: %v", err)
+	}
+	if _, err := VerifyReader(bytes.NewReader(data), int64(len(data)), nil); err == nil {
+		t.Error("VerifyReader should fail on a tampered spore")
+	}
+}
+
+func TestExtractLeavesNothingOnFailure(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sporePath := packTestSpore(t, privKey)
+
+	kr := &Keyring{}
+	if err := kr.Add("other", otherPub, nil); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	destDir := t.TempDir()
+	if _, _, err := Extract(sporePath, destDir, kr); err == nil {
+		t.Fatal("Extract should fail for an untrusted spore")
+	}
+
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatalf("Failed to read destination: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected empty destination after failed extract, found %d entries", len(entries))
+	}
+}
+
+func TestVerifyValidityPeriod(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		want      string
+	}{
+		{"unbounded", time.Time{}, time.Time{}, ""},
+		{"current", now.Add(-time.Hour), now.Add(time.Hour), ""},
+		{"expired", now.Add(-2 * time.Hour), now.Add(-time.Hour), ReasonExpired},
+		{"not yet valid", now.Add(time.Hour), time.Time{}, ReasonNotYetValid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := Manifest{
+				Name:      "test-app",
+				Version:   "v1.0.0",
+				Command:   "test-binary",
+				Nutrients: Nutrients{CPUMilli: 100, MemoryMB: 64},
+				NotBefore: tt.notBefore,
+				NotAfter:  tt.notAfter,
+			}
+			payload := Payload{Binary: strings.NewReader("test binary content")}
+			sporePath, _, err := PackPayload(payload, manifest, priv, t.TempDir())
+			if err != nil {
+				t.Fatalf("PackPayload failed: %v", err)
+			}
+
+			_, err = Verify(sporePath, nil)
+			if got := Reason(err); got != tt.want {
+				t.Errorf("Expected reason %q, got %q (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
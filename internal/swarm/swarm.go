@@ -0,0 +1,288 @@
+// Package swarm distributes spores between agents in content-addressed
+// chunks, so rolling a digest out to many nodes does not send every node to
+// the repository for the whole spore.
+package swarm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/karadia10/mycelium-mesh/internal/fabric"
+	"github.com/karadia10/mycelium-mesh/internal/repo"
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// Origin is the repository spores come from when no peer has a chunk: a
+// local Repo or a Remote one
+type Origin interface {
+	repo.Source
+	ChunkIndex(digest string) (*repo.ChunkIndex, error)
+	ReadChunk(digest string, c repo.Chunk) ([]byte, error)
+}
+
+// Swarm is a repo.Source that fetches spores chunk by chunk from peers that
+// advertise them on the fabric, falling back to the origin for chunks no
+// peer has or serves intact. Every chunk is checked against its hash before
+// it is kept or advertised, and the assembled spore against its digest.
+// Once a spore is assembled its chunks are dropped from the cache, and Swarm
+// serves them to other peers over HTTP out of the spore itself.
+type Swarm struct {
+	NodeID string
+	Fab    *fabric.Fabric
+	Origin Origin
+	Cache  repo.Store // assembled spores, and chunks of the one being fetched
+	Client *http.Client
+
+	mu   sync.Mutex // serializes fetches
+	held sync.Map   // chunk hash -> heldChunk, for chunks of assembled spores
+	mux  *http.ServeMux
+}
+
+// heldChunk locates a chunk inside an assembled spore in the cache
+type heldChunk struct {
+	digest string
+	chunk  repo.Chunk
+}
+
+// New creates a swarm member for a node, keeping what it fetches in cache
+func New(nodeID string, fab *fabric.Fabric, origin Origin, cache repo.Store) *Swarm {
+	s := &Swarm{
+		NodeID: nodeID,
+		Fab:    fab,
+		Origin: origin,
+		Cache:  cache,
+		Client: http.DefaultClient,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /chunks/{hash}", s.handleChunk)
+	return s
+}
+
+// Join registers the URL peers reach this node's ServeHTTP at and
+// advertises the chunks already in the cache, including those of spores
+// assembled before a restart
+func (s *Swarm) Join(url string) error {
+	objects, err := s.Cache.List()
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	for _, obj := range objects {
+		// An object the origin has no chunk index for is a chunk of a
+		// spore whose fetch was interrupted
+		index, err := s.Origin.ChunkIndex(obj.Digest)
+		if err != nil || index.Digest != obj.Digest || index.Size != obj.Size {
+			hashes = append(hashes, obj.Digest)
+			continue
+		}
+		hashes = append(hashes, s.hold(index)...)
+	}
+
+	s.Fab.RegisterPeer(fabric.Peer{NodeID: s.NodeID, URL: url})
+	s.Fab.AdvertiseChunks(s.NodeID, hashes...)
+	return nil
+}
+
+// Leave stops serving chunks to peers
+func (s *Swarm) Leave() {
+	s.Fab.RemovePeer(s.NodeID)
+}
+
+// Open returns a reader for a spore, fetching any chunks the cache lacks
+func (s *Swarm) Open(digest string) (repo.Blob, error) {
+	if err := repo.CheckDigest(digest); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if blob, err := s.Cache.Open(digest); err == nil {
+		return blob, nil
+	}
+
+	index, err := s.Origin.ChunkIndex(digest)
+	if err != nil {
+		return nil, err
+	}
+	if index.Digest != digest {
+		return nil, fmt.Errorf("chunk index is for %s, expected %s", index.Digest, digest)
+	}
+
+	// Fetch in random order, so nodes starting together ask the origin for
+	// different chunks and then trade them
+	fromPeers, fromOrigin := 0, 0
+	for _, i := range rand.Perm(len(index.Chunks)) {
+		c := index.Chunks[i]
+		if _, err := s.Cache.Stat(c.Hash); err == nil {
+			continue
+		}
+		fromPeer, err := s.fetchChunk(digest, c)
+		if err != nil {
+			return nil, err
+		}
+		if fromPeer {
+			fromPeers++
+		} else {
+			fromOrigin++
+		}
+		s.Fab.AdvertiseChunks(s.NodeID, c.Hash)
+	}
+
+	if err := s.assemble(index); err != nil {
+		return nil, err
+	}
+	if err := s.dropChunks(index); err != nil {
+		return nil, err
+	}
+	log.Printf("Node %s fetched spore %s: %d chunks from peers, %d from origin", s.NodeID, digest, fromPeers, fromOrigin)
+	return s.Cache.Open(digest)
+}
+
+// fetchChunk stores a chunk from the first peer that serves it intact, or
+// else from the origin
+func (s *Swarm) fetchChunk(digest string, c repo.Chunk) (fromPeer bool, err error) {
+	peers := s.Fab.ChunkPeers(c.Hash)
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, p := range peers {
+		if p.NodeID == s.NodeID {
+			continue
+		}
+		data, err := s.peerChunk(p, c)
+		if err == nil {
+			err = s.Cache.Put(c.Hash, bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Printf("Node %s rejected chunk %s from peer %s: %v", s.NodeID, c.Hash, p.NodeID, err)
+			continue
+		}
+		return true, nil
+	}
+
+	data, err := s.Origin.ReadChunk(digest, c)
+	if err != nil {
+		return false, err
+	}
+	return false, s.Cache.Put(c.Hash, bytes.NewReader(data))
+}
+
+// peerChunk downloads a chunk from a peer
+func (s *Swarm) peerChunk(p fabric.Peer, c repo.Chunk) ([]byte, error) {
+	resp, err := s.Client.Get(p.URL + "/chunks/" + url.PathEscape(c.Hash))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, c.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	return data, c.Check(data)
+}
+
+// assemble joins a spore's chunks into the cache, which checks the result
+// against the digest
+func (s *Swarm) assemble(index *repo.ChunkIndex) error {
+	readers := make([]io.Reader, 0, len(index.Chunks))
+	for _, c := range index.Chunks {
+		blob, err := s.Cache.Open(c.Hash)
+		if err != nil {
+			return err
+		}
+		defer blob.Close()
+		readers = append(readers, blob)
+	}
+	return s.Cache.Put(index.Digest, io.MultiReader(readers...))
+}
+
+// dropChunks removes an assembled spore's chunks from the cache, so a spore
+// is not kept twice, and serves them from the spore instead
+func (s *Swarm) dropChunks(index *repo.ChunkIndex) error {
+	s.hold(index)
+	for _, c := range index.Chunks {
+		if c.Hash == index.Digest {
+			continue // a one-chunk spore is its own chunk
+		}
+		if err := s.Cache.Delete(c.Hash); err != nil {
+			return fmt.Errorf("failed to drop chunk %s: %w", c.Hash, err)
+		}
+	}
+	return nil
+}
+
+// hold serves an assembled spore's chunks out of the spore, and returns
+// their hashes
+func (s *Swarm) hold(index *repo.ChunkIndex) []string {
+	hashes := make([]string, 0, len(index.Chunks))
+	for _, c := range index.Chunks {
+		s.held.Store(c.Hash, heldChunk{digest: index.Digest, chunk: c})
+		hashes = append(hashes, c.Hash)
+	}
+	return hashes
+}
+
+// openChunk returns a reader for a chunk this node holds, on its own or in
+// an assembled spore
+func (s *Swarm) openChunk(hash string) (io.ReadCloser, int64, error) {
+	if blob, err := s.Cache.Open(hash); err == nil {
+		return blob, blob.Size(), nil
+	}
+	v, ok := s.held.Load(hash)
+	if !ok {
+		return nil, 0, fs.ErrNotExist
+	}
+	h := v.(heldChunk)
+	blob, err := s.Cache.Open(h.digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	section := io.NewSectionReader(blob, h.chunk.Offset, h.chunk.Size)
+	return struct {
+		io.Reader
+		io.Closer
+	}{section, blob}, h.chunk.Size, nil
+}
+
+// Revocations returns the origin's revocation list
+func (s *Swarm) Revocations() (*spore.RevocationList, error) {
+	return s.Origin.Revocations()
+}
+
+// Lease takes a lease on a digest at the origin
+func (s *Swarm) Lease(digest, holder string) error {
+	return s.Origin.Lease(digest, holder)
+}
+
+// Release drops a lease on a digest at the origin
+func (s *Swarm) Release(digest, holder string) error {
+	return s.Origin.Release(digest, holder)
+}
+
+// ServeHTTP serves the chunks this node holds to peers
+func (s *Swarm) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *Swarm) handleChunk(w http.ResponseWriter, req *http.Request) {
+	chunk, size, err := s.openChunk(req.PathValue("hash"))
+	if err != nil {
+		http.Error(w, "chunk not found", http.StatusNotFound)
+		return
+	}
+	defer chunk.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+	io.Copy(w, chunk)
+}
@@ -0,0 +1,208 @@
+package swarm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/karadia10/mycelium-mesh/internal/fabric"
+	"github.com/karadia10/mycelium-mesh/internal/repo"
+	"github.com/karadia10/mycelium-mesh/internal/spore"
+)
+
+// countingOrigin counts the chunk bytes the origin serves
+type countingOrigin struct {
+	*repo.Repo
+	chunks atomic.Int64
+	bytes  atomic.Int64
+}
+
+func (o *countingOrigin) ReadChunk(digest string, c repo.Chunk) ([]byte, error) {
+	data, err := o.Repo.ReadChunk(digest, c)
+	if err == nil {
+		o.chunks.Add(1)
+		o.bytes.Add(int64(len(data)))
+	}
+	return data, err
+}
+
+// newOrigin stores a spore with an incompressible binary of binarySize
+// bytes in an in-memory repo split into chunkSize chunks
+func newOrigin(t *testing.T, binarySize int, chunkSize int64) (*countingOrigin, string, []byte) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	binary := make([]byte, binarySize)
+	rand.Read(binary)
+
+	manifest := spore.Manifest{
+		Name:      "test-app",
+		Version:   "v1.0.0",
+		Command:   "test-binary",
+		Nutrients: spore.Nutrients{CPUMilli: 100, MemoryMB: 64},
+	}
+	var buf bytes.Buffer
+	if _, err := spore.PackTo(&buf, spore.Payload{Binary: bytes.NewReader(binary)}, manifest, priv); err != nil {
+		t.Fatalf("PackTo failed: %v", err)
+	}
+	data := buf.Bytes()
+	digest := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	r := repo.New(t.TempDir(), repo.NewMemoryStore())
+	r.ChunkSize = chunkSize
+	if err := r.PutReader(bytes.NewReader(data), digest); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	return &countingOrigin{Repo: r}, digest, data
+}
+
+// newNode starts a swarm member serving chunks over HTTP
+func newNode(t *testing.T, id string, fab *fabric.Fabric, origin Origin) *Swarm {
+	t.Helper()
+	return startNode(t, id, fab, origin, repo.NewMemoryStore())
+}
+
+// startNode starts a swarm member on an existing cache, as a node does when
+// its agent restarts
+func startNode(t *testing.T, id string, fab *fabric.Fabric, origin Origin, cache repo.Store) *Swarm {
+	t.Helper()
+
+	s := New(id, fab, origin, cache)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	if err := s.Join(srv.URL); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	return s
+}
+
+// checkSpore fails unless a node's copy of a spore is exactly want
+func checkSpore(t *testing.T, s *Swarm, digest string, want []byte) {
+	t.Helper()
+
+	blob, err := s.Open(digest)
+	if err != nil {
+		t.Errorf("Node %s: Open failed: %v", s.NodeID, err)
+		return
+	}
+	defer blob.Close()
+
+	if got, err := io.ReadAll(blob); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Node %s: spore differs from the origin's (%v)", s.NodeID, err)
+	}
+}
+
+func TestSwarmSimulation(t *testing.T) {
+	const nodes = 40
+	origin, digest, data := newOrigin(t, 256<<10, 4<<10)
+	index, err := origin.ChunkIndex(digest)
+	if err != nil {
+		t.Fatalf("ChunkIndex failed: %v", err)
+	}
+
+	fab := fabric.New()
+	swarms := make([]*Swarm, nodes)
+	for i := range swarms {
+		swarms[i] = newNode(t, fmt.Sprintf("node-%d", i+1), fab, origin)
+	}
+
+	// Every node starts fetching the same digest at once, as in a rollout
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, s := range swarms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			checkSpore(t, s, digest, data)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// Chunks are dropped once the spore is assembled
+	for _, s := range swarms {
+		if objects, _ := s.Cache.List(); len(objects) != 1 || objects[0].Digest != digest {
+			t.Errorf("Node %s: expected only the spore in its cache, got %d objects", s.NodeID, len(objects))
+		}
+	}
+
+	naive := int64(nodes) * int64(len(data))
+	t.Logf("%d nodes, %d chunks: origin served %d chunks, %d of %d bytes (%.1f%%)",
+		nodes, len(index.Chunks), origin.chunks.Load(), origin.bytes.Load(), naive,
+		100*float64(origin.bytes.Load())/float64(naive))
+	if origin.bytes.Load() < int64(len(data)) {
+		t.Errorf("Origin served %d bytes, less than the spore itself", origin.bytes.Load())
+	}
+	if origin.bytes.Load() > naive/4 {
+		t.Errorf("Origin served %d bytes, expected peers to carry most of the %d", origin.bytes.Load(), naive)
+	}
+
+	// A node that joins later gets everything from peers, which serve the
+	// chunks out of their assembled spores
+	before := origin.chunks.Load()
+	checkSpore(t, newNode(t, "late", fab, origin), digest, data)
+	if served := origin.chunks.Load() - before; served != 0 {
+		t.Errorf("Origin served %d chunks to a late node", served)
+	}
+}
+
+func TestSwarmRejectsBadPeer(t *testing.T) {
+	origin, digest, data := newOrigin(t, 64<<10, 4<<10)
+	index, err := origin.ChunkIndex(digest)
+	if err != nil {
+		t.Fatalf("ChunkIndex failed: %v", err)
+	}
+
+	// A peer that claims every chunk and serves garbage
+	var served atomic.Int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served.Add(1)
+		w.Write(bytes.Repeat([]byte("x"), 4<<10))
+	}))
+	defer bad.Close()
+
+	fab := fabric.New()
+	fab.RegisterPeer(fabric.Peer{NodeID: "bad", URL: bad.URL})
+	for _, c := range index.Chunks {
+		fab.AdvertiseChunks("bad", c.Hash)
+	}
+
+	s := newNode(t, "node-1", fab, origin)
+	checkSpore(t, s, digest, data)
+	if served.Load() != int64(len(index.Chunks)) {
+		t.Errorf("Bad peer was asked for %d chunks, expected %d", served.Load(), len(index.Chunks))
+	}
+	if origin.chunks.Load() != int64(len(index.Chunks)) {
+		t.Errorf("Origin served %d chunks, expected all %d", origin.chunks.Load(), len(index.Chunks))
+	}
+}
+
+func TestSwarmRestart(t *testing.T) {
+	origin, digest, data := newOrigin(t, 64<<10, 4<<10)
+
+	fab := fabric.New()
+	cache := repo.NewMemoryStore()
+	first := startNode(t, "node-1", fab, origin, cache)
+	checkSpore(t, first, digest, data)
+	fetched := origin.chunks.Load()
+
+	// The node restarts with its cache and serves the spore's chunks again
+	first.Leave()
+	startNode(t, "node-1", fab, origin, cache)
+	checkSpore(t, newNode(t, "node-2", fab, origin), digest, data)
+	if served := origin.chunks.Load() - fetched; served != 0 {
+		t.Errorf("Origin served %d chunks with a restarted peer holding the spore", served)
+	}
+}